@@ -1,20 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/infrastructure/metrics"
 	"log-flow/internal/infrastructure/server"
 	"log-flow/internal/utils/helper"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2/log"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
 	helper.SetFiberLogLevel(config.Env.AppSettings.LogLevel)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	app := server.InitializeServer()
-	err := app.Listen(fmt.Sprintf(":%s", config.Env.AppSettings.Port))
-	if err != nil {
+	metrics.Serve(config.Env.AppSettings.MetricsPort)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(fmt.Sprintf(":%s", config.Env.AppSettings.Port))
+	}()
+
+	select {
+	case err := <-listenErr:
 		log.Fatal("Couldn't start the server. Error: " + err.Error())
+	case <-ctx.Done():
+	}
+	stop() //a second signal kills the process right away
+
+	shutdownTimeout := time.Duration(config.Env.AppSettings.ShutdownTimeoutSeconds) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
 	}
+	log.Infof("Shutting down, waiting up to %v for jobs in progress...", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	app.Shutdown(shutdownCtx)
+	log.Info("Server stopped")
 }
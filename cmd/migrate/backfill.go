@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/infrastructure/storage"
+	"log-flow/internal/workers"
+
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+)
+
+// backfillUniqueIPs persists the distinct IPs of reports saved before they were persisted, and their sketch,
+// by rereading their log files, so that they count once in aggregated stats.
+// Reports whose file can't be read anymore are skipped, and keep being summed.
+func backfillUniqueIPs(db *gorm.DB) error {
+	reports, err := models.GetLogReportsWithoutIPData(db)
+	if err != nil {
+		return fmt.Errorf("Error getting reports to backfill: %v", err)
+	}
+
+	fileStore := storage.NewSupabaseStorage(config.Env.SupaBaseURL, config.Env.SupaBaseKey, config.Env.SupaBaseBucket)
+
+	backfilled := 0
+	for _, report := range reports {
+		if err := backfillReport(db, fileStore, report); err != nil {
+			log.Errorf("Skipping report %s: %v", report.ID, err)
+			continue
+		}
+		backfilled++
+	}
+
+	fmt.Printf("Backfilled unique IPs of %d/%d reports\n", backfilled, len(reports))
+	return nil
+}
+
+func backfillReport(db *gorm.DB, fileStore storage.Storage, report models.LogReportWithoutIPData) error {
+	logStream, err := fileStore.StreamLogs(report.FileURL)
+	if err != nil {
+		return fmt.Errorf("failed to stream logs: %v", err)
+	}
+	defer logStream.Close()
+
+	ipData, err := workers.PersistUniqueIPs(db, report.JobID, logStream)
+	if err != nil {
+		return fmt.Errorf("failed to persist IPs: %v", err)
+	}
+
+	return models.SetLogReportIPData(db, report.ID, ipData)
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log-flow/internal/domain/models"
 	"log-flow/internal/infrastructure/db"
+	"strings"
 
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 func main() {
+	backfill := flag.Bool("backfill-unique-ips", false, "reprocess the log files of reports saved without their distinct IPs")
+	admin := flag.String("admin", "", "make the user of the ID (or email, for the local auth provider) admin, for the first admin of a deployment")
+	flag.Parse()
+
 	fmt.Println("Hello, World!")
 
 	db := db.GetDB()
@@ -18,12 +25,84 @@ func main() {
 		models.Job{},
 		models.LogReport{},
 		models.TrackedKeywordsCount{},
+		models.JobIP{},
+		models.JobCheckpoint{},
+		models.WsTicket{},
+		models.WorkerPool{},
+		models.JobShare{},
+		models.Organization{},
+		models.OrgMember{},
+		models.OrgKeyword{},
+		models.APIKey{},
+		models.User{},
+		models.PasswordResetToken{},
+		models.RevokedToken{},
+		models.UserRevocation{},
+		models.UserRole{},
+		models.AuditEntry{},
+		models.Quota{},
 	})
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
+	if err := protectAuditLog(db); err != nil {
+		log.Fatalf(err.Error())
+	}
 
 	fmt.Println("Logs table migrated successfully")
+
+	if *backfill {
+		if err := backfillUniqueIPs(db); err != nil {
+			log.Fatalf(err.Error())
+		}
+	}
+
+	if *admin != "" {
+		if err := makeAdmin(db, *admin); err != nil {
+			log.Fatalf(err.Error())
+		}
+	}
+}
+
+// protectAuditLog makes the database reject changes to the entries of the audit log, but appending ones
+func protectAuditLog(db *gorm.DB) error {
+	table := models.AuditEntry{}.TableName()
+	for _, statement := range []string{
+		`CREATE OR REPLACE FUNCTION reject_audit_log_changes() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'the audit log is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS audit_log_append_only ON " + table,
+		"CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON " + table + " FOR EACH ROW EXECUTE FUNCTION reject_audit_log_changes()",
+		"DROP TRIGGER IF EXISTS audit_log_no_truncate ON " + table,
+		"CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON " + table + " FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_log_changes()",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("Error protecting table %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+func makeAdmin(db *gorm.DB, user string) error {
+	userID, err := uuid.Parse(user)
+	if err != nil {
+		account, err := models.GetUserByEmail(db, strings.ToLower(user))
+		if err != nil {
+			return fmt.Errorf("Error getting user %s: %v", user, err)
+		}
+		if account == nil {
+			return fmt.Errorf("No user with the ID or email %s", user)
+		}
+		userID = account.ID
+	}
+
+	if err := models.SetSystemRole(db, userID, models.SystemRoleAdmin); err != nil {
+		return fmt.Errorf("Error making user %s admin: %v", user, err)
+	}
+	fmt.Println("User", userID, "is admin")
+	return nil
 }
 
 func migrateTables(db *gorm.DB, tables []models.DbTablesWithName) error {
@@ -0,0 +1,64 @@
+// Command worker runs only the processing of uploaded logs, for workers to be scaled apart from the API.
+// The API is then to be run with DISABLE_EMBEDDED_WORKERS set.
+package main
+
+import (
+	"context"
+	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/infrastructure/db"
+	"log-flow/internal/infrastructure/metrics"
+	"log-flow/internal/infrastructure/queue"
+	"log-flow/internal/infrastructure/storage"
+	"log-flow/internal/utils/helper"
+	"log-flow/internal/workers"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+func main() {
+	helper.SetFiberLogLevel(config.Env.AppSettings.LogLevel)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	//dependencies
+	database := db.GetDB()
+	fileStore := storage.NewSupabaseStorage(config.Env.SupaBaseURL, config.Env.SupaBaseKey, config.Env.SupaBaseBucket)
+	logFileQueue := queue.InitLogQueue()
+	liveProgressMessenger := queue.InitLiveStatusQueue()
+
+	logWorkers := workers.NewWorkers(database, fileStore, logFileQueue, liveProgressMessenger, config.Env.LogConfig.Keywords)
+	logWorkers.Start()
+	metrics.Serve(config.Env.AppSettings.MetricsPort)
+
+	<-ctx.Done()
+	stop() //a second signal kills the process right away
+
+	shutdownTimeout := time.Duration(config.Env.AppSettings.ShutdownTimeoutSeconds) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	log.Infof("Shutting down, waiting up to %v for jobs in progress...", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := logWorkers.Shutdown(shutdownCtx); err != nil {
+		log.Warnf("Workers shutdown: %v", err)
+	}
+
+	if err := logFileQueue.Close(); err != nil {
+		log.Errorf("Error closing log queue: %v", err)
+	}
+	if err := liveProgressMessenger.Close(); err != nil {
+		log.Errorf("Error closing live status queue: %v", err)
+	}
+	if sqlDB, err := database.DB(); err == nil {
+		sqlDB.Close()
+	}
+	log.Info("Workers stopped")
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log-flow/internal/api/middleware"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/infrastructure/queue"
+	"log-flow/internal/utils/validation"
+	"log-flow/internal/workers"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Failed jobs replayed at once by default, and at most
+const (
+	defaultReplayLimit = 100
+	maxReplayLimit     = 1000
+)
+
+func (h *HttpHandler) GetQueueStatus(c *fiber.Ctx) response.HandledResponse {
+	status, err := h.logQueue.GetQueueStatus()
+	if err != nil {
+		return response.InternalServerErrorResponse(fmt.Errorf("Failed to get queue status. %v", err))
+	}
+
+	// Workers of all processes, as saved by them periodically
+	workerPools, err := models.GetActiveWorkerPools(h.db, time.Now().Add(-workers.WorkerPoolStaleAfter))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get worker pools. %v", err))
+	}
+
+	return response.SuccessResponse(200, response.Success, map[string]any{
+		"queueStatus": status,
+		"workerPools": workerPools,
+	})
+}
+
+// ReplayFailedJobs requeues the jobs of the failed queue, up to the `limit` query param, with their attempts reset.
+func (h *HttpHandler) ReplayFailedJobs(c *fiber.Ctx) response.HandledResponse {
+	limit, errResponse := parseLimitQuery(c, defaultReplayLimit, maxReplayLimit)
+	if errResponse != nil {
+		return errResponse
+	}
+
+	// Attempts are reset once the job is requeued, not to leave it pending if requeuing fails
+	var jobIDs []string
+	var resetErr error
+	replayed, err := h.logQueue.ReplayFailed(limit, func(logMsg queue.LogMessage) error {
+		jobIDs = append(jobIDs, logMsg.JobID)
+		resetErr = models.ResetJobAttempts(h.db, logMsg.JobID)
+		return resetErr
+	})
+	for i, jobID := range jobIDs { //all reset, but the last one if resetting it failed
+		if resetErr != nil && i == len(jobIDs)-1 {
+			middleware.RecordAudit(h.db, c, models.AuditJobReprocess, models.AuditTargetJob, jobID, models.AuditFailure, fiber.StatusInternalServerError)
+		} else {
+			middleware.RecordAudit(h.db, c, models.AuditJobReprocess, models.AuditTargetJob, jobID, models.AuditSuccess, fiber.StatusOK)
+		}
+	}
+	if err != nil {
+		return response.InternalServerErrorResponse(fmt.Errorf("Failed to replay failed jobs, %d replayed. %v", replayed, err))
+	}
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, map[string]any{"replayed": replayed})
+}
+
+// FetchSystemStats aggregates the reports of all users and organizations, between the optional `from` and `to` query params,
+// with the jobs by state.
+func (h *HttpHandler) FetchSystemStats(c *fiber.Ctx) response.HandledResponse {
+	from, to, errResponse := parseDateRangeQuery(c)
+	if errResponse != nil {
+		return errResponse
+	}
+	filter := models.ReportsFilter{AllJobs: true, From: from, To: to}
+
+	reports, err := models.GetWholeLogReportsAggregate(h.db, uuid.Nil, filter)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get whole log reports aggregate. %v", err))
+	}
+	jobs, err := models.GetJobCounts(h.db, time.Now())
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to count jobs. %v", err))
+	}
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, map[string]any{
+		"reports": reports,
+		"jobs":    jobs,
+	})
+}
+
+func (h *HttpHandler) ListUsers(c *fiber.Ctx) response.HandledResponse {
+	users, err := models.GetAdminUsers(h.db)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get users. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, users)
+}
+
+// SetUserRole sets the system role of a user, who doesn't need an account of the local auth provider.
+func (h *HttpHandler) SetUserRole(c *fiber.Ctx) response.HandledResponse {
+	userID, err := uuid.Parse(c.Params("userID"))
+	if err != nil {
+		return response.InvalidURLParamResponse("userID", err)
+	}
+	req := new(struct {
+		Role string `json:"role" validate:"required"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+	if !models.IsValidSystemRole(req.Role) {
+		return response.ErrorResponse(fiber.StatusBadRequest, "INVALID_ROLE", fmt.Errorf("Invalid role %q, expected %s or %s",
+			req.Role, models.SystemRoleAdmin, models.SystemRoleUser))
+	}
+
+	if err := models.SetSystemRole(h.db, userID, req.Role); err != nil {
+		return userRoleErrorResponse(err)
+	}
+	h.adminAccess.Invalidate(userID)
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, map[string]any{
+		"userID": userID,
+		"role":   req.Role,
+	})
+}
+
+// DeleteUser deletes the account of the user with the local auth provider, their role, API keys, org memberships
+// and job shares, and revokes their tokens. Their jobs are kept.
+func (h *HttpHandler) DeleteUser(c *fiber.Ctx) response.HandledResponse {
+	userID, err := uuid.Parse(c.Params("userID"))
+	if err != nil {
+		return response.InvalidURLParamResponse("userID", err)
+	}
+
+	orgs, err := models.GetUserOrganizations(h.db, userID)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get organizations of user. %v", err))
+	}
+	sharedJobIDs, err := models.GetJobIDsSharedWith(h.db, userID)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get jobs shared with user. %v", err))
+	}
+	deleted, err := models.DeleteUser(h.db, userID)
+	if err != nil {
+		return userRoleErrorResponse(err)
+	}
+	if !deleted {
+		return response.NotFoundResponse("user")
+	}
+	h.adminAccess.Invalidate(userID)
+	for _, org := range orgs {
+		h.orgAccess.Invalidate(org.ID)
+	}
+	for _, jobID := range sharedJobIDs {
+		h.jobAccess.Invalidate(jobID)
+	}
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, nil)
+}
+
+// parseLimitQuery returns the `limit` query param, defaultLimit if not given.
+func parseLimitQuery(c *fiber.Ctx, defaultLimit, maxLimit int) (int, response.HandledResponse) {
+	value := c.Query("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, response.ErrorResponse(fiber.StatusBadRequest, "INVALID_LIMIT", fmt.Errorf("Invalid limit %q, expected 1 to %d", value, maxLimit))
+	}
+	return limit, nil
+}
+
+func userRoleErrorResponse(err error) response.HandledResponse {
+	if errors.Is(err, models.ErrLastAdmin) {
+		return response.ErrorResponse(fiber.StatusConflict, "LAST_ADMIN", fmt.Errorf("There must be an admin left. Make another user admin first."))
+	}
+	if errors.Is(err, models.ErrLastOwner) {
+		return response.ErrorResponse(fiber.StatusConflict, "LAST_OWNER", fmt.Errorf("The user is the last owner of an organization. Make another member owner first."))
+	}
+	return response.DBErrorResponse(fmt.Errorf("Failed to save user. %v", err))
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"log-flow/internal/utils/validation"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Characters of a key kept to recognize it by, after the prefix
+const apiKeyVisibleChars = 6
+
+// CreateAPIKey creates an API key in the workspace of the request: a personal key, or a key of the organization.
+// The key is returned only once, as only its hash is stored.
+func (h *HttpHandler) CreateAPIKey(c *fiber.Ctx) response.HandledResponse {
+	req := new(struct {
+		Name          string   `json:"name" validate:"required,max=100"`
+		Scopes        []string `json:"scopes" validate:"required,min=1,dive,required"`
+		ExpiresInDays int      `json:"expiresInDays" validate:"min=0,max=3650"` //0 for a key which doesn't expire
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !models.IsValidScope(scope) {
+			return response.ErrorResponse(fiber.StatusBadRequest, "INVALID_SCOPE", fmt.Errorf("Invalid scope %q, expected %s, %s or %s",
+				scope, models.ScopeUpload, models.ScopeReadStats, models.ScopeAdmin))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return response.InternalServerErrorResponse(fmt.Errorf("Failed to generate API key. %v", err))
+	}
+	key := models.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiKey := models.APIKey{
+		UserID:  locals.GetUserID(c),
+		Name:    strings.TrimSpace(req.Name),
+		Prefix:  key[:len(models.APIKeyPrefix)+apiKeyVisibleChars],
+		KeyHash: models.HashAPIKey(key),
+		Scopes:  strings.Join(scopes, ","),
+	}
+	if orgID := locals.GetOrgID(c); orgID != uuid.Nil {
+		apiKey.OrgID = &orgID
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := apiKey.Create(h.db); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to save API key. %v", err))
+	}
+	locals.SetAuditTarget(c, apiKey.ID.String())
+
+	return response.SuccessResponse(fiber.StatusCreated, response.Created, map[string]any{
+		"apiKey": apiKey,
+		"key":    key, //not retrievable later
+	})
+}
+
+// ListAPIKeys lists the keys of the workspace of the request: the personal keys of the user, or the keys of the organization.
+func (h *HttpHandler) ListAPIKeys(c *fiber.Ctx) response.HandledResponse {
+	keys, err := models.GetAPIKeys(h.db, locals.GetUserID(c), locals.GetOrgID(c))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get API keys. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, keys)
+}
+
+// RevokeAPIKey revokes a personal key of the user, or a key of an organization the user is an admin of.
+// Revoked keys are rejected right away.
+func (h *HttpHandler) RevokeAPIKey(c *fiber.Ctx) response.HandledResponse {
+	keyID, err := uuid.Parse(c.Params("keyID"))
+	if err != nil {
+		return response.InvalidURLParamResponse("keyID", err)
+	}
+
+	apiKey, err := models.GetAPIKeyByID(h.db, keyID.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return response.NotFoundResponse("API key")
+	}
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get API key. %v", err))
+	}
+	canRevoke, err := h.canManageAPIKey(c, apiKey)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get organization members. %v", err))
+	}
+	if !canRevoke { //not disclosing the keys of others
+		return response.NotFoundResponse("API key")
+	}
+
+	if err := models.RevokeAPIKey(h.db, apiKey.ID, time.Now()); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to revoke API key. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, nil)
+}
+
+func (h *HttpHandler) canManageAPIKey(c *fiber.Ctx, apiKey *models.APIKey) (bool, error) {
+	_, requestKeyOrgID, _ := locals.GetAPIKey(c)
+	if apiKey.OrgID == nil {
+		return requestKeyOrgID == uuid.Nil && apiKey.UserID == locals.GetUserID(c), nil
+	}
+	if requestKeyOrgID != uuid.Nil && requestKeyOrgID != *apiKey.OrgID {
+		return false, nil
+	}
+	role, err := h.orgAccess.RoleOf(*apiKey.OrgID, locals.GetUserID(c))
+	return models.RoleAtLeast(role, models.RoleAdmin), err
+}
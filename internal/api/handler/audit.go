@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+)
+
+// Audit entries listed at once by default, and at most. Exports get all the entries, up to maxAuditExport,
+// streamed auditExportBatch entries at a time.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	maxAuditExport    = 100000
+	auditExportBatch  = 1000
+)
+
+var auditCSVHeader = []string{"id", "created_at", "actor_id", "api_key_id", "action", "target_type", "target_id", "result", "status", "ip", "user_agent"}
+
+// ListAuditLog lists the entries of the audit log, the latest first, filtered by the `actor`, `action`, `target` and `result`
+// query params, and the `from` and `to` dates. Pages follow with the `before` query param, set to the last ID of a page.
+// With `format=csv`, the entries are exported as a CSV file.
+func (h *HttpHandler) ListAuditLog(c *fiber.Ctx) response.HandledResponse {
+	from, to, errResponse := parseDateRangeQuery(c)
+	if errResponse != nil {
+		return errResponse
+	}
+	filter := models.AuditFilter{
+		Action:   c.Query("action"),
+		TargetID: c.Query("target"),
+		Result:   c.Query("result"),
+		From:     from,
+		To:       to,
+	}
+	if actor := c.Query("actor"); actor != "" {
+		actorID, err := uuid.Parse(actor)
+		if err != nil {
+			return response.ErrorResponse(fiber.StatusBadRequest, response.WrongInput, fmt.Errorf("Invalid 'actor'. %v", err))
+		}
+		filter.ActorID = actorID
+	}
+	if before := c.Query("before"); before != "" {
+		beforeID, err := strconv.ParseInt(before, 10, 64)
+		if err != nil || beforeID < 1 {
+			return response.ErrorResponse(fiber.StatusBadRequest, response.WrongInput, fmt.Errorf("Invalid 'before', expected an entry ID"))
+		}
+		filter.BeforeID = beforeID
+	}
+
+	export := c.Query("format") == "csv"
+	if export {
+		filter.Limit, errResponse = parseLimitQuery(c, maxAuditExport, maxAuditExport)
+	} else {
+		filter.Limit, errResponse = parseLimitQuery(c, defaultAuditLimit, maxAuditLimit)
+	}
+	if errResponse != nil {
+		return errResponse
+	}
+
+	if export {
+		return &csvResponse{
+			filename: fmt.Sprintf("audit-%s.csv", time.Now().UTC().Format("20060102T150405Z")),
+			rows: func(w *csv.Writer) error {
+				if err := w.Write(auditCSVHeader); err != nil {
+					return err
+				}
+				return models.EachAuditEntry(h.db, filter, auditExportBatch, func(entry models.AuditEntry) error {
+					return w.Write(auditCSVRow(entry))
+				})
+			},
+		}
+	}
+
+	entries, err := models.GetAuditEntries(h.db, filter)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get audit log. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, entries)
+}
+
+func auditCSVRow(entry models.AuditEntry) []string {
+	optionalID := func(id *uuid.UUID) string {
+		if id == nil {
+			return ""
+		}
+		return id.String()
+	}
+	return []string{
+		strconv.FormatInt(entry.ID, 10),
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		optionalID(entry.ActorID),
+		optionalID(entry.APIKeyID),
+		entry.Action,
+		entry.TargetType,
+		csvCell(entry.TargetID),
+		entry.Result,
+		strconv.Itoa(entry.Status),
+		csvCell(entry.IP),
+		csvCell(entry.UserAgent),
+	}
+}
+
+// csvCell neutralizes values spreadsheets would take for formulas, as values sent by clients are exported as is otherwise.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// csvResponse is a CSV file download, streamed as its rows are written. Once streaming, failing can only cut the file short.
+type csvResponse struct {
+	filename string
+	rows     func(w *csv.Writer) error
+}
+
+func (cr *csvResponse) WriteToJSON(c *fiber.Ctx) error {
+	c.Attachment(cr.filename)
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		csvWriter := csv.NewWriter(w)
+		err := cr.rows(csvWriter)
+		csvWriter.Flush()
+		if err == nil {
+			err = csvWriter.Error()
+		}
+		if err != nil {
+			log.Errorf("Failed to export %s: %v", cr.filename, err)
+		}
+	})
+	return nil
+}
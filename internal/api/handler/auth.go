@@ -1,50 +1,145 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"log-flow/internal/domain/models"
 	"log-flow/internal/domain/response"
+	"log-flow/internal/infrastructure/auth"
+	"log-flow/internal/utils/locals"
 	"log-flow/internal/utils/validation"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/supabase-community/gotrue-go/types"
 )
 
 func (h *HttpHandler) Login(c *fiber.Ctx) response.HandledResponse {
 	req := new(struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required"`
+		Password string `json:"password" validate:"required"`
 	})
 	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
 		return errResponse
 	}
 
-	user, err := h.supabaseAuth.SignInWithEmailPassword(
-		req.Email,
-		req.Password,
-	)
+	session, err := h.authProvider.Login(req.Email, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		return response.ErrorResponse(fiber.StatusUnauthorized, "INVALID_CREDENTIALS", err)
+	}
 	if err != nil {
 		return response.ErrorResponse(fiber.StatusInternalServerError, "LOGIN_FAILED", fmt.Errorf("Failed to login. %v", err))
 	}
 
-	return response.SuccessResponse(fiber.StatusOK, "LOGIN_SUCCESS", user)
+	return response.SuccessResponse(fiber.StatusOK, "LOGIN_SUCCESS", session)
 }
 
 func (h *HttpHandler) Register(c *fiber.Ctx) response.HandledResponse {
 	req := new(struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required,min=8,max=72"` //bcrypt uses the first 72 bytes only
 	})
 	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
 		return errResponse
 	}
 
-	user, err := h.supabaseAuth.Signup(types.SignupRequest{
-		Email:    req.Email,
-		Password: req.Password,
-	})
+	session, err := h.authProvider.Register(req.Email, req.Password)
+	if errors.Is(err, auth.ErrEmailTaken) {
+		return response.ErrorResponse(fiber.StatusConflict, "EMAIL_TAKEN", err)
+	}
 	if err != nil {
 		return response.ErrorResponse(fiber.StatusInternalServerError, "SIGNUP_FAILED", fmt.Errorf("Failed to signup. %v", err))
 	}
 
-	return response.SuccessResponse(fiber.StatusOK, "SIGNUP_SUCCESS", user)
+	return response.SuccessResponse(fiber.StatusOK, "SIGNUP_SUCCESS", session)
+}
+
+// RequestPasswordReset sends a reset token to the user of the email. It succeeds whether the email is registered or not.
+func (h *HttpHandler) RequestPasswordReset(c *fiber.Ctx) response.HandledResponse {
+	req := new(struct {
+		Email string `json:"email" validate:"required,email"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+
+	if err := h.authProvider.RequestPasswordReset(req.Email); err != nil {
+		return response.ErrorResponse(fiber.StatusInternalServerError, "PASSWORD_RESET_FAILED", fmt.Errorf("Failed to request password reset. %v", err))
+	}
+
+	return response.SuccessResponse(fiber.StatusAccepted, "PASSWORD_RESET_REQUESTED", nil)
+}
+
+// ResetPassword sets a new password with a reset token.
+func (h *HttpHandler) ResetPassword(c *fiber.Ctx) response.HandledResponse {
+	req := new(struct {
+		Email    string `json:"email" validate:"required,email"`
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8,max=72"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+
+	err := h.authProvider.ResetPassword(req.Email, req.Token, req.Password)
+	if errors.Is(err, auth.ErrInvalidResetToken) {
+		return response.ErrorResponse(fiber.StatusBadRequest, "INVALID_RESET_TOKEN", err)
+	}
+	if err != nil {
+		return response.ErrorResponse(fiber.StatusInternalServerError, "PASSWORD_RESET_FAILED", fmt.Errorf("Failed to reset password. %v", err))
+	}
+
+	return response.SuccessResponse(fiber.StatusOK, "PASSWORD_RESET_SUCCESS", nil)
+}
+
+// Refresh issues a new session with a refresh token, which can't be used again.
+func (h *HttpHandler) Refresh(c *fiber.Ctx) response.HandledResponse {
+	req := new(struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+
+	session, err := h.authProvider.Refresh(req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		return response.UnauthorizedResponse(err)
+	}
+	if err != nil {
+		return response.ErrorResponse(fiber.StatusInternalServerError, "REFRESH_FAILED", fmt.Errorf("Failed to refresh session. %v", err))
+	}
+
+	return response.SuccessResponse(fiber.StatusOK, "REFRESH_SUCCESS", session)
+}
+
+// Logout revokes the access token of the request till it expires, and ends the session with the auth provider,
+// revoking the refresh token if given.
+func (h *HttpHandler) Logout(c *fiber.Ctx) response.HandledResponse {
+	if _, _, isAPIKey := locals.GetAPIKey(c); isAPIKey {
+		return response.ErrorResponse(fiber.StatusBadRequest, response.WrongInput, fmt.Errorf("API keys are revoked through /api/api-keys"))
+	}
+	req := new(struct {
+		RefreshToken string `json:"refresh_token"`
+	})
+	if len(c.Body()) > 0 { //optional
+		if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+			return errResponse
+		}
+	}
+
+	if tokenID := locals.GetTokenID(c); tokenID != "" {
+		if _, err := models.RevokeToken(h.db, tokenID, locals.GetTokenExpiresAt(c)); err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to revoke token. %v", err))
+		}
+	}
+
+	accessToken := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	err := h.authProvider.Logout(locals.GetUserID(c).String(), accessToken, req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		return response.ErrorResponse(fiber.StatusBadRequest, "INVALID_REFRESH_TOKEN", err)
+	}
+	if err != nil {
+		return response.ErrorResponse(fiber.StatusInternalServerError, "LOGOUT_FAILED", fmt.Errorf("Failed to logout. %v", err))
+	}
+
+	return response.SuccessResponse(fiber.StatusOK, "LOGOUT_SUCCESS", nil)
 }
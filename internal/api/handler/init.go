@@ -1,32 +1,49 @@
 package handler
 
 import (
+	"log-flow/internal/api/middleware"
+	"log-flow/internal/infrastructure/auth"
 	"log-flow/internal/infrastructure/queue"
 	"log-flow/internal/infrastructure/storage"
+	"log-flow/internal/utils/shutdown"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/supabase-community/gotrue-go"
 	"gorm.io/gorm"
 )
 
 type HttpHandler struct {
-	fileStorage  storage.Storage
-	logQueue     queue.LogQueueSender
-	db           *gorm.DB
-	supabaseAuth gotrue.Client
+	fileStorage     storage.Storage
+	logQueue        queue.LogQueueSender
+	liveStatusQueue queue.LiveStatusQueue
+	db              *gorm.DB
+	authProvider    auth.Provider
+	drain           *shutdown.Drain
+	jobAccess       *middleware.JobAccess
+	orgAccess       *middleware.OrgAccess
+	adminAccess     *middleware.AdminAccess
 }
 
 func NewHttpHandler(
 	logQueue queue.LogQueueSender,
+	liveStatusQueue queue.LiveStatusQueue,
 	storage storage.Storage,
 	db *gorm.DB,
-	supabaseAuth gotrue.Client,
+	authProvider auth.Provider,
+	drain *shutdown.Drain,
+	jobAccess *middleware.JobAccess,
+	orgAccess *middleware.OrgAccess,
+	adminAccess *middleware.AdminAccess,
 ) *HttpHandler {
 	return &HttpHandler{
-		fileStorage:  storage,
-		logQueue:     logQueue,
-		db:           db,
-		supabaseAuth: supabaseAuth,
+		fileStorage:     storage,
+		logQueue:        logQueue,
+		liveStatusQueue: liveStatusQueue,
+		db:              db,
+		authProvider:    authProvider,
+		drain:           drain,
+		jobAccess:       jobAccess,
+		orgAccess:       orgAccess,
+		adminAccess:     adminAccess,
 	}
 }
 
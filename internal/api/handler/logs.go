@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log-flow/internal/domain/models"
 	"log-flow/internal/domain/response"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 const (
@@ -32,36 +35,50 @@ func (h *HttpHandler) UploadLogs(c *fiber.Ctx) response.HandledResponse {
 	}
 
 	userID := locals.GetUserID(c)
-
-	url, err := h.fileStorage.UploadFile(file)
-	if err != nil {
-		return response.ErrorResponse(fiber.StatusInternalServerError, "UPLOAD_FAILED", fmt.Errorf("Failed to upload file. %v", err))
-	}
-	log.Debug("File uploaded. URL: ", url)
-
-	jobID, err := helper.GenerateUserIDPrefixedUUID(userID)
-	if err != nil {
-		return response.ErrorResponse(fiber.StatusInternalServerError, "UUID_ERROR", fmt.Errorf("Failed to generate UUID. %v", err))
-	}
+	jobID := uuid.New()
 
 	logMsg := queue.LogMessage{
 		JobID:    jobID.String(),
-		FileURL:  url,
+		UserID:   userID.String(),
+		FileSize: file.Size,
 		Priority: helper.GetPriorityByFileSize(file.Size),
 	}
 
 	job := models.Job{
-		ID:         jobID,
-		UserID:     userID,
-		FileURL:    url,
-		UploadedAt: time.Now(),
+		ID:       jobID,
+		UserID:   userID,
+		FileSize: file.Size,
 	}
-	if err = job.Create(h.db); err != nil {
+	if orgID := locals.GetOrgID(c); orgID != uuid.Nil { //uploaded into an organization, tracking its keywords
+		job.OrgID = &orgID
+		logMsg.OrgID = orgID.String()
+		logMsg.Keywords, err = models.GetOrgKeywords(h.db, orgID)
+		if err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to get keywords of organization. %v", err))
+		}
+	}
+	locals.SetAuditTarget(c, logMsg.JobID)
+	//the job is saved before the upload, reserving its share of the quotas
+	if errResponse := h.createJobWithinQuotas(c, &job); errResponse != nil {
+		return errResponse
+	}
+
+	url, err := h.fileStorage.UploadFile(file)
+	if err != nil {
+		h.deleteUnqueuedJob(jobID)
+		return response.ErrorResponse(fiber.StatusInternalServerError, "UPLOAD_FAILED", fmt.Errorf("Failed to upload file. %v", err))
+	}
+	log.Debug("File uploaded. URL: ", url)
+
+	logMsg.FileURL = url
+	if err = models.SetJobFileURL(h.db, jobID, url); err != nil {
+		h.deleteUnqueuedJob(jobID)
 		return response.ErrorResponse(fiber.StatusInternalServerError, "DB_ERROR", fmt.Errorf("Failed to save job to db. %v", err))
 	}
 
 	err = h.logQueue.SendToQueue(logMsg)
 	if err != nil {
+		h.deleteUnqueuedJob(jobID)
 		return response.ErrorResponse(fiber.StatusInternalServerError, "QUEUE_ERROR", fmt.Errorf("Failed to send to queue. %v", err))
 	}
 
@@ -72,23 +89,37 @@ func (h *HttpHandler) UploadLogs(c *fiber.Ctx) response.HandledResponse {
 	)
 }
 
+// deleteUnqueuedJob deletes a job that failed to be uploaded or queued, so it isn't counted as active in the quotas.
+func (h *HttpHandler) deleteUnqueuedJob(jobID uuid.UUID) {
+	if err := models.DeleteJob(h.db, jobID); err != nil {
+		log.Errorf("Failed to delete unqueued job %s: %v", jobID, err)
+	}
+}
+
 func (h *HttpHandler) FetchStatsByJobId(c *fiber.Ctx) response.HandledResponse {
 	jobID := c.Params("jobID")
 
-	job, err := models.GetLogReportByJobID(h.db, jobID)
+	logReport, err := models.GetLogReportByJobID(h.db, jobID)
+	if errors.Is(err, gorm.ErrRecordNotFound) { //the job exists, as checked by JobAccess, but isn't processed yet
+		return response.ErrorResponse(fiber.StatusNotFound, "REPORT_NOT_FOUND", fmt.Errorf("Job has no report yet."))
+	}
 	if err != nil {
 		return response.ErrorResponse(fiber.StatusInternalServerError, "DB_ERROR", fmt.Errorf("Failed to get job details. %v", err))
 	}
-	if job == nil {
-		return response.ErrorResponse(fiber.StatusNotFound, "JOB_NOT_FOUND", fmt.Errorf("Job not found."))
-	}
 
-	return response.SuccessResponse(200, response.Success, job)
+	return response.SuccessResponse(200, response.Success, logReport)
 }
 
 func (h *HttpHandler) FetchStats(c *fiber.Ctx) response.HandledResponse {
 	userID := locals.GetUserID(c)
-	results, err := models.GetWholeLogReportsAggregate(h.db, userID)
+
+	from, to, errResponse := parseDateRangeQuery(c)
+	if errResponse != nil {
+		return errResponse
+	}
+	filter := models.ReportsFilter{OrgID: locals.GetOrgID(c), From: from, To: to}
+
+	results, err := models.GetWholeLogReportsAggregate(h.db, userID, filter)
 	if err != nil {
 		return response.DBErrorResponse(fmt.Errorf("Failed to get whole log reports aggregate. %v", err))
 	}
@@ -96,13 +127,39 @@ func (h *HttpHandler) FetchStats(c *fiber.Ctx) response.HandledResponse {
 	return response.SuccessResponse(200, response.Success, results)
 }
 
-func (h *HttpHandler) GetQueueStatus(c *fiber.Ctx) response.HandledResponse {
-	status, err := h.logQueue.GetQueueStatus()
-	if err != nil {
-		return response.InternalServerErrorResponse(fmt.Errorf("Failed to get queue status. %v", err))
+// parseDateRangeQuery returns the bounds of the `from` and `to` query params, zero if not given.
+func parseDateRangeQuery(c *fiber.Ctx) (from, to time.Time, errResponse response.HandledResponse) {
+	var err error
+	if value := c.Query("from"); value != "" {
+		from, err = parseDateQuery(value, false)
+		if err != nil {
+			return from, to, response.ErrorResponse(fiber.StatusBadRequest, "INVALID_DATE", fmt.Errorf("Invalid 'from' date. %v", err))
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		to, err = parseDateQuery(value, true)
+		if err != nil {
+			return from, to, response.ErrorResponse(fiber.StatusBadRequest, "INVALID_DATE", fmt.Errorf("Invalid 'to' date. %v", err))
+		}
 	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, response.ErrorResponse(fiber.StatusBadRequest, "INVALID_DATE_RANGE", fmt.Errorf("'from' must be before 'to'."))
+	}
+	return from, to, nil
+}
 
-	return response.SuccessResponse(200, response.Success, map[string]any{
-		"queueStatus": status,
-	})
+// parseDateQuery accepts an RFC3339 timestamp or a YYYY-MM-DD date (UTC).
+// A date used as an upper bound covers the whole day, so it gives the start of the next day.
+func parseDateQuery(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 timestamp or YYYY-MM-DD date, got %q", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
 }
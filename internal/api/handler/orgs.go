@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"log-flow/internal/utils/validation"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Routes of this file, but CreateOrg and ListOrgs, are to be called after the OrgAccess role check.
+
+func (h *HttpHandler) CreateOrg(c *fiber.Ctx) response.HandledResponse {
+	req := new(struct {
+		Name string `json:"name" validate:"required,max=100"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+
+	org := models.Organization{Name: strings.TrimSpace(req.Name)}
+	if err := org.Create(h.db, locals.GetUserID(c)); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to create organization. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusCreated, response.Created, models.UserOrganization{Organization: org, Role: models.RoleOwner})
+}
+
+// ListOrgs lists the organizations of the user, with their role in each
+func (h *HttpHandler) ListOrgs(c *fiber.Ctx) response.HandledResponse {
+	orgs, err := models.GetUserOrganizations(h.db, locals.GetUserID(c))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get organizations. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, orgs)
+}
+
+func (h *HttpHandler) ListOrgMembers(c *fiber.Ctx) response.HandledResponse {
+	members, err := models.GetOrgMembers(h.db, locals.GetOrgID(c))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get organization members. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, members)
+}
+
+// SaveOrgMember adds a member to the organization, or changes its role. Only owners can make owners, or change the role of one.
+func (h *HttpHandler) SaveOrgMember(c *fiber.Ctx) response.HandledResponse {
+	userID, err := uuid.Parse(c.Params("userID"))
+	if err != nil {
+		return response.InvalidURLParamResponse("userID", err)
+	}
+	req := new(struct {
+		Role string `json:"role" validate:"required"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+	if !models.IsValidRole(req.Role) {
+		return response.ErrorResponse(fiber.StatusBadRequest, "INVALID_ROLE", fmt.Errorf("Invalid role %q, expected %s, %s, %s or %s",
+			req.Role, models.RoleOwner, models.RoleAdmin, models.RoleMember, models.RoleViewer))
+	}
+
+	orgID := locals.GetOrgID(c)
+	current, err := models.GetOrgMember(h.db, orgID, userID)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get organization member. %v", err))
+	}
+	if errResponse := checkCanManageRole(c, current, req.Role); errResponse != nil {
+		return errResponse
+	}
+
+	member := models.OrgMember{OrgID: orgID, UserID: userID, Role: req.Role}
+	if err := models.SaveOrgMember(h.db, &member); err != nil {
+		return orgMemberErrorResponse(err)
+	}
+	h.orgAccess.Invalidate(orgID)
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, member)
+}
+
+// RemoveOrgMember removes a member from the organization. Any member can leave, while removing others takes an admin,
+// and removing owners an owner.
+func (h *HttpHandler) RemoveOrgMember(c *fiber.Ctx) response.HandledResponse {
+	userID, err := uuid.Parse(c.Params("userID"))
+	if err != nil {
+		return response.InvalidURLParamResponse("userID", err)
+	}
+
+	orgID := locals.GetOrgID(c)
+	if userID != locals.GetUserID(c) {
+		current, err := models.GetOrgMember(h.db, orgID, userID)
+		if err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to get organization member. %v", err))
+		}
+		if current == nil {
+			return response.NotFoundResponse("organization member")
+		}
+		if errResponse := checkCanManageRole(c, current, ""); errResponse != nil {
+			return errResponse
+		}
+	}
+
+	deleted, err := models.DeleteOrgMember(h.db, orgID, userID)
+	if err != nil {
+		return orgMemberErrorResponse(err)
+	}
+	if !deleted {
+		return response.NotFoundResponse("organization member")
+	}
+	h.orgAccess.Invalidate(orgID)
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, nil)
+}
+
+func (h *HttpHandler) GetOrgKeywords(c *fiber.Ctx) response.HandledResponse {
+	keywords, err := models.GetOrgKeywords(h.db, locals.GetOrgID(c))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get keywords. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, map[string]any{"keywords": keywords})
+}
+
+// SetOrgKeywords replaces the keyword profile of the organization, tracked in the jobs uploaded into it from then on.
+// An empty profile tracks the default keywords.
+func (h *HttpHandler) SetOrgKeywords(c *fiber.Ctx) response.HandledResponse {
+	req := new(struct {
+		Keywords []string `json:"keywords" validate:"max=100,dive,required,max=100"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+
+	if err := models.SetOrgKeywords(h.db, locals.GetOrgID(c), req.Keywords); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to save keywords. %v", err))
+	}
+	return h.GetOrgKeywords(c)
+}
+
+// checkCanManageRole returns an error response unless the user can change the member from its current role
+// (nil if not a member yet) to the new one (empty if removed).
+func checkCanManageRole(c *fiber.Ctx, current *models.OrgMember, newRole string) response.HandledResponse {
+	if locals.GetOrgRole(c) == models.RoleOwner {
+		return nil
+	}
+	if !models.RoleAtLeast(locals.GetOrgRole(c), models.RoleAdmin) {
+		return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("Requires the %s role in the organization", models.RoleAdmin))
+	}
+	if newRole == models.RoleOwner || (current != nil && current.Role == models.RoleOwner) {
+		return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("Only owners can manage owners"))
+	}
+	return nil
+}
+
+func orgMemberErrorResponse(err error) response.HandledResponse {
+	if errors.Is(err, models.ErrLastOwner) {
+		return response.ErrorResponse(fiber.StatusConflict, "LAST_OWNER", fmt.Errorf("The organization must keep an owner. Make another member owner first."))
+	}
+	return response.DBErrorResponse(fmt.Errorf("Failed to save organization member. %v", err))
+}
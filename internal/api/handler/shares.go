@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"log-flow/internal/utils/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Routes of this file are to be called after the JobAccess owner check.
+
+// jobUserRequest names the user to share a job with, or to transfer it to
+type jobUserRequest struct {
+	UserID uuid.UUID `json:"userID" validate:"required"`
+}
+
+func (h *HttpHandler) ListJobShares(c *fiber.Ctx) response.HandledResponse {
+	shares, err := models.GetJobShares(h.db, c.Params("jobID"))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get job shares. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, shares)
+}
+
+// ShareJob gives another user access to the job: its stats and live progress.
+func (h *HttpHandler) ShareJob(c *fiber.Ctx) response.HandledResponse {
+	req := new(jobUserRequest)
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+	if req.UserID == locals.GetUserID(c) {
+		return response.ErrorResponse(fiber.StatusBadRequest, response.WrongInput, fmt.Errorf("The job is yours already"))
+	}
+
+	jobID := c.Params("jobID")
+	share := models.JobShare{
+		JobID:  uuid.MustParse(jobID), //validated by the owner check
+		UserID: req.UserID,
+	}
+	if err := share.Create(h.db); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to share job. %v", err))
+	}
+	h.jobAccess.Invalidate(jobID)
+
+	return response.SuccessResponse(fiber.StatusCreated, response.Created, share)
+}
+
+func (h *HttpHandler) UnshareJob(c *fiber.Ctx) response.HandledResponse {
+	userID, err := uuid.Parse(c.Params("userID"))
+	if err != nil {
+		return response.InvalidURLParamResponse("userID", err)
+	}
+
+	jobID := c.Params("jobID")
+	deleted, err := models.DeleteJobShare(h.db, jobID, userID)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to unshare job. %v", err))
+	}
+	if !deleted {
+		return response.NotFoundResponse("job share")
+	}
+	h.jobAccess.Invalidate(jobID)
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, nil)
+}
+
+// TransferJob makes another user the owner of the job. The previous owner loses access to it, unless it's shared with them.
+func (h *HttpHandler) TransferJob(c *fiber.Ctx) response.HandledResponse {
+	req := new(jobUserRequest)
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+	if req.UserID == locals.GetUserID(c) {
+		return response.ErrorResponse(fiber.StatusBadRequest, response.WrongInput, fmt.Errorf("The job is yours already"))
+	}
+
+	jobID := c.Params("jobID")
+	err := models.TransferJob(h.db, jobID, req.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return response.ErrorResponse(fiber.StatusNotFound, "JOB_NOT_FOUND", fmt.Errorf("Job not found."))
+	}
+	if errors.Is(err, models.ErrNotOrgMember) {
+		return response.ErrorResponse(fiber.StatusBadRequest, "NOT_ORG_MEMBER", fmt.Errorf("Jobs of an organization can only be transferred to its members"))
+	}
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to transfer job. %v", err))
+	}
+	h.jobAccess.Invalidate(jobID)
+
+	return response.SuccessResponse(fiber.StatusOK, response.Success, map[string]any{
+		"jobID":   jobID,
+		"ownerID": req.UserID,
+	})
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/infrastructure/queue"
+	"log-flow/internal/workers"
+	"log-flow/pkg/liveprogress"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/gofiber/fiber/v2/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+
+	// The final status can arrive just before the report is saved
+	finalReportAttempts = 5
+	finalReportDelay    = 200 * time.Millisecond
+)
+
+// Event types of the job events stream
+const (
+	sseEventProgress  = "progress"  //LogLiveStats
+	sseEventCompleted = "completed" //final report (models.LogReport), or the last LogLiveStats if it couldn't be fetched
+	sseEventFailed    = "failed"    //LogLiveStats, or an error message
+)
+
+// Sent for jobs failed out of attempts, on the job events stream and the job WebSocket
+var attemptsExhaustedMessage = fmt.Sprintf("Job had been attempted %d times, but failed", models.JobMaxAttempts)
+
+// StreamJobEvents streams the live status of a job as Server-Sent Events, ending with a completed or failed event.
+// Each progress event is a full snapshot with the seq of the status as its ID, so a client resuming with
+// Last-Event-ID gets the latest status right away, unless it has already seen it.
+// On shutdown, the stream ends early, for the client to reconnect to another instance.
+func (h *HttpHandler) StreamJobEvents(c *fiber.Ctx) response.HandledResponse {
+	jobID := utils.CopyString(c.Params("jobID"))
+	lastEventID, _ := strconv.ParseInt(c.Get("Last-Event-ID"), 10, 64)
+
+	logReport, err := models.GetLogReportByJobID(h.db, jobID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get job details. %v", err))
+	}
+	if logReport != nil {
+		return &sseResponse{events: func(w *bufio.Writer) error {
+			return writeSSEEvent(w, sseEventCompleted, "", logReport)
+		}}
+	}
+
+	job, err := models.GetJobByID(h.db, jobID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return response.ErrorResponse(fiber.StatusNotFound, "JOB_NOT_FOUND", fmt.Errorf("Job not found."))
+	}
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get job details. %v", err))
+	}
+	if job.HasFailed(time.Now()) {
+		return &sseResponse{events: func(w *bufio.Writer) error {
+			return writeSSEEvent(w, sseEventFailed, "", attemptsExhaustedMessage)
+		}}
+	}
+
+	unwatch := h.liveStatusQueue.WatchJob(jobID)
+	subscription, err := h.liveStatusQueue.Subscribe(jobID)
+	if err != nil {
+		unwatch()
+		return response.InternalServerErrorResponse(fmt.Errorf("Failed to subscribe to job progress. %v", err))
+	}
+
+	return &sseResponse{events: func(w *bufio.Writer) error {
+		defer unwatch()
+		defer subscription.Close()
+		return h.streamLiveStatus(w, jobID, subscription, lastEventID)
+	}}
+}
+
+func (h *HttpHandler) streamLiveStatus(w *bufio.Writer, jobID string, subscription *queue.LiveStatusSubscription, lastEventID int64) error {
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-h.drain.Done():
+			return nil
+
+		case <-heartbeat.C:
+			// A comment line, ignored by clients. Writing it detects clients that went away.
+			if _, err := w.WriteString(": heartbeat\n\n"); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+		case msg, ok := <-subscription.Messages():
+			if !ok {
+				return nil //closed after the final status, sent below
+			}
+			if msg.Seq <= lastEventID {
+				continue //already seen before reconnecting
+			}
+
+			var stats workers.LogLiveStats
+			if err := json.Unmarshal(msg.Body, &stats); err != nil {
+				log.Errorf("Error unmarshalling live stats of job %s: %v", jobID, err)
+				continue
+			}
+			if !msg.Final {
+				if err := writeSSEEvent(w, sseEventProgress, strconv.FormatInt(msg.Seq, 10), stats); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if stats.Status != liveprogress.StatusCompleted {
+				return writeSSEEvent(w, sseEventFailed, "", stats)
+			}
+			return writeSSEEvent(w, sseEventCompleted, "", h.waitForFinalReport(jobID, stats))
+		}
+	}
+}
+
+// waitForFinalReport returns the saved report of a completed job, or its final live status if the report can't be fetched.
+func (h *HttpHandler) waitForFinalReport(jobID string, finalStatus workers.LogLiveStats) any {
+	for attempt := 0; attempt < finalReportAttempts; attempt++ {
+		logReport, err := models.GetLogReportByJobID(h.db, jobID)
+		if err == nil {
+			return logReport
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("Error fetching final report of job %s: %v", jobID, err)
+			break
+		}
+		time.Sleep(finalReportDelay)
+	}
+	return finalStatus
+}
+
+func writeSSEEvent(w *bufio.Writer, event, id string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("Error marshalling %s event: %v", event, err)
+	}
+
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return w.Flush()
+}
+
+// sseResponse streams the events written by events as the response body.
+type sseResponse struct {
+	events func(w *bufio.Writer) error
+}
+
+func (sr *sseResponse) WriteToJSON(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no") //for nginx, not to buffer the stream
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := sr.events(w); err != nil {
+			log.Debug("Event stream ended: ", err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/utils/locals"
+	"log-flow/internal/utils/validation"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// quotaLimits are the quotas of a workspace, 0 for no limit
+type quotaLimits struct {
+	jobsPerDay     int
+	storageBytes   int64
+	concurrentJobs int
+}
+
+// quotaUsage is what a workspace uses of a quota
+type quotaUsage struct {
+	Used  int64  `json:"used"`
+	Limit *int64 `json:"limit"` //null for no limit
+}
+
+func newQuotaUsage(used, limit int64) quotaUsage {
+	usage := quotaUsage{Used: used}
+	if limit > 0 {
+		usage.Limit = &limit
+	}
+	return usage
+}
+
+type workspaceUsage struct {
+	WorkspaceType  string     `json:"workspaceType"`
+	WorkspaceID    uuid.UUID  `json:"workspaceID"`
+	JobsToday      quotaUsage `json:"jobsToday"`
+	DayResetsAt    time.Time  `json:"dayResetsAt"` //next UTC midnight
+	StorageBytes   quotaUsage `json:"storageBytes"`
+	ConcurrentJobs quotaUsage `json:"concurrentJobs"` //queued or processing
+}
+
+// GetUsage returns the usage of the quotas of the workspace of the request.
+func (h *HttpHandler) GetUsage(c *fiber.Ctx) response.HandledResponse {
+	usage, errResponse := h.workspaceUsage(locals.GetUserID(c), locals.GetOrgID(c))
+	if errResponse != nil {
+		return errResponse
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, usage)
+}
+
+// errQuotaExceeded is returned by the quota check of createJobWithinQuotas, its error response being set aside.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// createJobWithinQuotas saves the job, uploading a file into the workspace of the request, or returns an error response
+// if it would exceed the quotas of the workspace. Quotas are checked and the job saved under a lock of the workspace,
+// so concurrent uploads can't exceed them together.
+func (h *HttpHandler) createJobWithinQuotas(c *fiber.Ctx, job *models.Job) response.HandledResponse {
+	now := time.Now()
+	userID, orgID := locals.GetUserID(c), locals.GetOrgID(c)
+	limits, err := h.workspaceQuota(userID, orgID)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get quotas. %v", err))
+	}
+	if limits == (quotaLimits{}) {
+		if err := job.Create(h.db); err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to save job to db. %v", err))
+		}
+		return nil
+	}
+
+	var quotaResponse response.HandledResponse
+	err = models.CreateJobWithinQuota(h.db, job, dayStart(now), now, func(usage *models.WorkspaceUsage) error {
+		quotaResponse = quotaExceededResponse(c, limits, usage, job.FileSize, now)
+		if quotaResponse != nil {
+			return errQuotaExceeded
+		}
+		return nil
+	})
+	if errors.Is(err, errQuotaExceeded) {
+		return quotaResponse
+	}
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to save job to db. %v", err))
+	}
+	return nil
+}
+
+// quotaExceededResponse returns an error response if uploading a file of the size would exceed the quotas, given the usage.
+func quotaExceededResponse(c *fiber.Ctx, limits quotaLimits, usage *models.WorkspaceUsage, fileSize int64, now time.Time) response.HandledResponse {
+	if limits.jobsPerDay > 0 && usage.JobsToday >= limits.jobsPerDay {
+		retryAfter := dayStart(now).AddDate(0, 0, 1).Sub(now)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())+1))
+		return response.ErrorResponse(fiber.StatusTooManyRequests, "DAILY_JOB_QUOTA_EXCEEDED",
+			fmt.Errorf("The workspace has uploaded its %d jobs of the day. The quota resets at 00:00 UTC.", limits.jobsPerDay))
+	}
+	if limits.concurrentJobs > 0 && usage.ActiveJobs >= limits.concurrentJobs {
+		return response.ErrorResponse(fiber.StatusTooManyRequests, "CONCURRENT_JOB_QUOTA_EXCEEDED",
+			fmt.Errorf("The workspace has %d jobs queued or processing, its quota. Upload once some are done.", usage.ActiveJobs))
+	}
+	if limits.storageBytes > 0 && usage.StorageBytes+fileSize > limits.storageBytes {
+		return response.ErrorResponse(fiber.StatusRequestEntityTooLarge, "STORAGE_QUOTA_EXCEEDED",
+			fmt.Errorf("The file would exceed the storage quota of the workspace, %d of %d bytes being used.", usage.StorageBytes, limits.storageBytes))
+	}
+	return nil
+}
+
+// workspaceUsage returns the usage of the personal workspace of the user, or of the organization if not nil.
+func (h *HttpHandler) workspaceUsage(userID, orgID uuid.UUID) (*workspaceUsage, response.HandledResponse) {
+	now := time.Now()
+	limits, err := h.workspaceQuota(userID, orgID)
+	if err != nil {
+		return nil, response.DBErrorResponse(fmt.Errorf("Failed to get quotas. %v", err))
+	}
+	usage, err := models.GetWorkspaceUsage(h.db, userID, orgID, dayStart(now), now)
+	if err != nil {
+		return nil, response.DBErrorResponse(fmt.Errorf("Failed to get usage. %v", err))
+	}
+
+	workspaceType, workspaceID := workspaceOf(userID, orgID)
+	return &workspaceUsage{
+		WorkspaceType:  workspaceType,
+		WorkspaceID:    workspaceID,
+		JobsToday:      newQuotaUsage(int64(usage.JobsToday), int64(limits.jobsPerDay)),
+		DayResetsAt:    dayStart(now).AddDate(0, 0, 1),
+		StorageBytes:   newQuotaUsage(usage.StorageBytes, limits.storageBytes),
+		ConcurrentJobs: newQuotaUsage(int64(usage.ActiveJobs), int64(limits.concurrentJobs)),
+	}, nil
+}
+
+// workspaceQuota returns the quotas of the workspace: the defaults of the config, but the ones overridden by admins.
+func (h *HttpHandler) workspaceQuota(userID, orgID uuid.UUID) (quotaLimits, error) {
+	defaults := config.Env.QuotaConfig
+	limits := quotaLimits{
+		jobsPerDay:     max(defaults.JobsPerDay, 0),
+		storageBytes:   max(defaults.StorageMB, 0) * 1024 * 1024,
+		concurrentJobs: max(defaults.ConcurrentJobs, 0),
+	}
+
+	workspaceType, workspaceID := workspaceOf(userID, orgID)
+	quota, err := models.GetQuota(h.db, workspaceType, workspaceID)
+	if err != nil || quota == nil {
+		return limits, err
+	}
+	if quota.JobsPerDay != nil {
+		limits.jobsPerDay = *quota.JobsPerDay
+	}
+	if quota.StorageMB != nil {
+		limits.storageBytes = *quota.StorageMB * 1024 * 1024
+	}
+	if quota.ConcurrentJobs != nil {
+		limits.concurrentJobs = *quota.ConcurrentJobs
+	}
+	return limits, nil
+}
+
+func (h *HttpHandler) GetUserQuota(c *fiber.Ctx) response.HandledResponse {
+	return h.getWorkspaceQuota(c, models.WorkspaceUser, "userID")
+}
+
+func (h *HttpHandler) SetUserQuota(c *fiber.Ctx) response.HandledResponse {
+	return h.setWorkspaceQuota(c, models.WorkspaceUser, "userID")
+}
+
+func (h *HttpHandler) GetOrgQuota(c *fiber.Ctx) response.HandledResponse {
+	return h.getWorkspaceQuota(c, models.WorkspaceOrg, "orgID")
+}
+
+func (h *HttpHandler) SetOrgQuota(c *fiber.Ctx) response.HandledResponse {
+	return h.setWorkspaceQuota(c, models.WorkspaceOrg, "orgID")
+}
+
+// getWorkspaceQuota returns the usage of the quotas of the workspace of the param, with their overrides.
+func (h *HttpHandler) getWorkspaceQuota(c *fiber.Ctx, workspaceType, param string) response.HandledResponse {
+	workspaceID, err := uuid.Parse(c.Params(param))
+	if err != nil {
+		return response.InvalidURLParamResponse(param, err)
+	}
+	userID, orgID := workspaceID, uuid.Nil
+	if workspaceType == models.WorkspaceOrg {
+		userID, orgID = uuid.Nil, workspaceID
+	}
+
+	usage, errResponse := h.workspaceUsage(userID, orgID)
+	if errResponse != nil {
+		return errResponse
+	}
+	quota, err := models.GetQuota(h.db, workspaceType, workspaceID)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get quotas. %v", err))
+	}
+	return response.SuccessResponse(fiber.StatusOK, response.Success, map[string]any{
+		"usage":     usage,
+		"overrides": quota, //null if the workspace has the default quotas
+	})
+}
+
+// setWorkspaceQuota overrides the default quotas of the workspace of the param. Null limits are the default ones, and 0 is no limit.
+func (h *HttpHandler) setWorkspaceQuota(c *fiber.Ctx, workspaceType, param string) response.HandledResponse {
+	workspaceID, err := uuid.Parse(c.Params(param))
+	if err != nil {
+		return response.InvalidURLParamResponse(param, err)
+	}
+	req := new(struct {
+		JobsPerDay     *int   `json:"jobsPerDay" validate:"omitempty,min=0"`
+		StorageMB      *int64 `json:"storageMB" validate:"omitempty,min=0"`
+		ConcurrentJobs *int   `json:"concurrentJobs" validate:"omitempty,min=0"`
+	})
+	if errResponse := validation.BindAndValidateJSONRequest(c, req); errResponse != nil {
+		return errResponse
+	}
+
+	quota := models.Quota{
+		WorkspaceType:  workspaceType,
+		WorkspaceID:    workspaceID,
+		JobsPerDay:     req.JobsPerDay,
+		StorageMB:      req.StorageMB,
+		ConcurrentJobs: req.ConcurrentJobs,
+	}
+	if err := quota.Save(h.db); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to save quotas. %v", err))
+	}
+	return h.getWorkspaceQuota(c, workspaceType, param)
+}
+
+func workspaceOf(userID, orgID uuid.UUID) (workspaceType string, workspaceID uuid.UUID) {
+	if orgID != uuid.Nil {
+		return models.WorkspaceOrg, orgID
+	}
+	return models.WorkspaceUser, userID
+}
+
+// dayStart is the start of the UTC day of the time, days quotas are counted by
+func dayStart(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
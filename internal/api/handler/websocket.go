@@ -1,11 +1,14 @@
 package handler
 
 import (
-	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"log-flow/internal/api/middleware"
 	"log-flow/internal/domain/models"
 	"log-flow/internal/infrastructure/queue"
 	"log-flow/internal/workers"
+	"log-flow/pkg/liveprogress"
+	"sync"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
@@ -16,92 +19,149 @@ import (
 type WebSocketManager struct {
 	ProgressMessenger queue.LiveStatusQueue
 	db                *gorm.DB
+	jobAccess         *middleware.JobAccess //of the jobs subscribed to on the user feed
+
+	mutex    sync.Mutex
+	sessions map[*wsSession]struct{} //open sockets, to be closed on shutdown
 }
 
-func NewWebSocketManager(liveProgressMessenger queue.LiveStatusQueue, db *gorm.DB) *WebSocketManager {
+func NewWebSocketManager(liveProgressMessenger queue.LiveStatusQueue, db *gorm.DB, jobAccess *middleware.JobAccess) *WebSocketManager {
 	return &WebSocketManager{
 		ProgressMessenger: liveProgressMessenger,
 		db:                db,
+		jobAccess:         jobAccess,
+		sessions:          make(map[*wsSession]struct{}),
 	}
 }
 
-func (wsm *WebSocketManager) LiveProgressLogs(c *websocket.Conn) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // 5 minutes
-	defer cancel()
+// track registers an open session until the returned func is called
+func (wsm *WebSocketManager) track(session *wsSession) (untrack func()) {
+	wsm.mutex.Lock()
+	wsm.sessions[session] = struct{}{}
+	wsm.mutex.Unlock()
+
+	return func() {
+		wsm.mutex.Lock()
+		delete(wsm.sessions, session)
+		wsm.mutex.Unlock()
+	}
+}
+
+// CloseAll closes the open sockets with a going away close frame, for clients to reconnect to another instance.
+func (wsm *WebSocketManager) CloseAll() {
+	wsm.mutex.Lock()
+	sessions := make([]*wsSession, 0, len(wsm.sessions))
+	for session := range wsm.sessions {
+		sessions = append(sessions, session)
+	}
+	wsm.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session.Close(websocket.CloseGoingAway, "Server shutting down")
+		}()
+	}
+	wg.Wait()
+}
 
+// LiveProgressLogs streams the progress of a job as liveprogress.Envelopes: a snapshot, progress events,
+// then a completed or failed event, after which the socket is closed.
+// The session lasts as long as the job, unless the client goes away or its token expires.
+func (wsm *WebSocketManager) LiveProgressLogs(c *websocket.Conn) {
 	jobID := c.Params("jobID") // Extract job ID from URL
 
+	session := newWsSession(c)
+	defer wsm.track(session)()
+	defer session.closeAtTokenExpiry()()
+	gone := make(chan struct{})
+	go session.readUntilGone(gone)
+
 	logReport, err := models.GetLogReportByJobID(wsm.db, jobID)
-	if err != nil && err.Error() != "record not found" {
-		c.WriteMessage(websocket.TextMessage, []byte("Database error occured while fetching job details"))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		log.Error("Error while fetching job details:", err)
+		session.SendErrorAndClose(jobID, liveprogress.ErrCodeInternal, "Database error occured while fetching job details", websocket.CloseInternalServerErr)
 		return
 	}
 	if logReport != nil {
 		//send log report to client
-		logReport := workers.LogLiveStats{
+		stats := workers.LogLiveStats{
 			JobID:              jobID,
 			Progress:           100,
-			Status:             "Completed",
+			Status:             liveprogress.StatusCompleted,
 			UniqueIPs:          logReport.UniqueIPs,
 			InvalidLogs:        logReport.InvalidLogs,
 			TotalLogsProcessed: logReport.TotalLogs,
 			LogLevelCounts: map[string]int{
-				"ERROR": logReport.ErrorCount,
-				"WARN":  logReport.WarnCount,
-				"INFO":  logReport.InfoCount,
+				liveprogress.LogLevelError: logReport.ErrorCount,
+				liveprogress.LogLevelWarn:  logReport.WarnCount,
+				liveprogress.LogLevelInfo:  logReport.InfoCount,
 			},
+			KeyWordCounts: logReport.TrackedKeywordsCount,
 		}
-		message, err := logReport.GetMessage()
-		if err != nil {
-			c.WriteMessage(websocket.TextMessage, []byte("Some error occured while parsing log report"))
-		} else {
-			c.WriteMessage(websocket.TextMessage, []byte(message))
-		}
+		session.Send(liveprogress.EventCompleted, jobID, 0, stats)
+		session.Close(websocket.CloseNormalClosure, "Job completed")
 		return
 	}
 
 	//if log report not found, then check if job is registered
 	job, err := models.GetJobByID(wsm.db, jobID)
-	if err != nil {
-		c.WriteMessage(websocket.TextMessage, []byte("Database error occured while fetching job details"))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		log.Error("Error while fetching job details:", err)
+		session.SendErrorAndClose(jobID, liveprogress.ErrCodeInternal, "Database error occured while fetching job details", websocket.CloseInternalServerErr)
 		return
 	}
 	if job == nil {
-		c.WriteMessage(websocket.TextMessage, []byte("Job not registered(Invalid Job ID)"))
+		session.SendErrorAndClose(jobID, liveprogress.ErrCodeJobNotFound, "Job not registered(Invalid Job ID)", liveprogress.CloseJobNotFound)
 		return
 	}
-	if job.Attempts >= 3 && job.Succeeded == false {
-		c.WriteMessage(websocket.TextMessage, []byte("Job had been attempted 3 times, but failed"))
+	if job.HasFailed(time.Now()) {
+		session.Send(liveprogress.EventFailed, jobID, 0, liveprogress.Error{Code: liveprogress.ErrCodeAttemptsFailed, Message: attemptsExhaustedMessage})
+		session.Close(websocket.CloseNormalClosure, "Job failed")
 		return
 	}
 
 	//Job registered, but log report not found. So, listen for progress messages
 
-	workers.ActiveJobs.Store(jobID, true)
-	defer workers.ActiveJobs.Delete(jobID) // Remove when client disconnects
+	unwatch := wsm.ProgressMessenger.WatchJob(jobID)
+	defer unwatch() // Remove when client disconnects
 
-	msgs, err := wsm.ProgressMessenger.WaitAndRecieveProgressMsgsQueue(ctx, jobID)
+	subscription, err := wsm.ProgressMessenger.Subscribe(jobID)
 	if err != nil {
-		if err == queue.ErrCtxCancelled {
-			fmt.Println("Context cancelled: stopping queue wait for ", jobID)
-			c.WriteMessage(websocket.TextMessage, []byte("Error: Context timeout"))
-		} else {
-			c.WriteMessage(websocket.TextMessage, []byte("Error: "+err.Error()))
-			log.Warn("error while waiting for progress messages:", err)
-		}
+		log.Warn("error while subscribing to progress messages:", err)
+		session.SendErrorAndClose(jobID, liveprogress.ErrCodeInternal, err.Error(), websocket.CloseInternalServerErr)
 		return
 	}
+	defer subscription.Close()
 
-	for msg := range msgs {
-		if err := c.WriteMessage(websocket.TextMessage, msg.Body); err != nil {
-			c.WriteMessage(websocket.TextMessage, []byte("Error: "+err.Error()))
-			log.Error("WebSocket send error:", err)
-			break
+	first := true
+	for {
+		select {
+		case <-gone:
+			return
+		case <-session.Done():
+			return
+
+		case msg, ok := <-subscription.Messages():
+			if !ok { //job done, after its final status
+				session.Close(websocket.CloseNormalClosure, "Job ended")
+				return
+			}
+
+			var stats workers.LogLiveStats
+			if err := json.Unmarshal(msg.Body, &stats); err != nil {
+				log.Errorf("Error unmarshalling live stats of job %s: %v", jobID, err)
+				continue
+			}
+			eventType := liveprogress.EventTypeOf(stats, msg.Final)
+			if first && eventType == liveprogress.EventProgress {
+				eventType = liveprogress.EventSnapshot
+			}
+			first = false
+
+			session.Send(eventType, jobID, msg.Seq, stats)
 		}
 	}
-
-	c.WriteMessage(websocket.TextMessage, []byte("Completed"))
-	time.Sleep(1 * time.Second) // Give some time to send the ending message
 }
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log-flow/internal/infrastructure/queue"
+	"log-flow/internal/utils/locals"
+	"log-flow/internal/workers"
+	"log-flow/pkg/liveprogress"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+)
+
+// feedRequest is a liveprogress.Request, validated by the reader of the socket
+type feedRequest struct {
+	liveprogress.Request
+	err error //invalid request, replied to with the error
+}
+
+// LiveProgressFeed streams the status changes (started, completed, failed...) of all the jobs of the user.
+// The progress of jobs is streamed too, for the jobs subscribed to with a liveprogress.Request.
+func (wsm *WebSocketManager) LiveProgressFeed(c *websocket.Conn) {
+	session := newWsSession(c)
+	defer wsm.track(session)()
+	userID, _ := c.Locals(locals.UserIdKey).(string)
+	if userID == "" {
+		session.SendErrorAndClose("", liveprogress.ErrCodeInvalidRequest, "Unauthorized", websocket.ClosePolicyViolation)
+		return
+	}
+	defer session.closeAtTokenExpiry()()
+
+	unwatch := wsm.ProgressMessenger.WatchUser(userID)
+	defer unwatch()
+
+	subscription, err := wsm.ProgressMessenger.SubscribeUser(userID)
+	if err != nil {
+		log.Warn("error while subscribing to progress messages:", err)
+		session.SendErrorAndClose("", liveprogress.ErrCodeInternal, err.Error(), websocket.CloseInternalServerErr)
+		return
+	}
+	defer subscription.Close()
+
+	requests := make(chan feedRequest)
+	readerDone := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go wsm.readFeedRequests(c, userID, requests, readerDone, stop)
+
+	// Jobs subscribed to are followed through subscriptions of their own, as the jobs shared with the user or of
+	// their organizations aren't on the subscription of the user. Their statuses are skipped on the latter.
+	jobMessages := make(chan feedMessage)
+	followed := make(map[string]func()) //stops following each job subscribed to
+	defer func() {
+		for _, unfollow := range followed {
+			unfollow()
+		}
+	}()
+
+	lastStatuses := make(map[string]string) //status last sent of each job
+	for {
+		select {
+		case <-readerDone: //client gone
+			return
+		case <-session.Done():
+			return
+
+		case request := <-requests:
+			if request.err != nil {
+				session.Send(liveprogress.EventError, "", 0, liveprogress.Error{Code: liveprogress.ErrCodeInvalidRequest, Message: request.err.Error()})
+				continue
+			}
+
+			if request.Action != liveprogress.ActionSubscribe {
+				for _, jobID := range request.JobIDs {
+					if unfollow, ok := followed[jobID]; ok {
+						unfollow()
+						delete(followed, jobID)
+					}
+				}
+				session.Send(liveprogress.EventUnsubscribed, "", 0, liveprogress.Subscription{JobIDs: request.JobIDs})
+				continue
+			}
+
+			session.Send(liveprogress.EventSubscribed, "", 0, liveprogress.Subscription{JobIDs: request.JobIDs})
+			for _, jobID := range request.JobIDs { //starting with the latest progress, without waiting for the next one
+				if _, ok := followed[jobID]; ok {
+					continue
+				}
+				unfollow, err := wsm.followJob(jobID, jobMessages, stop)
+				if err != nil {
+					log.Warn("error while subscribing to progress messages:", err)
+					session.Send(liveprogress.EventError, jobID, 0, liveprogress.Error{Code: liveprogress.ErrCodeInternal, Message: err.Error()})
+					continue
+				}
+				followed[jobID] = unfollow
+			}
+
+		case message := <-jobMessages:
+			if _, ok := followed[message.JobID]; ok { //unless unsubscribed since
+				sendFeedStatus(session, message.LiveStatusMessage, message.snapshot, true, lastStatuses)
+			}
+
+		case msg, ok := <-subscription.Messages():
+			if !ok {
+				return
+			}
+			if _, ok := followed[msg.JobID]; !ok {
+				sendFeedStatus(session, msg, false, false, lastStatuses)
+			}
+		}
+	}
+}
+
+// feedMessage is a status of a job followed on the user feed
+type feedMessage struct {
+	queue.LiveStatusMessage
+	snapshot bool //the first status received, the latest one when subscribing
+}
+
+// followJob forwards the statuses of the job to messages until the returned func is called, or stop is closed.
+func (wsm *WebSocketManager) followJob(jobID string, messages chan<- feedMessage, stop <-chan struct{}) (unfollow func(), err error) {
+	unwatch := wsm.ProgressMessenger.WatchJob(jobID)
+	subscription, err := wsm.ProgressMessenger.Subscribe(jobID)
+	if err != nil {
+		unwatch()
+		return nil, err
+	}
+
+	go func() {
+		snapshot := true
+		for msg := range subscription.Messages() { //closed after the final status, or once unfollowed
+			select {
+			case messages <- feedMessage{LiveStatusMessage: msg, snapshot: snapshot}:
+			case <-stop:
+				return
+			}
+			snapshot = false
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			subscription.Close()
+			unwatch()
+		})
+	}, nil
+}
+
+// sendFeedStatus sends msg if its job is subscribed to, or if it is a status change.
+func sendFeedStatus(session *wsSession, msg queue.LiveStatusMessage, snapshot, subscribed bool, lastStatuses map[string]string) {
+	var stats workers.LogLiveStats
+	if err := json.Unmarshal(msg.Body, &stats); err != nil {
+		log.Errorf("Error unmarshalling live stats of job %s: %v", msg.JobID, err)
+		return
+	}
+
+	statusChanged := lastStatuses[msg.JobID] != stats.Status
+	if msg.Final {
+		delete(lastStatuses, msg.JobID)
+	} else {
+		lastStatuses[msg.JobID] = stats.Status
+	}
+	if !subscribed && !statusChanged && !msg.Final {
+		return
+	}
+
+	eventType := liveprogress.EventTypeOf(stats, msg.Final)
+	if snapshot && !msg.Final {
+		eventType = liveprogress.EventSnapshot
+	}
+	session.Send(eventType, msg.JobID, msg.Seq, stats)
+}
+
+// readFeedRequests reads the requests of the client until it disconnects, then closes done.
+func (wsm *WebSocketManager) readFeedRequests(c *websocket.Conn, userID string, requests chan<- feedRequest, done, stop chan struct{}) {
+	defer close(done)
+
+	for {
+		var request feedRequest
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if err := json.Unmarshal(data, &request.Request); err != nil {
+			request.err = fmt.Errorf("Invalid request: %v", err)
+		} else {
+			switch request.Action {
+			case liveprogress.ActionSubscribe:
+				request.err = wsm.checkJobsAccess(userID, request.JobIDs)
+			case liveprogress.ActionUnsubscribe:
+			default:
+				request.err = fmt.Errorf("Invalid action, expected %q or %q", liveprogress.ActionSubscribe, liveprogress.ActionUnsubscribe)
+			}
+		}
+
+		select {
+		case requests <- request:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkJobsAccess returns an error if the user can't read any of the jobs, as on the routes of the jobs:
+// theirs, the ones shared with them, and the ones of their organizations.
+func (wsm *WebSocketManager) checkJobsAccess(userID string, jobIDs []string) error {
+	userUUID, _ := uuid.Parse(userID) //nil for invalid IDs, which can read no job
+	for _, jobID := range jobIDs {
+		canRead, err := wsm.jobAccess.CanRead(jobID, userUUID)
+		if err != nil {
+			log.Errorf("Error getting access to job %s: %v", jobID, err)
+			return fmt.Errorf("Failed to get access to job %s", jobID)
+		}
+		if !canRead {
+			return fmt.Errorf("Job %s not found", jobID)
+		}
+	}
+	return nil
+}
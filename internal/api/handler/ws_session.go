@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/utils/locals"
+	"log-flow/pkg/liveprogress"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	defaultPingInterval   = 30 * time.Second
+	defaultWriteTimeout   = 10 * time.Second
+	defaultSendBufferSize = 32
+)
+
+// wsSession writes to a socket from its own goroutine, so that a slow client never holds up the handler.
+// Messages are queued up to a bound, beyond which the oldest progress updates are dropped, as later ones
+// supersede them. Other messages (snapshots excepted) are never dropped. The client is pinged to keep the
+// connection alive, and disconnected if it doesn't answer or doesn't read a message within the write timeout.
+type wsSession struct {
+	conn         *websocket.Conn
+	pingInterval time.Duration
+	writeTimeout time.Duration
+	bufferSize   int
+
+	mutex  sync.Mutex
+	queue  []wsOutgoing
+	closed bool //no more messages accepted
+
+	notify chan struct{}
+	done   chan struct{} //closed when the writer stops
+}
+
+type wsOutgoing struct {
+	envelope    liveprogress.Envelope
+	closeCode   int //a close frame rather than a message, ending the session
+	closeReason string
+}
+
+func (o wsOutgoing) droppable() bool {
+	return o.closeCode == 0 && (o.envelope.Type == liveprogress.EventProgress || o.envelope.Type == liveprogress.EventSnapshot)
+}
+
+func newWsSession(conn *websocket.Conn) *wsSession {
+	s := &wsSession{
+		conn:         conn,
+		pingInterval: time.Duration(config.Env.WebSocketConfig.PingIntervalSeconds) * time.Second,
+		writeTimeout: time.Duration(config.Env.WebSocketConfig.WriteTimeoutSeconds) * time.Second,
+		bufferSize:   config.Env.WebSocketConfig.SendBufferSize,
+		notify:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	if s.pingInterval <= 0 {
+		s.pingInterval = defaultPingInterval
+	}
+	if s.writeTimeout <= 0 {
+		s.writeTimeout = defaultWriteTimeout
+	}
+	if s.bufferSize <= 0 {
+		s.bufferSize = defaultSendBufferSize
+	}
+
+	// Reading is up to the handler, which has to keep reading for pongs to be handled
+	pongWait := 2 * s.pingInterval
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go s.writeLoop()
+	return s
+}
+
+// Send queues an envelope. It never blocks.
+func (s *wsSession) Send(eventType liveprogress.EventType, jobID string, seq int64, payload any) {
+	envelope, err := liveprogress.NewEnvelope(eventType, jobID, seq, payload)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	s.enqueue(wsOutgoing{envelope: envelope})
+}
+
+// Close sends a close frame after the queued messages, and waits for the writer to stop.
+func (s *wsSession) Close(code int, reason string) {
+	s.enqueue(wsOutgoing{closeCode: code, closeReason: reason})
+	select {
+	case <-s.done:
+	case <-time.After(s.writeTimeout * 2): //writer stuck on a previous message, for at most the write timeout
+	}
+}
+
+// Done is closed when the session can't write anymore: closed, or the client is gone.
+func (s *wsSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// SendErrorAndClose sends an error event, then closes the socket.
+func (s *wsSession) SendErrorAndClose(jobID, code, message string, closeCode int) {
+	s.Send(liveprogress.EventError, jobID, 0, liveprogress.Error{Code: code, Message: message})
+	s.Close(closeCode, message)
+}
+
+func (s *wsSession) enqueue(outgoing wsOutgoing) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	if outgoing.closeCode != 0 {
+		s.closed = true
+	}
+
+	if len(s.queue) >= s.bufferSize && outgoing.droppable() {
+		for i, queued := range s.queue {
+			if queued.droppable() {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	s.queue = append(s.queue, outgoing)
+	s.mutex.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *wsSession) writeLoop() {
+	defer close(s.done)
+
+	ping := time.NewTicker(s.pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.writeTimeout)); err != nil {
+				log.Debug("WebSocket ping error:", err)
+				return
+			}
+
+		case <-s.notify:
+			for {
+				s.mutex.Lock()
+				if len(s.queue) == 0 {
+					s.mutex.Unlock()
+					break
+				}
+				outgoing := s.queue[0]
+				s.queue = s.queue[1:]
+				s.mutex.Unlock()
+
+				if outgoing.closeCode != 0 {
+					closeMsg := websocket.FormatCloseMessage(outgoing.closeCode, outgoing.closeReason)
+					if err := s.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(s.writeTimeout)); err != nil {
+						log.Debug("WebSocket close error:", err)
+					}
+					return
+				}
+
+				s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+				if err := s.conn.WriteJSON(outgoing.envelope); err != nil {
+					log.Debug("WebSocket send error:", err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// closeAtTokenExpiry closes the session when the token it was authenticated with expires,
+// unless the returned function is called before.
+func (s *wsSession) closeAtTokenExpiry() (stop func()) {
+	expiresAt, _ := s.conn.Locals(locals.TokenExpiresAtKey).(time.Time)
+	if expiresAt.IsZero() {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(time.Until(expiresAt), func() {
+		s.Close(liveprogress.CloseTokenExpired, "Token expired")
+		s.conn.Close()
+	})
+	return func() { timer.Stop() }
+}
+
+// readUntilGone reads and discards the client's messages, so that pongs and close frames are handled,
+// and closes gone once the client is gone.
+func (s *wsSession) readUntilGone(gone chan<- struct{}) {
+	defer close(gone)
+	for {
+		if _, _, err := s.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
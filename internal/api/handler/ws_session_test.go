@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"log-flow/pkg/liveprogress"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWsSessionDropsOnlyOldProgress(t *testing.T) {
+	session := &wsSession{bufferSize: 3, notify: make(chan struct{}, 1)} //no writer, so everything stays queued
+
+	session.Send(liveprogress.EventSnapshot, "job", 1, nil)
+	session.Send(liveprogress.EventSubscribed, "", 0, nil)
+	for seq := int64(2); seq <= 10; seq++ {
+		session.Send(liveprogress.EventProgress, "job", seq, nil)
+	}
+	session.Send(liveprogress.EventCompleted, "job", 11, nil)
+	session.Send(liveprogress.EventCompleted, "other-job", 1, nil)
+	session.Close(1000, "done") //doesn't wait, with no write timeout
+	session.Send(liveprogress.EventProgress, "job", 12, nil)
+
+	var sent []string
+	for _, outgoing := range session.queue {
+		if outgoing.closeCode != 0 {
+			sent = append(sent, "close")
+			continue
+		}
+		sent = append(sent, string(outgoing.envelope.Type)+":"+outgoing.envelope.JobID)
+	}
+	assert.Equal(t, []string{"subscribed:", "progress:job", "progress:job", "completed:job", "completed:other-job", "close"}, sent,
+		"the latest progress and every other message should be kept, in order, and nothing after closing")
+	assert.Equal(t, int64(9), session.queue[1].envelope.Seq)
+	assert.Equal(t, int64(10), session.queue[2].envelope.Seq)
+}
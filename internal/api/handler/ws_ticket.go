@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const wsTicketTTL = 30 * time.Second
+
+// IssueWsTicket issues a one-time ticket to authenticate a WebSocket upgrade with, valid for wsTicketTTL.
+// Connections authenticated with it are closed when the token the ticket was issued with expires.
+func (h *HttpHandler) IssueWsTicket(c *fiber.Ctx) response.HandledResponse {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return response.InternalServerErrorResponse(fmt.Errorf("Failed to generate ticket. %v", err))
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(secret)
+
+	tokenExpiresAt := locals.GetTokenExpiresAt(c)
+	expiresAt := time.Now().Add(wsTicketTTL)
+	if !tokenExpiresAt.IsZero() && tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+
+	wsTicket := models.WsTicket{
+		TicketHash:     models.HashTicket(ticket),
+		UserID:         locals.GetUserID(c),
+		ExpiresAt:      expiresAt,
+		TokenExpiresAt: tokenExpiresAt,
+	}
+	if err := wsTicket.Create(h.db); err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to save ticket. %v", err))
+	}
+
+	return response.SuccessResponse(fiber.StatusCreated, response.Created, map[string]any{
+		"ticket":    ticket,
+		"expiresAt": expiresAt,
+	})
+}
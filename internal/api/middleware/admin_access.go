@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// System roles are cached this long. Changes made through another process are seen once their cache expires.
+const adminAccessTTL = 30 * time.Second
+
+// AdminAccess authorizes admin-only routes by the system role of the user: carried in the claim of AUTH_ROLES_CLAIM of
+// their JWT, or stored in the database and cached by user. To be used only after the user is authenticated.
+type AdminAccess struct {
+	db    *gorm.DB
+	roles *ttlCache[uuid.UUID, string]
+}
+
+func NewAdminAccess(db *gorm.DB) *AdminAccess {
+	return &AdminAccess{
+		db:    db,
+		roles: newTTLCache[uuid.UUID, string](adminAccessTTL),
+	}
+}
+
+// RoleOf returns the stored system role of the user.
+func (a *AdminAccess) RoleOf(userID uuid.UUID) (string, error) {
+	return a.roles.get(userID, time.Now(), func() (string, error) {
+		return models.GetSystemRole(a.db, userID)
+	})
+}
+
+// Invalidate drops the cached role of the user, once it changed.
+func (a *AdminAccess) Invalidate(userID uuid.UUID) {
+	a.roles.invalidate(userID)
+}
+
+// RequireAdmin lets through admins. Requests authenticated by an API key need its admin scope, and keys of
+// organizations are refused, as admin routes span all workspaces.
+func (a *AdminAccess) RequireAdmin(c *fiber.Ctx) error {
+	scopes, keyOrgID, isAPIKey := locals.GetAPIKey(c)
+	if isAPIKey && (!slices.Contains(scopes, models.ScopeAdmin) || keyOrgID != uuid.Nil) {
+		return adminOnlyResponse().WriteToJSON(c)
+	}
+
+	if !slices.Contains(locals.GetTokenRoles(c), models.SystemRoleAdmin) {
+		role, err := a.RoleOf(locals.GetUserID(c))
+		if err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to get role of user. %v", err)).WriteToJSON(c)
+		}
+		if role != models.SystemRoleAdmin {
+			return adminOnlyResponse().WriteToJSON(c)
+		}
+	}
+	return c.Next()
+}
+
+func adminOnlyResponse() *response.Response {
+	return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("Requires the %s role", models.SystemRoleAdmin))
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"log-flow/internal/domain/models"
+	"log-flow/internal/utils/locals"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Audit records the request in the audit log once handled: the action on the target of the targetParams params, joined
+// by "/" (or the one set by the handler, with locals.SetAuditTarget), with the result of the response. To be used after
+// the user is authenticated, and before the target is authorized, for denied requests to be recorded too.
+func Audit(db *gorm.DB, action, targetType string, targetParams ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		targetID := locals.GetAuditTarget(c)
+		if targetID == "" && len(targetParams) > 0 {
+			values := make([]string, len(targetParams))
+			for i, param := range targetParams {
+				values[i] = c.Params(param)
+			}
+			targetID = strings.Join(values, "/")
+		}
+		status := c.Response().StatusCode()
+		result := auditResult(status)
+		if err != nil {
+			result = models.AuditFailure
+		}
+		RecordAudit(db, c, action, targetType, targetID, result, status)
+		return err
+	}
+}
+
+// RecordAudit appends an entry of the action of the user of the request to the audit log. Failing to is logged,
+// rather than failing the request.
+func RecordAudit(db *gorm.DB, c *fiber.Ctx, action, targetType, targetID, result string, status int) {
+	entry := models.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Result:     result,
+		Status:     status,
+		IP:         ClientIP(c),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+	}
+	if userID := locals.GetUserID(c); userID != uuid.Nil {
+		entry.ActorID = &userID
+	}
+	if keyID := locals.GetAPIKeyID(c); keyID != uuid.Nil {
+		entry.APIKeyID = &keyID
+	}
+	if err := entry.Create(db); err != nil {
+		log.Errorf("Failed to record %s of %s %s in the audit log: %v", action, targetType, targetID, err)
+	}
+}
+
+func auditResult(status int) string {
+	switch {
+	case status < fiber.StatusBadRequest:
+		return models.AuditSuccess
+	case status == fiber.StatusUnauthorized || status == fiber.StatusForbidden || status == fiber.StatusNotFound:
+		return models.AuditDenied
+	default:
+		return models.AuditFailure
+	}
+}
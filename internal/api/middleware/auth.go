@@ -1,33 +1,120 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"log-flow/internal/domain/models"
 	"log-flow/internal/domain/response"
 	jwttoken "log-flow/internal/utils/jwt"
 	"log-flow/internal/utils/locals"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
-func AuthMiddleware(c *fiber.Ctx) error {
-	authHeader := c.Get("Authorization")
-	if authHeader == "" {
-		return invalidAuthResponse(c, fmt.Errorf("Missing token"))
+// APIKeyHeader carries an API key, as an alternative to `Authorization: Bearer <key>`
+const APIKeyHeader = "X-API-Key"
+
+// AuthMiddleware authenticates requests with a JWT of the auth provider which hasn't been revoked, or with an API key,
+// given as bearer token or in the APIKeyHeader. Requests authenticated by keys are limited to their scopes by RequireScope.
+func AuthMiddleware(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = c.Get(APIKeyHeader)
+		}
+		if token == "" {
+			return invalidAuthResponse(c, fmt.Errorf("Missing token"))
+		}
+
+		if strings.HasPrefix(token, models.APIKeyPrefix) {
+			return authenticateAPIKey(c, db, token)
+		}
+
+		claims, err := jwttoken.ValidateToken(token)
+		if err != nil {
+			return invalidAuthResponse(c, err)
+		}
+		if claims.RevocationID() != "" {
+			revoked, err := models.IsTokenRevoked(db, claims.RevocationID())
+			if err != nil {
+				return response.DBErrorResponse(fmt.Errorf("Failed to validate token. %v", err)).WriteToJSON(c)
+			}
+			if revoked {
+				return invalidAuthResponse(c, fmt.Errorf("Token has been revoked"))
+			}
+		}
+		revoked, err := models.IsUserTokenRevoked(db, claims.UserID, claims.IssuedAt)
+		if err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to validate token. %v", err)).WriteToJSON(c)
+		}
+		if revoked {
+			return invalidAuthResponse(c, fmt.Errorf("Token has been revoked"))
+		}
+
+		//set user id in context
+		locals.SetUserID(c, claims.UserID)
+		locals.SetTokenExpiresAt(c, claims.ExpiresAt)
+		locals.SetTokenID(c, claims.RevocationID())
+		locals.SetTokenRoles(c, claims.Roles)
+
+		return c.Next()
 	}
+}
 
-	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-	userID, err := jwttoken.ValidateTokenAndGetUserID(tokenStr)
-	if err != nil {
+func authenticateAPIKey(c *fiber.Ctx, db *gorm.DB, key string) error {
+	apiKey, err := models.AuthenticateAPIKey(db, key, time.Now())
+	if errors.Is(err, models.ErrInvalidAPIKey) {
 		return invalidAuthResponse(c, err)
 	}
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to validate API key. %v", err)).WriteToJSON(c)
+	}
+
+	locals.SetUserID(c, apiKey.UserID.String())
+	if apiKey.ExpiresAt != nil {
+		locals.SetTokenExpiresAt(c, *apiKey.ExpiresAt)
+	}
+	orgID := uuid.Nil
+	if apiKey.OrgID != nil {
+		orgID = *apiKey.OrgID
+	}
+	locals.SetAPIKey(c, apiKey.ID, apiKey.ScopeList(), orgID)
+
+	return c.Next()
+}
 
-	//set user id in context
-	locals.SetUserID(c, userID)
+// RequireScope lets through requests authenticated by an API key with the scope (or the admin one),
+// and requests authenticated otherwise, which aren't limited by scopes.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scopes, _, isAPIKey := locals.GetAPIKey(c)
+		if isAPIKey && !slices.Contains(scopes, scope) && !slices.Contains(scopes, models.ScopeAdmin) {
+			return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("API key lacks the %s scope", scope)).WriteToJSON(c)
+		}
+		return c.Next()
+	}
+}
 
+// RejectOrgAPIKeys refuses requests authenticated by API keys of organizations, for routes spanning all the jobs of the user.
+func RejectOrgAPIKeys(c *fiber.Ctx) error {
+	if !apiKeyAllowsOrg(c, uuid.Nil) {
+		return apiKeyOrgResponse().WriteToJSON(c)
+	}
 	return c.Next()
 }
 
+// apiKeyAllowsOrg reports whether the request can be made in the organization (nil for the personal workspace):
+// keys of an organization are limited to it, and personal keys to the workspaces of their user.
+func apiKeyAllowsOrg(c *fiber.Ctx, orgID uuid.UUID) bool {
+	_, keyOrgID, isAPIKey := locals.GetAPIKey(c)
+	return !isAPIKey || keyOrgID == uuid.Nil || keyOrgID == orgID
+}
+
 func invalidAuthResponse(c *fiber.Ctx, err error) error {
 	return response.UnauthorizedResponse(err).WriteToJSON(c)
 }
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"fmt"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/shutdown"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RejectWhenDraining refuses requests once the server is shutting down, for clients to retry on another instance
+func RejectWhenDraining(drain *shutdown.Drain) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if drain.IsDraining() {
+			c.Set(fiber.HeaderRetryAfter, "5")
+			return response.ErrorResponse(fiber.StatusServiceUnavailable, response.ServiceUnavailable, fmt.Errorf("Server is shutting down, retry later")).WriteToJSON(c)
+		}
+		return c.Next()
+	}
+}
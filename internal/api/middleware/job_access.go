@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Access lists are cached this long. Changes made through another process are seen once their cache expires.
+const jobAccessTTL = 30 * time.Second
+
+// jobACL is who can access a job: its owner, the users it's shared with, and the members of its organization
+type jobACL struct {
+	ownerID    uuid.UUID
+	orgID      uuid.UUID //nil for personal jobs
+	sharedWith map[uuid.UUID]bool
+}
+
+// JobAccess authorizes access to jobs by their owner, the users they're shared with, and the members of
+// their organization, loaded from the database and cached by job ID. To be used only after the user is authenticated.
+type JobAccess struct {
+	db     *gorm.DB
+	orgs   *OrgAccess
+	access *ttlCache[string, *jobACL]
+}
+
+func NewJobAccess(db *gorm.DB, orgs *OrgAccess) *JobAccess {
+	return &JobAccess{
+		db:     db,
+		orgs:   orgs,
+		access: newTTLCache[string, *jobACL](jobAccessTTL),
+	}
+}
+
+// Check lets through the users who can read the job of the `jobID` param.
+// Jobs the user can't access are reported not found, not to disclose which IDs exist.
+func (a *JobAccess) Check(c *fiber.Ctx) error {
+	acl, errResponse := a.aclOf(c)
+	if errResponse != nil {
+		return errResponse.WriteToJSON(c)
+	}
+	canRead, _, err := a.permissions(c, acl)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get job access. %v", err)).WriteToJSON(c)
+	}
+	if !canRead {
+		return jobNotFoundResponse().WriteToJSON(c)
+	}
+	return c.Next()
+}
+
+// OwnerCheck lets through the users who can manage the job of the `jobID` param, to share or transfer it:
+// its owner, and the admins of its organization.
+func (a *JobAccess) OwnerCheck(c *fiber.Ctx) error {
+	acl, errResponse := a.aclOf(c)
+	if errResponse != nil {
+		return errResponse.WriteToJSON(c)
+	}
+	canRead, canManage, err := a.permissions(c, acl)
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get job access. %v", err)).WriteToJSON(c)
+	}
+	if canManage {
+		return c.Next()
+	}
+	if canRead {
+		return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("Only the owner of the job can do this")).WriteToJSON(c)
+	}
+	return jobNotFoundResponse().WriteToJSON(c)
+}
+
+// Invalidate drops the cached access list of the job, once its owner or shares changed.
+func (a *JobAccess) Invalidate(jobID string) {
+	a.access.invalidate(jobID)
+}
+
+// CanRead reports whether the user can read the job, for requests not on the routes of the job,
+// such as subscriptions of the user feed. Invalid and unknown job IDs can't be read.
+func (a *JobAccess) CanRead(jobID string, userID uuid.UUID) (bool, error) {
+	id, err := uuid.Parse(jobID)
+	if err != nil {
+		return false, nil
+	}
+	acl, err := a.loadACL(id.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	canRead, _, err := a.userPermissions(acl, userID)
+	return canRead, err
+}
+
+func (a *JobAccess) permissions(c *fiber.Ctx, acl *jobACL) (canRead, canManage bool, err error) {
+	if !apiKeyAllowsOrg(c, acl.orgID) {
+		return false, false, nil
+	}
+	return a.userPermissions(acl, locals.GetUserID(c))
+}
+
+// userPermissions of personal jobs are for their owner, and reading for the users they're shared with.
+// The ones of org jobs come from the current role of the user in the org, whoever uploaded them,
+// so members who left lose them. Shares still let read org jobs.
+func (a *JobAccess) userPermissions(acl *jobACL, userID uuid.UUID) (canRead, canManage bool, err error) {
+	if acl.orgID == uuid.Nil {
+		isOwner := acl.ownerID == userID
+		return isOwner || acl.sharedWith[userID], isOwner, nil
+	}
+	role, err := a.orgs.RoleOf(acl.orgID, userID)
+	if err != nil {
+		return false, false, err
+	}
+	return role != "" || acl.sharedWith[userID], models.RoleAtLeast(role, models.RoleAdmin), nil
+}
+
+func (a *JobAccess) aclOf(c *fiber.Ctx) (*jobACL, *response.Response) {
+	jobID, err := uuid.Parse(c.Params("jobID"))
+	if err != nil {
+		return nil, response.InvalidURLParamResponse("jobID", err)
+	}
+
+	acl, err := a.loadACL(jobID.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, jobNotFoundResponse()
+	}
+	if err != nil {
+		return nil, response.DBErrorResponse(fmt.Errorf("Failed to get job access. %v", err))
+	}
+	return acl, nil
+}
+
+func (a *JobAccess) loadACL(jobID string) (*jobACL, error) {
+	return a.access.get(jobID, time.Now(), func() (*jobACL, error) {
+		return loadJobACL(a.db, jobID)
+	})
+}
+
+func loadJobACL(db *gorm.DB, jobID string) (*jobACL, error) {
+	job, err := models.GetJobByID(db, jobID)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := models.GetJobShares(db, jobID)
+	if err != nil {
+		return nil, err
+	}
+	acl := &jobACL{
+		ownerID:    job.UserID,
+		sharedWith: make(map[uuid.UUID]bool, len(shares)),
+	}
+	if job.OrgID != nil {
+		acl.orgID = *job.OrgID
+	}
+	for _, share := range shares {
+		acl.sharedWith[share.UserID] = true
+	}
+	return acl, nil
+}
+
+func jobNotFoundResponse() *response.Response {
+	return response.ErrorResponse(fiber.StatusNotFound, "JOB_NOT_FOUND", fmt.Errorf("Job not found."))
+}
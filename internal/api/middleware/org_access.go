@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// WorkspaceHeader selects the organization a request is made in, the personal workspace of the user if not set.
+	// It can be given as the `workspace` query param too.
+	WorkspaceHeader     = "X-Workspace-ID"
+	workspaceQueryParam = "workspace"
+
+	// Members are cached this long. Changes made through another process are seen once their cache expires.
+	orgAccessTTL = 30 * time.Second
+)
+
+// OrgAccess authorizes requests in organizations by the role of the user, loaded from the database and cached
+// by organization. To be used only after the user is authenticated.
+type OrgAccess struct {
+	db      *gorm.DB
+	members *ttlCache[uuid.UUID, map[uuid.UUID]string] //roles by user ID, by org ID
+}
+
+func NewOrgAccess(db *gorm.DB) *OrgAccess {
+	return &OrgAccess{
+		db:      db,
+		members: newTTLCache[uuid.UUID, map[uuid.UUID]string](orgAccessTTL),
+	}
+}
+
+// RoleOf returns the role of the user in the organization, empty if not a member.
+func (a *OrgAccess) RoleOf(orgID, userID uuid.UUID) (string, error) {
+	roles, err := a.members.get(orgID, time.Now(), func() (map[uuid.UUID]string, error) {
+		members, err := models.GetOrgMembers(a.db, orgID)
+		if err != nil {
+			return nil, err
+		}
+		roles := make(map[uuid.UUID]string, len(members))
+		for _, member := range members {
+			roles[member.UserID] = member.Role
+		}
+		return roles, nil
+	})
+	return roles[userID], err
+}
+
+// Invalidate drops the cached members of the organization, once they changed.
+func (a *OrgAccess) Invalidate(orgID uuid.UUID) {
+	a.members.invalidate(orgID)
+}
+
+// RequireRole lets through members of the organization of the `orgID` param with at least minRole.
+// Organizations the user isn't a member of are reported not found.
+func (a *OrgAccess) RequireRole(minRole string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID, err := uuid.Parse(c.Params("orgID"))
+		if err != nil {
+			return response.InvalidURLParamResponse("orgID", err).WriteToJSON(c)
+		}
+		if !apiKeyAllowsOrg(c, orgID) {
+			return apiKeyOrgResponse().WriteToJSON(c)
+		}
+		if errResponse := a.authorize(c, orgID, minRole); errResponse != nil {
+			return errResponse.WriteToJSON(c)
+		}
+		return c.Next()
+	}
+}
+
+// Workspace lets through requests in the personal workspace of the user, and requests in an organization
+// by its members with at least minRole. The organization is given by the WorkspaceHeader, or the `workspace` query param.
+// Requests authenticated by an API key of an organization are made in it.
+func (a *OrgAccess) Workspace(minRole string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		workspace := c.Get(WorkspaceHeader)
+		if workspace == "" {
+			workspace = c.Query(workspaceQueryParam)
+		}
+		if _, keyOrgID, _ := locals.GetAPIKey(c); workspace == "" && keyOrgID != uuid.Nil {
+			workspace = keyOrgID.String()
+		}
+		if workspace == "" {
+			return c.Next()
+		}
+
+		orgID, err := uuid.Parse(workspace)
+		if err != nil {
+			return response.ErrorResponse(fiber.StatusBadRequest, "INVALID_WORKSPACE", fmt.Errorf("Invalid workspace. %v", err)).WriteToJSON(c)
+		}
+		if !apiKeyAllowsOrg(c, orgID) {
+			return apiKeyOrgResponse().WriteToJSON(c)
+		}
+		if errResponse := a.authorize(c, orgID, minRole); errResponse != nil {
+			return errResponse.WriteToJSON(c)
+		}
+		return c.Next()
+	}
+}
+
+func (a *OrgAccess) authorize(c *fiber.Ctx, orgID uuid.UUID, minRole string) *response.Response {
+	role, err := a.RoleOf(orgID, locals.GetUserID(c))
+	if err != nil {
+		return response.DBErrorResponse(fmt.Errorf("Failed to get organization members. %v", err))
+	}
+	if role == "" {
+		return response.ErrorResponse(fiber.StatusNotFound, "ORG_NOT_FOUND", fmt.Errorf("Organization not found."))
+	}
+	if !models.RoleAtLeast(role, minRole) {
+		return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("Requires the %s role in the organization", minRole))
+	}
+	locals.SetOrg(c, orgID, role)
+	return nil
+}
+
+func apiKeyOrgResponse() *response.Response {
+	return response.ErrorResponse(fiber.StatusForbidden, response.Forbidden, fmt.Errorf("API key is limited to the workspace of its organization"))
+}
@@ -9,14 +9,17 @@ import (
 
 func RateLimit(rate int) func(ctx *fiber.Ctx) error {
 	return limiter.New(limiter.Config{
-		Max:        rate,
-		Expiration: 1 * time.Minute,
-		KeyGenerator: func(c *fiber.Ctx) string {
-			realIP := c.Get("X-Real-IP") //real ip, set by nginx
-			if realIP != "" {
-				return realIP
-			}
-			return c.Context().RemoteIP().String() //remote ip
-		},
+		Max:          rate,
+		Expiration:   1 * time.Minute,
+		KeyGenerator: ClientIP,
 	})
 }
+
+// ClientIP returns the IP of the client of the request
+func ClientIP(c *fiber.Ctx) string {
+	realIP := c.Get("X-Real-IP") //real ip, set by nginx
+	if realIP != "" {
+		return realIP
+	}
+	return c.Context().RemoteIP().String() //remote ip
+}
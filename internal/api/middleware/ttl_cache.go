@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// Bound of the entries of a cache. Past it, expired entries are dropped, or all of them if none is.
+const ttlCacheSize = 10000
+
+type ttlCacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// ttlCache caches values for a while, for authorization not to hit the database on every request.
+// Entries are invalidated on changes made by this process only; changes made by others apply once they expire.
+type ttlCache[K comparable, V any] struct {
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[K]ttlCacheEntry[V]
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:     ttl,
+		entries: make(map[K]ttlCacheEntry[V]),
+	}
+}
+
+// get returns the cached value of key, or loads and caches it
+func (c *ttlCache[K, V]) get(key K, now time.Time, load func() (V, error)) (V, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.entries) >= ttlCacheSize {
+		for cachedKey, cached := range c.entries {
+			if !now.Before(cached.expiresAt) {
+				delete(c.entries, cachedKey)
+			}
+		}
+		if len(c.entries) >= ttlCacheSize { //all recent, start over
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = ttlCacheEntry[V]{value: value, expiresAt: now.Add(c.ttl)}
+	return value, nil
+}
+
+func (c *ttlCache[K, V]) invalidate(key K) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/locals"
+	"strings"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// WsSubprotocol is the subprotocol to request on upgrades, along with the ticket if not given as query param
+	WsSubprotocol = "log-flow"
+
+	wsTicketQueryParam = "ticket"
+)
+
+// WebSocketAuth authenticates WebSocket upgrades with a JWT or an API key like AuthMiddleware,
+// or with a one-time ticket issued by the ticket endpoint, for browsers which can't set headers on upgrades.
+// The ticket is given as the `ticket` query param, or as a subprotocol: `Sec-WebSocket-Protocol: log-flow, <ticket>`.
+func WebSocketAuth(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return response.ErrorResponse(fiber.StatusUpgradeRequired, "UPGRADE_REQUIRED", fmt.Errorf("WebSocket upgrade required")).WriteToJSON(c)
+		}
+
+		if c.Get("Authorization") != "" || c.Get(APIKeyHeader) != "" {
+			return AuthMiddleware(db)(c)
+		}
+
+		ticket := c.Query(wsTicketQueryParam)
+		if ticket == "" {
+			ticket = ticketFromSubprotocols(c.Get(fiber.HeaderSecWebSocketProtocol))
+		}
+		if ticket == "" {
+			return invalidAuthResponse(c, fmt.Errorf("Missing token or ticket"))
+		}
+
+		wsTicket, err := models.ConsumeWsTicket(db, ticket)
+		if err != nil {
+			return response.DBErrorResponse(fmt.Errorf("Failed to validate ticket. %v", err)).WriteToJSON(c)
+		}
+		if wsTicket == nil {
+			return invalidAuthResponse(c, fmt.Errorf("Invalid or expired ticket"))
+		}
+
+		locals.SetUserID(c, wsTicket.UserID.String())
+		locals.SetTokenExpiresAt(c, wsTicket.TokenExpiresAt)
+		return c.Next()
+	}
+}
+
+func ticketFromSubprotocols(header string) string {
+	for _, protocol := range strings.Split(header, ",") {
+		if protocol = strings.TrimSpace(protocol); protocol != "" && protocol != WsSubprotocol {
+			return protocol
+		}
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"log-flow/internal/api/handler"
+	"log-flow/internal/api/middleware"
+	"log-flow/internal/domain/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// mountAdminRoutes mounts the routes spanning all users and workspaces, for admins only
+func mountAdminRoutes(app *fiber.App, handler *handler.HttpHandler, db *gorm.DB, adminAccess *middleware.AdminAccess) {
+	admin := app.Group("/admin")
+	admin.Use(middleware.AuthMiddleware(db), adminAccess.RequireAdmin)
+	{
+		admin.Get("/queue-status", responseWrapper(handler.GetQueueStatus))
+		admin.Post("/failed-jobs/replay", responseWrapper(handler.ReplayFailedJobs))
+		admin.Get("/stats", responseWrapper(handler.FetchSystemStats))
+
+		admin.Get("/users", responseWrapper(handler.ListUsers))
+		admin.Put("/users/:userID/role", middleware.Audit(db, models.AuditUserSetRole, models.AuditTargetUser, "userID"), responseWrapper(handler.SetUserRole))
+		admin.Delete("/users/:userID", middleware.Audit(db, models.AuditUserDelete, models.AuditTargetUser, "userID"), responseWrapper(handler.DeleteUser))
+		admin.Get("/users/:userID/quota", responseWrapper(handler.GetUserQuota))
+		admin.Put("/users/:userID/quota", middleware.Audit(db, models.AuditQuotaSet, models.AuditTargetUser, "userID"), responseWrapper(handler.SetUserQuota))
+		admin.Get("/orgs/:orgID/quota", responseWrapper(handler.GetOrgQuota))
+		admin.Put("/orgs/:orgID/quota", middleware.Audit(db, models.AuditQuotaSet, models.AuditTargetOrg, "orgID"), responseWrapper(handler.SetOrgQuota))
+
+		// Reads of the audit log are audited too, exports apart
+		readAudit := middleware.Audit(db, models.AuditLogRead, models.AuditTargetAuditLog)
+		exportAudit := middleware.Audit(db, models.AuditLogExport, models.AuditTargetAuditLog)
+		admin.Get("/audit", func(c *fiber.Ctx) error {
+			if c.Query("format") == "csv" {
+				return exportAudit(c)
+			}
+			return readAudit(c)
+		}, responseWrapper(handler.ListAuditLog))
+	}
+}
@@ -6,13 +6,18 @@ import (
 	"log-flow/internal/infrastructure/config"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-func mountAuthRoutes(app *fiber.App, handler *handler.HttpHandler) {
+func mountAuthRoutes(app *fiber.App, handler *handler.HttpHandler, db *gorm.DB) {
 	auth := app.Group("/auth")
 	app.Use(middleware.RateLimit(config.Env.AuthEndpointsRateLimit))
 	{
 		auth.Post("/login", responseWrapper(handler.Login))
 		auth.Post("/register", responseWrapper(handler.Register))
+		auth.Post("/refresh", responseWrapper(handler.Refresh))
+		auth.Post("/logout", middleware.AuthMiddleware(db), responseWrapper(handler.Logout))
+		auth.Post("/password-reset", responseWrapper(handler.RequestPasswordReset))
+		auth.Post("/password-reset/confirm", responseWrapper(handler.ResetPassword))
 	}
 }
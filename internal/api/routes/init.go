@@ -2,26 +2,34 @@ package routes
 
 import (
 	"log-flow/internal/api/handler"
+	"log-flow/internal/api/middleware"
 	"log-flow/internal/domain/response"
+	"log-flow/internal/utils/shutdown"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func MountRoutes(
 	app *fiber.App,
 	httpHandler *handler.HttpHandler,
 	websocketManager *handler.WebSocketManager,
+	db *gorm.DB,
+	jobAccess *middleware.JobAccess,
+	orgAccess *middleware.OrgAccess,
+	adminAccess *middleware.AdminAccess,
+	drain *shutdown.Drain,
 ) {
 	// health check
 	app.Get("/health", httpHandler.HealthCheck)
 
-	//http routes
-	mountAuthRoutes(app, httpHandler)
-	mountLogRoutes(app, httpHandler)
-
 	//websocket routes
-	mountWebSocketRoutes(app, websocketManager)
+	mountWebSocketRoutes(app, websocketManager, db, jobAccess, drain)
 
+	//http routes
+	mountAuthRoutes(app, httpHandler, db)
+	mountLogRoutes(app, httpHandler, db, jobAccess, orgAccess, drain)
+	mountAdminRoutes(app, httpHandler, db, adminAccess)
 }
 
 func responseWrapper(handlerFunc func(*fiber.Ctx) response.HandledResponse) func(*fiber.Ctx) error {
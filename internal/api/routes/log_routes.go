@@ -3,19 +3,54 @@ package routes
 import (
 	"log-flow/internal/api/handler"
 	"log-flow/internal/api/middleware"
+	"log-flow/internal/domain/models"
 	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/utils/shutdown"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-func mountLogRoutes(app *fiber.App, handler *handler.HttpHandler) {
+func mountLogRoutes(app *fiber.App, handler *handler.HttpHandler, db *gorm.DB, jobAccess *middleware.JobAccess, orgAccess *middleware.OrgAccess, drain *shutdown.Drain) {
 	api := app.Group("/api")
 	app.Use(middleware.RateLimit(config.Env.GeneralRateLimit))
-	api.Use(middleware.AuthMiddleware)
+	api.Use(middleware.AuthMiddleware(db))
+
+	// Scopes API keys need, requests authenticated by JWTs aren't limited by them
+	upload := middleware.RequireScope(models.ScopeUpload)
+	readStats := middleware.RequireScope(models.ScopeReadStats)
+	admin := middleware.RequireScope(models.ScopeAdmin)
+
+	// Actions on jobs recorded in the audit log, denied ones included
+	audit := func(action string) fiber.Handler {
+		return middleware.Audit(db, action, models.AuditTargetJob, "jobID")
+	}
 	{
-		api.Post("/upload-logs", responseWrapper(handler.UploadLogs))
-		api.Get("/stats", responseWrapper(handler.FetchStats))
-		api.Get("/stats/:jobId", middleware.JobAuthorCheck, responseWrapper(handler.FetchStatsByJobId))
-		api.Get("/queue-status", responseWrapper(handler.GetQueueStatus))
+		api.Post("/upload-logs", audit(models.AuditJobUpload), upload, middleware.RejectWhenDraining(drain), orgAccess.Workspace(models.RoleMember), responseWrapper(handler.UploadLogs))
+		api.Get("/stats", readStats, orgAccess.Workspace(models.RoleViewer), responseWrapper(handler.FetchStats))
+		api.Get("/usage", readStats, orgAccess.Workspace(models.RoleViewer), responseWrapper(handler.GetUsage))
+		api.Get("/stats/:jobID", audit(models.AuditJobView), readStats, jobAccess.Check, responseWrapper(handler.FetchStatsByJobId))
+		api.Post("/ws-tickets", readStats, middleware.RejectOrgAPIKeys, responseWrapper(handler.IssueWsTicket))
+		api.Get("/jobs/:jobID/events", audit(models.AuditJobView), readStats, jobAccess.Check, responseWrapper(handler.StreamJobEvents))
+		api.Get("/jobs/:jobID/shares", admin, jobAccess.OwnerCheck, responseWrapper(handler.ListJobShares))
+		api.Post("/jobs/:jobID/shares", audit(models.AuditJobShare), admin, jobAccess.OwnerCheck, responseWrapper(handler.ShareJob))
+		api.Delete("/jobs/:jobID/shares/:userID", audit(models.AuditJobUnshare), admin, jobAccess.OwnerCheck, responseWrapper(handler.UnshareJob))
+		api.Post("/jobs/:jobID/transfer", audit(models.AuditJobTransfer), admin, jobAccess.OwnerCheck, responseWrapper(handler.TransferJob))
+
+		api.Post("/orgs", admin, responseWrapper(handler.CreateOrg))
+		api.Get("/orgs", admin, responseWrapper(handler.ListOrgs))
+		api.Get("/orgs/:orgID/members", admin, orgAccess.RequireRole(models.RoleViewer), responseWrapper(handler.ListOrgMembers))
+		api.Put("/orgs/:orgID/members/:userID", middleware.Audit(db, models.AuditOrgSetMember, models.AuditTargetOrgMember, "orgID", "userID"),
+			admin, orgAccess.RequireRole(models.RoleAdmin), responseWrapper(handler.SaveOrgMember))
+		api.Delete("/orgs/:orgID/members/:userID", middleware.Audit(db, models.AuditOrgRemoveMember, models.AuditTargetOrgMember, "orgID", "userID"),
+			admin, orgAccess.RequireRole(models.RoleViewer), responseWrapper(handler.RemoveOrgMember)) //members can leave
+		api.Get("/orgs/:orgID/keywords", admin, orgAccess.RequireRole(models.RoleViewer), responseWrapper(handler.GetOrgKeywords))
+		api.Put("/orgs/:orgID/keywords", middleware.Audit(db, models.AuditOrgSetKeywords, models.AuditTargetOrg, "orgID"),
+			admin, orgAccess.RequireRole(models.RoleAdmin), responseWrapper(handler.SetOrgKeywords))
+
+		// Keys of the workspace of the request. Only admins manage the keys of an organization.
+		api.Post("/api-keys", middleware.Audit(db, models.AuditAPIKeyCreate, models.AuditTargetAPIKey), admin, orgAccess.Workspace(models.RoleAdmin), responseWrapper(handler.CreateAPIKey))
+		api.Get("/api-keys", admin, orgAccess.Workspace(models.RoleAdmin), responseWrapper(handler.ListAPIKeys))
+		api.Delete("/api-keys/:keyID", middleware.Audit(db, models.AuditAPIKeyRevoke, models.AuditTargetAPIKey, "keyID"), admin, responseWrapper(handler.RevokeAPIKey))
 	}
 }
@@ -0,0 +1,1293 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log-flow/internal/api/handler"
+	"log-flow/internal/api/middleware"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/infrastructure/auth"
+	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/infrastructure/queue"
+	"log-flow/internal/utils/shutdown"
+	"log-flow/pkg/liveprogress"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testJwtSecret = "test-jwt-secret"
+
+	// registered with the local auth provider
+	testEmail    = "user@example.com"
+	testPassword = "password1"
+)
+
+var (
+	owner    = uuid.MustParse("11111111-1111-4111-8111-111111111111")
+	sharee   = uuid.MustParse("22222222-2222-4222-8222-222222222222")
+	stranger = uuid.MustParse("33333333-3333-4333-8333-333333333333")
+
+	// members of org, owned by the owner
+	orgAdmin  = uuid.MustParse("44444444-4444-4444-8444-444444444444")
+	orgMember = uuid.MustParse("55555555-5555-4555-8555-555555555555")
+	orgViewer = uuid.MustParse("66666666-6666-4666-8666-666666666666")
+
+	sysAdmin = uuid.MustParse("77777777-7777-4777-8777-777777777777") //admin of the deployment, the only one
+
+	processedJob = uuid.MustParse("aaaaaaaa-aaaa-4aaa-8aaa-aaaaaaaaaaaa") //of the owner, with a report, shared with the sharee
+	pendingJob   = uuid.MustParse("bbbbbbbb-bbbb-4bbb-8bbb-bbbbbbbbbbbb") //of the owner, without a report yet
+	failedJob    = uuid.MustParse("cccccccc-cccc-4ccc-8ccc-cccccccccccc") //of the owner, out of attempts
+	unknownJob   = uuid.MustParse("dddddddd-dddd-4ddd-8ddd-dddddddddddd")
+	orgJob       = uuid.MustParse("ffffffff-ffff-4fff-8fff-ffffffffffff") //of the org member, uploaded into the org, with a report
+
+	org = uuid.MustParse("eeeeeeee-eeee-4eee-8eee-eeeeeeeeeeee")
+)
+
+type fakeStorage struct {
+	mutex sync.Mutex
+	files map[string][]byte
+}
+
+func (s *fakeStorage) UploadFile(fileHeader *multipart.FileHeader) (string, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	url := "fake://" + fileHeader.Filename
+	s.files[url] = content
+	return url, nil
+}
+
+func (s *fakeStorage) StreamLogs(fileURL string) (io.ReadCloser, error) {
+	return s.StreamLogsFrom(fileURL, 0)
+}
+
+func (s *fakeStorage) StreamLogsFrom(fileURL string, offset int64) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	content, ok := s.files[fileURL]
+	if !ok {
+		return nil, fmt.Errorf("file not found")
+	}
+	return io.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+func (s *fakeStorage) GetFileSize(fileURL string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return int64(len(s.files[fileURL])), nil
+}
+
+type fakeLogQueue struct {
+	mutex   sync.Mutex
+	sent    []queue.LogMessage
+	failed  []queue.LogMessage
+	sendErr error //returned by SendToQueue if not nil
+}
+
+func (q *fakeLogQueue) SendToQueue(logMsg queue.LogMessage) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.sendErr != nil {
+		return q.sendErr
+	}
+	q.sent = append(q.sent, logMsg)
+	return nil
+}
+
+func (q *fakeLogQueue) GetQueueStatus() (map[string]any, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return map[string]any{"log_queue": map[string]any{"messages": len(q.sent), "consumers": 0}}, nil
+}
+
+func (q *fakeLogQueue) ReplayFailed(limit int, afterReplay func(queue.LogMessage) error) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	replayed := 0
+	for replayed < limit && len(q.failed) > 0 {
+		logMsg := q.failed[0]
+		q.sent = append(q.sent, logMsg)
+		q.failed = q.failed[1:]
+		replayed++
+		if err := afterReplay(logMsg); err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// fakeLiveStatusQueue fans out within the process only
+type fakeLiveStatusQueue struct {
+	hub *queue.LiveStatusHub
+}
+
+func (q *fakeLiveStatusQueue) StartQueue(jobID, userID string) (*queue.LiveStatusQueueSession, error) {
+	return nil, fmt.Errorf("not supported by the fake")
+}
+
+func (q *fakeLiveStatusQueue) StartSubscriptions() error { return nil }
+
+func (q *fakeLiveStatusQueue) Subscribe(jobID string) (*queue.LiveStatusSubscription, error) {
+	return q.hub.Subscribe(jobID), nil
+}
+
+func (q *fakeLiveStatusQueue) SubscribeUser(userID string) (*queue.LiveStatusSubscription, error) {
+	return q.hub.SubscribeUser(userID), nil
+}
+
+func (q *fakeLiveStatusQueue) WatchJob(jobID string) func()        { return func() {} }
+func (q *fakeLiveStatusQueue) WatchUser(userID string) func()      { return func() {} }
+func (q *fakeLiveStatusQueue) IsWatched(jobID, userID string) bool { return true }
+func (q *fakeLiveStatusQueue) Close() error                        { return nil }
+
+type testServer struct {
+	app      *fiber.App
+	db       *gorm.DB
+	logQueue *fakeLogQueue
+	hub      *queue.LiveStatusHub
+	drain    *shutdown.Drain
+
+	resetTokens map[string]string //last password reset token sent, by email
+}
+
+// newTestServer mounts all the routes on a fresh database, holding the jobs of the test users
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	config.Env.AuthProvider = config.AuthProviderLocal
+	config.Env.AuthJwtSecret = testJwtSecret
+	config.Env.GeneralRateLimit = 1000
+	config.Env.AuthEndpointsRateLimit = 1000
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Job{}, &models.LogReport{}, &models.JobIP{}, &models.TrackedKeywordsCount{}, &models.JobCheckpoint{},
+		&models.WsTicket{}, &models.WorkerPool{}, &models.JobShare{}, &models.Organization{}, &models.OrgMember{}, &models.OrgKeyword{}, &models.APIKey{}, &models.User{}, &models.PasswordResetToken{}, &models.RevokedToken{}, &models.UserRevocation{}, &models.UserRole{}, &models.AuditEntry{}, &models.Quota{}))
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := models.User{Email: testEmail, PasswordHash: passwordHash}
+	require.NoError(t, user.Create(db))
+	require.NoError(t, models.SetSystemRole(db, sysAdmin, models.SystemRoleAdmin))
+
+	require.NoError(t, db.Create(&models.Organization{ID: org, Name: "Team", CreatedAt: time.Now()}).Error)
+	for userID, role := range map[uuid.UUID]string{owner: models.RoleOwner, orgAdmin: models.RoleAdmin, orgMember: models.RoleMember, orgViewer: models.RoleViewer} {
+		member := models.OrgMember{OrgID: org, UserID: userID, Role: role}
+		require.NoError(t, member.Save(db))
+	}
+	require.NoError(t, models.SetOrgKeywords(db, org, []string{"timeout", "panic"}))
+
+	for _, job := range []models.Job{
+		{ID: processedJob, UserID: owner, FileURL: "fake://processed.log", Attempts: 1, Succeeded: true},
+		{ID: pendingJob, UserID: owner, FileURL: "fake://pending.log"},
+		{ID: failedJob, UserID: owner, FileURL: "fake://failed.log", Attempts: models.JobMaxAttempts},
+		{ID: orgJob, UserID: orgMember, OrgID: &org, FileURL: "fake://org.log", Attempts: 1, Succeeded: true},
+	} {
+		require.NoError(t, job.Create(db))
+	}
+	for _, report := range []models.LogReport{
+		{JobID: processedJob, TotalLogs: 10, ErrorCount: 2, InfoCount: 8},
+		{JobID: orgJob, TotalLogs: 5, ErrorCount: 5},
+	} {
+		require.NoError(t, report.Create(db))
+	}
+	share := models.JobShare{JobID: processedJob, UserID: sharee}
+	require.NoError(t, share.Create(db))
+
+	server := &testServer{
+		app:      fiber.New(fiber.Config{StrictRouting: true}),
+		db:       db,
+		logQueue: &fakeLogQueue{},
+		drain:    shutdown.NewDrain(),
+
+		resetTokens: make(map[string]string),
+	}
+	authProvider := auth.NewLocalProvider(db, 0, 0, func(email, token string) error {
+		server.resetTokens[email] = token
+		return nil
+	})
+	server.hub = queue.NewLiveStatusHub()
+	liveStatusQueue := &fakeLiveStatusQueue{hub: server.hub}
+	orgAccess := middleware.NewOrgAccess(db)
+	adminAccess := middleware.NewAdminAccess(db)
+	jobAccess := middleware.NewJobAccess(db, orgAccess)
+	httpHandler := handler.NewHttpHandler(server.logQueue, liveStatusQueue, &fakeStorage{files: make(map[string][]byte)}, db, authProvider, server.drain, jobAccess, orgAccess, adminAccess)
+	MountRoutes(server.app, httpHandler, handler.NewWebSocketManager(liveStatusQueue, db, jobAccess), db, jobAccess, orgAccess, adminAccess, server.drain)
+	return server
+}
+
+func tokenFor(t *testing.T, userID uuid.UUID, expiresAt time.Time) string {
+	t.Helper()
+	return signToken(t, jwt.MapClaims{"sub": userID.String(), "exp": expiresAt.Unix()})
+}
+
+// signToken signs the claims as the local auth provider would, with its issuer and audience unless given
+func signToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	for claim, value := range map[string]string{"iss": "log-flow", "aud": "log-flow", "jti": uuid.NewString()} {
+		if _, ok := claims[claim]; !ok {
+			claims[claim] = value
+		}
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJwtSecret))
+	require.NoError(t, err)
+	return token
+}
+
+type routeTest struct {
+	name       string
+	method     string
+	path       string
+	user       uuid.UUID //authenticated as, if set
+	header     map[string]string
+	body       any //JSON encoded unless an upload, sent as is if a string
+	wantStatus int
+	wantCode   string //resp_code of the JSON response, if any
+}
+
+// upload is a multipart body, with the file in the "file" field
+type upload struct {
+	filename string
+	content  string
+}
+
+func (s *testServer) do(t *testing.T, test routeTest) (*http.Response, []byte) {
+	t.Helper()
+	var body io.Reader
+	contentType := ""
+	switch requestBody := test.body.(type) {
+	case nil:
+	case upload:
+		buffer := &bytes.Buffer{}
+		writer := multipart.NewWriter(buffer)
+		part, err := writer.CreateFormFile("file", requestBody.filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(requestBody.content))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		body, contentType = buffer, writer.FormDataContentType()
+	case string:
+		body, contentType = strings.NewReader(requestBody), fiber.MIMEApplicationJSON
+	default:
+		encoded, err := json.Marshal(requestBody)
+		require.NoError(t, err)
+		body, contentType = bytes.NewReader(encoded), fiber.MIMEApplicationJSON
+	}
+
+	req := httptest.NewRequest(test.method, test.path, body)
+	if contentType != "" {
+		req.Header.Set(fiber.HeaderContentType, contentType)
+	}
+	if test.user != uuid.Nil {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tokenFor(t, test.user, time.Now().Add(time.Hour)))
+	}
+	for key, value := range test.header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.app.Test(req, 5000)
+	require.NoError(t, err)
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, respBody
+}
+
+func respCode(body []byte) string {
+	var resp struct {
+		Code string `json:"resp_code"`
+	}
+	json.Unmarshal(body, &resp)
+	return resp.Code
+}
+
+var wsUpgrade = map[string]string{
+	"Connection":            "Upgrade",
+	"Upgrade":               "websocket",
+	"Sec-WebSocket-Version": "13",
+	"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+}
+
+func withHeader(header map[string]string, key, value string) map[string]string {
+	merged := map[string]string{key: value}
+	for k, v := range header {
+		merged[k] = v
+	}
+	return merged
+}
+
+func TestRoutes(t *testing.T) {
+	expiredToken := "Bearer " + tokenFor(t, owner, time.Now().Add(-time.Minute))
+	inAnHour := time.Now().Add(time.Hour).Unix()
+	withoutExpiry := "Bearer " + signToken(t, jwt.MapClaims{"sub": owner.String()})
+	ofOtherAudience := "Bearer " + signToken(t, jwt.MapClaims{"sub": owner.String(), "exp": inAnHour, "aud": "other-app"})
+	ofOtherIssuer := "Bearer " + signToken(t, jwt.MapClaims{"sub": owner.String(), "exp": inAnHour, "iss": "https://idp.example.com"})
+	jobPath := func(format string, jobID uuid.UUID) string { return fmt.Sprintf(format, jobID) }
+
+	tests := []routeTest{
+		{name: "health", method: "GET", path: "/health", wantStatus: 200},
+
+		// auth
+		{name: "login", method: "POST", path: "/auth/login", body: map[string]string{"email": testEmail, "password": testPassword}, wantStatus: 200, wantCode: "LOGIN_SUCCESS"},
+		{name: "login with email in other case", method: "POST", path: "/auth/login", body: map[string]string{"email": "User@Example.com", "password": testPassword}, wantStatus: 200, wantCode: "LOGIN_SUCCESS"},
+		{name: "login with wrong password", method: "POST", path: "/auth/login", body: map[string]string{"email": testEmail, "password": "wrong password"}, wantStatus: 401, wantCode: "INVALID_CREDENTIALS"},
+		{name: "login with unknown email", method: "POST", path: "/auth/login", body: map[string]string{"email": "unknown@example.com", "password": testPassword}, wantStatus: 401, wantCode: "INVALID_CREDENTIALS"},
+		{name: "login with malformed body", method: "POST", path: "/auth/login", body: "{", wantStatus: 400, wantCode: "BINDING_ERROR"},
+		{name: "register", method: "POST", path: "/auth/register", body: map[string]string{"email": "new@example.com", "password": testPassword}, wantStatus: 200, wantCode: "SIGNUP_SUCCESS"},
+		{name: "register taken email", method: "POST", path: "/auth/register", body: map[string]string{"email": testEmail, "password": testPassword}, wantStatus: 409, wantCode: "EMAIL_TAKEN"},
+		{name: "register with short password", method: "POST", path: "/auth/register", body: map[string]string{"email": "new@example.com", "password": "short"}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "register with invalid email", method: "POST", path: "/auth/register", body: map[string]string{"email": "new", "password": testPassword}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "request password reset", method: "POST", path: "/auth/password-reset", body: map[string]string{"email": testEmail}, wantStatus: 202, wantCode: "PASSWORD_RESET_REQUESTED"},
+		{name: "request password reset of unknown email", method: "POST", path: "/auth/password-reset", body: map[string]string{"email": "unknown@example.com"}, wantStatus: 202, wantCode: "PASSWORD_RESET_REQUESTED"},
+		{name: "refresh with invalid token", method: "POST", path: "/auth/refresh", body: map[string]string{"refresh_token": "invalid"}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "refresh with access token", method: "POST", path: "/auth/refresh", body: map[string]string{"refresh_token": tokenFor(t, owner, time.Now().Add(time.Hour))}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "refresh without token", method: "POST", path: "/auth/refresh", body: map[string]string{}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "logout", method: "POST", path: "/auth/logout", user: owner, wantStatus: 200, wantCode: "LOGOUT_SUCCESS"},
+		{name: "logout without token", method: "POST", path: "/auth/logout", wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "logout with refresh token of other user", method: "POST", path: "/auth/logout", user: owner, body: map[string]string{"refresh_token": signToken(t, jwt.MapClaims{"sub": stranger.String(), "exp": inAnHour, "token_type": "refresh"})}, wantStatus: 400, wantCode: "INVALID_REFRESH_TOKEN"},
+		{name: "reset password with invalid token", method: "POST", path: "/auth/password-reset/confirm", body: map[string]string{"email": testEmail, "token": "invalid", "password": "password2"}, wantStatus: 400, wantCode: "INVALID_RESET_TOKEN"},
+
+		// authentication of the api
+		{name: "stats without token", method: "GET", path: "/api/stats", wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "stats with invalid token", method: "GET", path: "/api/stats", header: map[string]string{"Authorization": "Bearer invalid"}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "stats with expired token", method: "GET", path: "/api/stats", header: map[string]string{"Authorization": expiredToken}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "stats with token without expiry", method: "GET", path: "/api/stats", header: map[string]string{"Authorization": withoutExpiry}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "stats with token of other audience", method: "GET", path: "/api/stats", header: map[string]string{"Authorization": ofOtherAudience}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "stats with token of other issuer", method: "GET", path: "/api/stats", header: map[string]string{"Authorization": ofOtherIssuer}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "stats", method: "GET", path: "/api/stats", user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "stats in range", method: "GET", path: "/api/stats?from=2020-01-01&to=2999-01-01", user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "stats with invalid date", method: "GET", path: "/api/stats?from=yesterday", user: owner, wantStatus: 400, wantCode: "INVALID_DATE"},
+		{name: "stats with inverted range", method: "GET", path: "/api/stats?from=2024-02-01&to=2024-01-01", user: owner, wantStatus: 400, wantCode: "INVALID_DATE_RANGE"},
+
+		// usage
+		{name: "usage", method: "GET", path: "/api/usage", user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "usage of org as viewer", method: "GET", path: "/api/usage", user: orgViewer, header: map[string]string{"X-Workspace-ID": org.String()}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "usage of org as stranger", method: "GET", path: "/api/usage", user: stranger, header: map[string]string{"X-Workspace-ID": org.String()}, wantStatus: 404, wantCode: "ORG_NOT_FOUND"},
+		{name: "usage without token", method: "GET", path: "/api/usage", wantStatus: 401, wantCode: "UNAUTHORIZED"},
+
+		// stats of a job
+		{name: "job stats of owner", method: "GET", path: jobPath("/api/stats/%s", processedJob), user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "job stats of sharee", method: "GET", path: jobPath("/api/stats/%s", processedJob), user: sharee, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "job stats of stranger", method: "GET", path: jobPath("/api/stats/%s", processedJob), user: stranger, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "job stats of job not shared with sharee", method: "GET", path: jobPath("/api/stats/%s", pendingJob), user: sharee, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "job stats without token", method: "GET", path: jobPath("/api/stats/%s", processedJob), wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "job stats of unknown job", method: "GET", path: jobPath("/api/stats/%s", unknownJob), user: owner, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "job stats of invalid job ID", method: "GET", path: "/api/stats/not-a-uuid", user: owner, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "job stats before report", method: "GET", path: jobPath("/api/stats/%s", pendingJob), user: owner, wantStatus: 404, wantCode: "REPORT_NOT_FOUND"},
+
+		// job events
+		{name: "events of processed job", method: "GET", path: jobPath("/api/jobs/%s/events", processedJob), user: owner, wantStatus: 200},
+		{name: "events of processed job for sharee", method: "GET", path: jobPath("/api/jobs/%s/events", processedJob), user: sharee, wantStatus: 200},
+		{name: "events of failed job", method: "GET", path: jobPath("/api/jobs/%s/events", failedJob), user: owner, wantStatus: 200},
+		{name: "events of stranger", method: "GET", path: jobPath("/api/jobs/%s/events", processedJob), user: stranger, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "events of invalid job ID", method: "GET", path: "/api/jobs/not-a-uuid/events", user: owner, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+
+		// upload
+		{name: "upload", method: "POST", path: "/api/upload-logs", user: owner, body: upload{"app.log", "2024-01-01T00:00:00Z INFO started\n"}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "upload without file", method: "POST", path: "/api/upload-logs", user: owner, wantStatus: 400, wantCode: "INVALID_FILE"},
+		{name: "upload of unsupported file", method: "POST", path: "/api/upload-logs", user: owner, body: upload{"app.exe", "binary"}, wantStatus: 400, wantCode: "NOT_SUPPORTED_FILE"},
+		{name: "upload without token", method: "POST", path: "/api/upload-logs", body: upload{"app.log", "line\n"}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+
+		// misc
+		{name: "ws ticket", method: "POST", path: "/api/ws-tickets", user: owner, wantStatus: 201, wantCode: "CREATED"},
+		{name: "ws ticket without token", method: "POST", path: "/api/ws-tickets", wantStatus: 401, wantCode: "UNAUTHORIZED"},
+
+		// shares
+		{name: "list shares", method: "GET", path: jobPath("/api/jobs/%s/shares", processedJob), user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "list shares as sharee", method: "GET", path: jobPath("/api/jobs/%s/shares", processedJob), user: sharee, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "list shares as stranger", method: "GET", path: jobPath("/api/jobs/%s/shares", processedJob), user: stranger, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "share", method: "POST", path: jobPath("/api/jobs/%s/shares", pendingJob), user: owner, body: map[string]any{"userID": stranger}, wantStatus: 201, wantCode: "CREATED"},
+		{name: "share again", method: "POST", path: jobPath("/api/jobs/%s/shares", processedJob), user: owner, body: map[string]any{"userID": sharee}, wantStatus: 201, wantCode: "CREATED"},
+		{name: "share with self", method: "POST", path: jobPath("/api/jobs/%s/shares", pendingJob), user: owner, body: map[string]any{"userID": owner}, wantStatus: 400, wantCode: "WRONG_INPUT"},
+		{name: "share without user", method: "POST", path: jobPath("/api/jobs/%s/shares", pendingJob), user: owner, body: map[string]any{}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "share with invalid user", method: "POST", path: jobPath("/api/jobs/%s/shares", pendingJob), user: owner, body: map[string]any{"userID": "someone"}, wantStatus: 400, wantCode: "BINDING_ERROR"},
+		{name: "share as sharee", method: "POST", path: jobPath("/api/jobs/%s/shares", processedJob), user: sharee, body: map[string]any{"userID": stranger}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "unshare", method: "DELETE", path: fmt.Sprintf("/api/jobs/%s/shares/%s", processedJob, sharee), user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "unshare not shared", method: "DELETE", path: fmt.Sprintf("/api/jobs/%s/shares/%s", processedJob, stranger), user: owner, wantStatus: 404, wantCode: "NOT_FOUND"},
+		{name: "unshare invalid user", method: "DELETE", path: fmt.Sprintf("/api/jobs/%s/shares/someone", processedJob), user: owner, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "unshare as sharee", method: "DELETE", path: fmt.Sprintf("/api/jobs/%s/shares/%s", processedJob, sharee), user: sharee, wantStatus: 403, wantCode: "FORBIDDEN"},
+
+		// transfer
+		{name: "transfer", method: "POST", path: jobPath("/api/jobs/%s/transfer", processedJob), user: owner, body: map[string]any{"userID": stranger}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "transfer to self", method: "POST", path: jobPath("/api/jobs/%s/transfer", processedJob), user: owner, body: map[string]any{"userID": owner}, wantStatus: 400, wantCode: "WRONG_INPUT"},
+		{name: "transfer as sharee", method: "POST", path: jobPath("/api/jobs/%s/transfer", processedJob), user: sharee, body: map[string]any{"userID": sharee}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "transfer unknown job", method: "POST", path: jobPath("/api/jobs/%s/transfer", unknownJob), user: owner, body: map[string]any{"userID": stranger}, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+
+		// websockets, up to the upgrade
+		{name: "live stats without upgrade", method: "GET", path: jobPath("/api/live-stats/%s", processedJob), user: owner, wantStatus: 426, wantCode: "UPGRADE_REQUIRED"},
+		{name: "live stats without token", method: "GET", path: jobPath("/api/live-stats/%s", processedJob), header: wsUpgrade, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "live stats with invalid ticket", method: "GET", path: jobPath("/api/live-stats/%s?ticket=invalid", processedJob), header: wsUpgrade, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "live stats of stranger", method: "GET", path: jobPath("/api/live-stats/%s", processedJob), user: stranger, header: wsUpgrade, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "live stats of invalid job ID", method: "GET", path: "/api/live-stats/not-a-uuid", user: owner, header: wsUpgrade, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "live stats with expired token", method: "GET", path: jobPath("/api/live-stats/%s", processedJob), header: withHeader(wsUpgrade, "Authorization", expiredToken), wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "feed without upgrade", method: "GET", path: "/api/live-stats", user: owner, wantStatus: 426, wantCode: "UPGRADE_REQUIRED"},
+		{name: "feed without token", method: "GET", path: "/api/live-stats", header: wsUpgrade, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+
+		// organizations
+		{name: "create org", method: "POST", path: "/api/orgs", user: stranger, body: map[string]any{"name": "New team"}, wantStatus: 201, wantCode: "CREATED"},
+		{name: "create org without name", method: "POST", path: "/api/orgs", user: stranger, body: map[string]any{}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "list orgs", method: "GET", path: "/api/orgs", user: orgViewer, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "list orgs without token", method: "GET", path: "/api/orgs", wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "org members as viewer", method: "GET", path: jobPath("/api/orgs/%s/members", org), user: orgViewer, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "org members as stranger", method: "GET", path: jobPath("/api/orgs/%s/members", org), user: stranger, wantStatus: 404, wantCode: "ORG_NOT_FOUND"},
+		{name: "org members of invalid org ID", method: "GET", path: "/api/orgs/not-a-uuid/members", user: owner, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "add member as admin", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: orgAdmin, body: map[string]any{"role": "viewer"}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "add member as member", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: orgMember, body: map[string]any{"role": "viewer"}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "add owner as admin", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: orgAdmin, body: map[string]any{"role": "owner"}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "demote owner as admin", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, owner), user: orgAdmin, body: map[string]any{"role": "viewer"}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "add owner as owner", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: owner, body: map[string]any{"role": "owner"}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "add member with invalid role", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: owner, body: map[string]any{"role": "boss"}, wantStatus: 400, wantCode: "INVALID_ROLE"},
+		{name: "demote last owner", method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, owner), user: owner, body: map[string]any{"role": "admin"}, wantStatus: 409, wantCode: "LAST_OWNER"},
+		{name: "leave org", method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, orgViewer), user: orgViewer, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "remove member as member", method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, orgViewer), user: orgMember, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "remove member as admin", method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, orgViewer), user: orgAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "remove owner as admin", method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, owner), user: orgAdmin, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "remove non member", method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: orgAdmin, wantStatus: 404, wantCode: "NOT_FOUND"},
+		{name: "leave org as last owner", method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, owner), user: owner, wantStatus: 409, wantCode: "LAST_OWNER"},
+		{name: "org keywords as viewer", method: "GET", path: jobPath("/api/orgs/%s/keywords", org), user: orgViewer, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "set org keywords as admin", method: "PUT", path: jobPath("/api/orgs/%s/keywords", org), user: orgAdmin, body: map[string]any{"keywords": []string{"oom"}}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "set org keywords as member", method: "PUT", path: jobPath("/api/orgs/%s/keywords", org), user: orgMember, body: map[string]any{"keywords": []string{"oom"}}, wantStatus: 403, wantCode: "FORBIDDEN"},
+
+		// workspaces
+		{name: "stats of org as viewer", method: "GET", path: "/api/stats", user: orgViewer, header: map[string]string{"X-Workspace-ID": org.String()}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "stats of org by query param", method: "GET", path: "/api/stats?workspace=" + org.String(), user: orgViewer, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "stats of org as stranger", method: "GET", path: "/api/stats", user: stranger, header: map[string]string{"X-Workspace-ID": org.String()}, wantStatus: 404, wantCode: "ORG_NOT_FOUND"},
+		{name: "stats of invalid workspace", method: "GET", path: "/api/stats", user: owner, header: map[string]string{"X-Workspace-ID": "team"}, wantStatus: 400, wantCode: "INVALID_WORKSPACE"},
+		{name: "upload into org as member", method: "POST", path: "/api/upload-logs", user: orgMember, header: map[string]string{"X-Workspace-ID": org.String()}, body: upload{"app.log", "line\n"}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "upload into org as viewer", method: "POST", path: "/api/upload-logs", user: orgViewer, header: map[string]string{"X-Workspace-ID": org.String()}, body: upload{"app.log", "line\n"}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "org job stats as viewer", method: "GET", path: jobPath("/api/stats/%s", orgJob), user: orgViewer, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "org job stats as stranger", method: "GET", path: jobPath("/api/stats/%s", orgJob), user: stranger, wantStatus: 404, wantCode: "JOB_NOT_FOUND"},
+		{name: "share org job as viewer", method: "POST", path: jobPath("/api/jobs/%s/shares", orgJob), user: orgViewer, body: map[string]any{"userID": stranger}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "share org job as admin", method: "POST", path: jobPath("/api/jobs/%s/shares", orgJob), user: orgAdmin, body: map[string]any{"userID": stranger}, wantStatus: 201, wantCode: "CREATED"},
+		{name: "share org job as uploader", method: "POST", path: jobPath("/api/jobs/%s/shares", orgJob), user: orgMember, body: map[string]any{"userID": stranger}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "transfer org job to non member", method: "POST", path: jobPath("/api/jobs/%s/transfer", orgJob), user: orgAdmin, body: map[string]any{"userID": stranger}, wantStatus: 400, wantCode: "NOT_ORG_MEMBER"},
+		{name: "transfer org job to member", method: "POST", path: jobPath("/api/jobs/%s/transfer", orgJob), user: orgAdmin, body: map[string]any{"userID": orgViewer}, wantStatus: 200, wantCode: "SUCCESS"},
+
+		// API keys
+		{name: "create API key", method: "POST", path: "/api/api-keys", user: owner, body: map[string]any{"name": "ci", "scopes": []string{"upload"}}, wantStatus: 201, wantCode: "CREATED"},
+		{name: "create API key with invalid scope", method: "POST", path: "/api/api-keys", user: owner, body: map[string]any{"name": "ci", "scopes": []string{"everything"}}, wantStatus: 400, wantCode: "INVALID_SCOPE"},
+		{name: "create API key without scopes", method: "POST", path: "/api/api-keys", user: owner, body: map[string]any{"name": "ci"}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "create API key of org as stranger", method: "POST", path: "/api/api-keys", user: stranger, header: map[string]string{"X-Workspace-ID": org.String()}, body: map[string]any{"name": "ci", "scopes": []string{"upload"}}, wantStatus: 404, wantCode: "ORG_NOT_FOUND"},
+		{name: "list API keys", method: "GET", path: "/api/api-keys", user: owner, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "revoke unknown API key", method: "DELETE", path: "/api/api-keys/" + uuid.NewString(), user: owner, wantStatus: 404, wantCode: "NOT_FOUND"},
+		{name: "revoke API key of invalid ID", method: "DELETE", path: "/api/api-keys/not-a-uuid", user: owner, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "stats with unknown API key", method: "GET", path: "/api/stats", header: map[string]string{"X-API-Key": "lf_unknown"}, wantStatus: 401, wantCode: "UNAUTHORIZED"},
+
+		// admin
+		{name: "queue status", method: "GET", path: "/admin/queue-status", user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "queue status as user", method: "GET", path: "/admin/queue-status", user: owner, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "queue status without token", method: "GET", path: "/admin/queue-status", wantStatus: 401, wantCode: "UNAUTHORIZED"},
+		{name: "queue status out of the admin group", method: "GET", path: "/api/queue-status", user: sysAdmin, wantStatus: 404},
+		{name: "replay failed jobs", method: "POST", path: "/admin/failed-jobs/replay?limit=10", user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "replay failed jobs as user", method: "POST", path: "/admin/failed-jobs/replay", user: owner, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "replay failed jobs with invalid limit", method: "POST", path: "/admin/failed-jobs/replay?limit=0", user: sysAdmin, wantStatus: 400, wantCode: "INVALID_LIMIT"},
+		{name: "system stats", method: "GET", path: "/admin/stats?from=2020-01-01", user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "system stats as user", method: "GET", path: "/admin/stats", user: owner, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "system stats with invalid date", method: "GET", path: "/admin/stats?to=tomorrow", user: sysAdmin, wantStatus: 400, wantCode: "INVALID_DATE"},
+		{name: "list users", method: "GET", path: "/admin/users", user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "list users as user", method: "GET", path: "/admin/users", user: owner, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "make admin", method: "PUT", path: fmt.Sprintf("/admin/users/%s/role", owner), user: sysAdmin, body: map[string]any{"role": "admin"}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "make admin as user", method: "PUT", path: fmt.Sprintf("/admin/users/%s/role", owner), user: owner, body: map[string]any{"role": "admin"}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "set invalid role", method: "PUT", path: fmt.Sprintf("/admin/users/%s/role", owner), user: sysAdmin, body: map[string]any{"role": "root"}, wantStatus: 400, wantCode: "INVALID_ROLE"},
+		{name: "set role of invalid user ID", method: "PUT", path: "/admin/users/someone/role", user: sysAdmin, body: map[string]any{"role": "admin"}, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "demote last admin", method: "PUT", path: fmt.Sprintf("/admin/users/%s/role", sysAdmin), user: sysAdmin, body: map[string]any{"role": "user"}, wantStatus: 409, wantCode: "LAST_ADMIN"},
+		{name: "delete last admin", method: "DELETE", path: fmt.Sprintf("/admin/users/%s", sysAdmin), user: sysAdmin, wantStatus: 409, wantCode: "LAST_ADMIN"},
+		{name: "delete unknown user", method: "DELETE", path: fmt.Sprintf("/admin/users/%s", stranger), user: sysAdmin, wantStatus: 404, wantCode: "NOT_FOUND"},
+		{name: "quota of user", method: "GET", path: fmt.Sprintf("/admin/users/%s/quota", owner), user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "set quota of user", method: "PUT", path: fmt.Sprintf("/admin/users/%s/quota", owner), user: sysAdmin, body: map[string]any{"jobsPerDay": 10, "storageMB": 0}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "set quota of user as user", method: "PUT", path: fmt.Sprintf("/admin/users/%s/quota", owner), user: owner, body: map[string]any{"jobsPerDay": 1000}, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "set negative quota", method: "PUT", path: fmt.Sprintf("/admin/users/%s/quota", owner), user: sysAdmin, body: map[string]any{"concurrentJobs": -1}, wantStatus: 400, wantCode: "VALIDATION_ERROR"},
+		{name: "set quota of invalid user ID", method: "PUT", path: "/admin/users/someone/quota", user: sysAdmin, body: map[string]any{}, wantStatus: 400, wantCode: "INVALID_URL_PARAM"},
+		{name: "quota of org", method: "GET", path: fmt.Sprintf("/admin/orgs/%s/quota", org), user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "set quota of org", method: "PUT", path: fmt.Sprintf("/admin/orgs/%s/quota", org), user: sysAdmin, body: map[string]any{"concurrentJobs": 5}, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "audit log", method: "GET", path: "/admin/audit?action=job.view&result=denied&from=2020-01-01", user: sysAdmin, wantStatus: 200, wantCode: "SUCCESS"},
+		{name: "audit log as user", method: "GET", path: "/admin/audit", user: owner, wantStatus: 403, wantCode: "FORBIDDEN"},
+		{name: "audit log export", method: "GET", path: "/admin/audit?format=csv", user: sysAdmin, wantStatus: 200},
+		{name: "audit log of invalid actor", method: "GET", path: "/admin/audit?actor=someone", user: sysAdmin, wantStatus: 400, wantCode: "WRONG_INPUT"},
+		{name: "audit log before invalid entry", method: "GET", path: "/admin/audit?before=last", user: sysAdmin, wantStatus: 400, wantCode: "WRONG_INPUT"},
+		{name: "audit log with invalid limit", method: "GET", path: "/admin/audit?limit=5000", user: sysAdmin, wantStatus: 400, wantCode: "INVALID_LIMIT"},
+
+		{name: "unknown route", method: "GET", path: "/api/unknown", user: owner, wantStatus: 404},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t)
+			resp, body := server.do(t, test)
+			assert.Equal(t, test.wantStatus, resp.StatusCode, "body: %s", body)
+			if test.wantCode != "" {
+				assert.Equal(t, test.wantCode, respCode(body), "body: %s", body)
+			}
+		})
+	}
+}
+
+func TestUploadQueuesJobOfUser(t *testing.T) {
+	server := newTestServer(t)
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/api/upload-logs", user: owner, body: upload{"app.log", "2024-01-01T00:00:00Z INFO started\n"}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+
+	var uploaded struct {
+		Data struct {
+			JobID string `json:"jobID"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &uploaded))
+	job, err := models.GetJobByID(server.db, uploaded.Data.JobID)
+	require.NoError(t, err)
+	assert.Equal(t, owner, job.UserID)
+	assert.NotEqual(t, owner.String()[:8], job.ID.String()[:8], "job IDs shouldn't be derived from the user ID")
+
+	require.Len(t, server.logQueue.sent, 1)
+	assert.Equal(t, uploaded.Data.JobID, server.logQueue.sent[0].JobID)
+	assert.Equal(t, owner.String(), server.logQueue.sent[0].UserID)
+	assert.Equal(t, int64(len("2024-01-01T00:00:00Z INFO started\n")), server.logQueue.sent[0].FileSize)
+}
+
+func TestUploadRejectedWhenDraining(t *testing.T) {
+	server := newTestServer(t)
+	server.drain.Start()
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/api/upload-logs", user: owner, body: upload{"app.log", "line\n"}})
+	assert.Equal(t, 503, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, "SERVICE_UNAVAILABLE", respCode(body))
+	assert.NotEmpty(t, resp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Empty(t, server.logQueue.sent)
+}
+
+func TestJobEventsFailOnlyOnceTheLastAttemptFails(t *testing.T) {
+	server := newTestServer(t)
+	eventsPath := fmt.Sprintf("/api/jobs/%s/events", failedJob)
+
+	resp, body := server.do(t, routeTest{method: "GET", path: eventsPath, user: owner})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Contains(t, string(body), "event: failed\n")
+
+	// On its last attempt, the job is streamed till it ends, here right away as the server drains
+	require.NoError(t, models.RenewJobLease(server.db, failedJob.String(), time.Now().Add(time.Minute)))
+	server.drain.Start()
+	resp, body = server.do(t, routeTest{method: "GET", path: eventsPath, user: owner})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.NotContains(t, string(body), "event: failed", "a job on its last attempt shouldn't be failed yet")
+}
+
+func TestJobAccessFollowsSharesAndTransfers(t *testing.T) {
+	server := newTestServer(t)
+	stats := func(user uuid.UUID) int {
+		resp, _ := server.do(t, routeTest{method: "GET", path: fmt.Sprintf("/api/stats/%s", processedJob), user: user})
+		return resp.StatusCode
+	}
+	post := func(path string, user uuid.UUID) {
+		resp, body := server.do(t, routeTest{method: "POST", path: fmt.Sprintf(path, processedJob), user: owner, body: map[string]any{"userID": user}})
+		require.Less(t, resp.StatusCode, 300, "body: %s", body)
+	}
+
+	require.Equal(t, 404, stats(stranger)) //cached from now on
+	post("/api/jobs/%s/shares", stranger)
+	assert.Equal(t, 200, stats(stranger), "sharing should apply right away")
+
+	resp, body := server.do(t, routeTest{method: "DELETE", path: fmt.Sprintf("/api/jobs/%s/shares/%s", processedJob, stranger), user: owner})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, 404, stats(stranger), "unsharing should apply right away")
+
+	post("/api/jobs/%s/transfer", stranger)
+	assert.Equal(t, 200, stats(stranger), "the new owner should have access")
+	assert.Equal(t, 404, stats(owner), "the previous owner should have lost access")
+	assert.Equal(t, 200, stats(sharee), "shares should be kept")
+
+	resp, body = server.do(t, routeTest{method: "POST", path: fmt.Sprintf("/api/jobs/%s/shares", processedJob), user: stranger, body: map[string]any{"userID": owner}})
+	assert.Equal(t, 201, resp.StatusCode, "the new owner should be able to share, body: %s", body)
+}
+
+func TestWorkspacesScopeJobsAndStats(t *testing.T) {
+	server := newTestServer(t)
+	inOrg := map[string]string{"X-Workspace-ID": org.String()}
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/api/upload-logs", user: orgMember, header: inOrg, body: upload{"app.log", "line\n"}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	require.Len(t, server.logQueue.sent, 1)
+	queued := server.logQueue.sent[0]
+	assert.Equal(t, org.String(), queued.OrgID)
+	assert.ElementsMatch(t, []string{"timeout", "panic"}, queued.Keywords, "the keyword profile of the org should be tracked")
+	job, err := models.GetJobByID(server.db, queued.JobID)
+	require.NoError(t, err)
+	require.NotNil(t, job.OrgID)
+	assert.Equal(t, org, *job.OrgID)
+
+	totalLogs := func(user uuid.UUID, header map[string]string) float64 {
+		resp, body := server.do(t, routeTest{method: "GET", path: "/api/stats", user: user, header: header})
+		require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+		var stats struct {
+			Data map[string]any `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &stats))
+		return stats.Data["totalLogs"].(float64)
+	}
+	assert.Equal(t, float64(5), totalLogs(orgViewer, inOrg), "the org should have the jobs uploaded into it by any member")
+	assert.Equal(t, float64(10), totalLogs(owner, nil), "the personal workspace should leave out the jobs of orgs")
+	assert.Equal(t, float64(0), totalLogs(orgMember, nil), "the personal workspace of the uploader should leave out the jobs of orgs")
+}
+
+func TestOrgMembershipChangesApplyRightAway(t *testing.T) {
+	server := newTestServer(t)
+	orgJobStats := func(user uuid.UUID) int {
+		resp, _ := server.do(t, routeTest{method: "GET", path: fmt.Sprintf("/api/stats/%s", orgJob), user: user})
+		return resp.StatusCode
+	}
+
+	require.Equal(t, 404, orgJobStats(stranger)) //cached from now on
+	resp, body := server.do(t, routeTest{method: "PUT", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: orgAdmin, body: map[string]any{"role": "viewer"}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, 200, orgJobStats(stranger), "new members should have access to the jobs of the org")
+
+	resp, body = server.do(t, routeTest{method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, stranger), user: stranger})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, 404, orgJobStats(stranger), "members who left should lose access")
+
+	resp, body = server.do(t, routeTest{method: "DELETE", path: fmt.Sprintf("/api/orgs/%s/members/%s", org, orgMember), user: orgMember})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, 404, orgJobStats(orgMember), "uploaders who left should lose access to the jobs they uploaded into the org")
+
+	added, err := models.GetAuditEntries(server.db, models.AuditFilter{Action: models.AuditOrgSetMember})
+	require.NoError(t, err)
+	require.Len(t, added, 1)
+	assert.Equal(t, fmt.Sprintf("%s/%s", org, stranger), added[0].TargetID, "membership changes should be recorded with the org and the user")
+	removed, err := models.GetAuditEntries(server.db, models.AuditFilter{Action: models.AuditOrgRemoveMember})
+	require.NoError(t, err)
+	assert.Len(t, removed, 2)
+}
+
+// createAPIKey creates a key as the user, in the workspace of the header, and returns it with its ID
+func (s *testServer) createAPIKey(t *testing.T, user uuid.UUID, header map[string]string, body map[string]any) (key, keyID string) {
+	t.Helper()
+	resp, respBody := s.do(t, routeTest{method: "POST", path: "/api/api-keys", user: user, header: header, body: body})
+	require.Equal(t, 201, resp.StatusCode, "body: %s", respBody)
+	var created struct {
+		Data struct {
+			Key    string `json:"key"`
+			APIKey struct {
+				ID string `json:"id"`
+			} `json:"apiKey"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(respBody, &created))
+	require.True(t, strings.HasPrefix(created.Data.Key, models.APIKeyPrefix), "key: %s", created.Data.Key)
+	return created.Data.Key, created.Data.APIKey.ID
+}
+
+func TestAPIKeysAuthenticateWithinTheirScopes(t *testing.T) {
+	server := newTestServer(t)
+	key, keyID := server.createAPIKey(t, owner, nil, map[string]any{"name": "ci", "scopes": []string{"read-stats"}})
+	bearer := map[string]string{fiber.HeaderAuthorization: "Bearer " + key}
+	header := map[string]string{"X-API-Key": key}
+
+	resp, body := server.do(t, routeTest{method: "GET", path: fmt.Sprintf("/api/stats/%s", processedJob), header: bearer})
+	assert.Equal(t, 200, resp.StatusCode, "the key should be accepted as bearer token, body: %s", body)
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/stats", header: header})
+	assert.Equal(t, 200, resp.StatusCode, "the key should be accepted in its header, body: %s", body)
+
+	resp, body = server.do(t, routeTest{method: "POST", path: "/api/upload-logs", header: header, body: upload{"app.log", "line\n"}})
+	assert.Equal(t, 403, resp.StatusCode, "body: %s", body)
+	assert.Empty(t, server.logQueue.sent)
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/api-keys", header: header})
+	assert.Equal(t, 403, resp.StatusCode, "managing keys should need the admin scope, body: %s", body)
+
+	apiKey, err := models.GetAPIKeyByID(server.db, keyID)
+	require.NoError(t, err)
+	assert.NotNil(t, apiKey.LastUsedAt, "the use of the key should be tracked")
+	assert.NotContains(t, string(apiKey.KeyHash), key, "the key shouldn't be stored as is")
+
+	resp, body = server.do(t, routeTest{method: "DELETE", path: "/api/api-keys/" + keyID, user: stranger})
+	assert.Equal(t, 404, resp.StatusCode, "only the creator should revoke personal keys, body: %s", body)
+	resp, body = server.do(t, routeTest{method: "DELETE", path: "/api/api-keys/" + keyID, user: owner})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/stats", header: header})
+	assert.Equal(t, 401, resp.StatusCode, "revoked keys should be rejected right away, body: %s", body)
+}
+
+func TestAPIKeysExpire(t *testing.T) {
+	server := newTestServer(t)
+	key, keyID := server.createAPIKey(t, owner, nil, map[string]any{"name": "ci", "scopes": []string{"admin"}, "expiresInDays": 1})
+	header := map[string]string{"X-API-Key": key}
+
+	resp, body := server.do(t, routeTest{method: "GET", path: "/api/stats", header: header})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+
+	require.NoError(t, server.db.Model(&models.APIKey{}).Where("id = ?", keyID).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/stats", header: header})
+	assert.Equal(t, 401, resp.StatusCode, "body: %s", body)
+}
+
+func TestOrgAPIKeysAreConfinedToTheirOrg(t *testing.T) {
+	server := newTestServer(t)
+	inOrg := map[string]string{"X-Workspace-ID": org.String()}
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/api/api-keys", user: orgMember, header: inOrg, body: map[string]any{"name": "ci", "scopes": []string{"upload"}}})
+	assert.Equal(t, 403, resp.StatusCode, "only admins should create keys of an org, body: %s", body)
+
+	key, keyID := server.createAPIKey(t, owner, inOrg, map[string]any{"name": "shipper", "scopes": []string{"upload", "read-stats"}})
+	header := map[string]string{"X-API-Key": key}
+
+	resp, body = server.do(t, routeTest{method: "POST", path: "/api/upload-logs", header: header, body: upload{"app.log", "line\n"}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	require.Len(t, server.logQueue.sent, 1)
+	assert.Equal(t, org.String(), server.logQueue.sent[0].OrgID, "keys of an org should upload into it")
+
+	resp, body = server.do(t, routeTest{method: "GET", path: fmt.Sprintf("/api/stats/%s", orgJob), header: header})
+	assert.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	resp, body = server.do(t, routeTest{method: "GET", path: fmt.Sprintf("/api/stats/%s", processedJob), header: header})
+	assert.Equal(t, 404, resp.StatusCode, "personal jobs of the creator should be out of reach, body: %s", body)
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/stats?workspace=" + uuid.NewString(), header: header})
+	assert.Equal(t, 403, resp.StatusCode, "other workspaces should be out of reach, body: %s", body)
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/live-stats", header: withHeader(wsUpgrade, "X-API-Key", key)})
+	assert.Equal(t, 403, resp.StatusCode, "the feed of all the jobs of the creator should be out of reach, body: %s", body)
+	resp, body = server.do(t, routeTest{method: "POST", path: "/api/ws-tickets", header: header})
+	assert.Equal(t, 403, resp.StatusCode, "tickets, which aren't limited to the org, should be out of reach, body: %s", body)
+
+	resp, body = server.do(t, routeTest{method: "GET", path: "/api/api-keys", user: orgAdmin, header: inOrg})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Contains(t, string(body), keyID, "admins should list the keys of the org")
+	resp, body = server.do(t, routeTest{method: "DELETE", path: "/api/api-keys/" + keyID, user: orgMember})
+	assert.Equal(t, 404, resp.StatusCode, "members shouldn't revoke keys of the org, body: %s", body)
+	resp, body = server.do(t, routeTest{method: "DELETE", path: "/api/api-keys/" + keyID, user: orgAdmin})
+	assert.Equal(t, 200, resp.StatusCode, "admins should revoke keys of the org, body: %s", body)
+}
+
+func TestLocalAuthProvider(t *testing.T) {
+	server := newTestServer(t)
+	login := func(password string) (int, map[string]any) {
+		resp, body := server.do(t, routeTest{method: "POST", path: "/auth/login", body: map[string]string{"email": testEmail, "password": password}})
+		var session struct {
+			Data map[string]any `json:"data"`
+		}
+		json.Unmarshal(body, &session)
+		return resp.StatusCode, session.Data
+	}
+	stats := func(token any) int {
+		resp, _ := server.do(t, routeTest{method: "GET", path: "/api/stats", header: map[string]string{fiber.HeaderAuthorization: fmt.Sprintf("Bearer %v", token)}})
+		return resp.StatusCode
+	}
+
+	status, session := login(testPassword)
+	require.Equal(t, 200, status)
+	assert.Equal(t, 200, stats(session["access_token"]), "access tokens should authenticate")
+	assert.Equal(t, 401, stats(session["refresh_token"]), "refresh tokens shouldn't be accepted as access tokens")
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/auth/password-reset", body: map[string]string{"email": testEmail}})
+	require.Equal(t, 202, resp.StatusCode, "body: %s", body)
+	token := server.resetTokens[testEmail]
+	require.NotEmpty(t, token)
+	resetPassword := func(email string) int {
+		resp, _ := server.do(t, routeTest{method: "POST", path: "/auth/password-reset/confirm", body: map[string]string{"email": email, "token": token, "password": "password2"}})
+		return resp.StatusCode
+	}
+	assert.Equal(t, 400, resetPassword("new@example.com"), "tokens should be valid for their user only")
+	assert.Equal(t, 200, resetPassword(testEmail))
+	assert.Equal(t, 400, resetPassword(testEmail), "tokens should be used once")
+
+	status, _ = login(testPassword)
+	assert.Equal(t, 401, status, "the previous password should be rejected")
+	status, _ = login("password2")
+	assert.Equal(t, 200, status)
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	server := newTestServer(t)
+	type session struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode := func(body []byte) session {
+		var resp struct {
+			Data session `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &resp), "body: %s", body)
+		return resp.Data
+	}
+	refresh := func(refreshToken string) (int, session) {
+		resp, body := server.do(t, routeTest{method: "POST", path: "/auth/refresh", body: map[string]string{"refresh_token": refreshToken}})
+		if resp.StatusCode != 200 {
+			return resp.StatusCode, session{}
+		}
+		return resp.StatusCode, decode(body)
+	}
+	stats := func(accessToken string) int {
+		resp, _ := server.do(t, routeTest{method: "GET", path: "/api/stats", header: map[string]string{fiber.HeaderAuthorization: "Bearer " + accessToken}})
+		return resp.StatusCode
+	}
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/auth/login", body: map[string]string{"email": testEmail, "password": testPassword}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	first := decode(body)
+
+	status, second := refresh(first.RefreshToken)
+	require.Equal(t, 200, status)
+	assert.Equal(t, 200, stats(second.AccessToken), "refreshed access tokens should authenticate")
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken, "refresh tokens should be rotated")
+	status, _ = refresh(first.RefreshToken)
+	assert.Equal(t, 401, status, "refresh tokens should be used once")
+
+	resp, body = server.do(t, routeTest{method: "POST", path: "/auth/logout", header: map[string]string{fiber.HeaderAuthorization: "Bearer " + second.AccessToken}, body: map[string]string{"refresh_token": second.RefreshToken}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, 401, stats(second.AccessToken), "access tokens should be revoked on logout")
+	status, _ = refresh(second.RefreshToken)
+	assert.Equal(t, 401, status, "refresh tokens should be revoked on logout")
+	assert.Equal(t, 200, stats(first.AccessToken), "other sessions should be kept")
+}
+
+func TestLogoutRevokesSessionOfTokensWithoutJti(t *testing.T) {
+	server := newTestServer(t)
+	sessionToken := func(sessionID string) routeTest {
+		token := signToken(t, jwt.MapClaims{"sub": owner.String(), "exp": time.Now().Add(time.Hour).Unix(), "jti": "", "session_id": sessionID})
+		return routeTest{header: map[string]string{fiber.HeaderAuthorization: "Bearer " + token}}
+	}
+	stats := func(test routeTest) int {
+		test.method, test.path = "GET", "/api/stats"
+		resp, _ := server.do(t, test)
+		return resp.StatusCode
+	}
+
+	session, otherSession := uuid.NewString(), uuid.NewString()
+	logout := sessionToken(session)
+	logout.method, logout.path = "POST", "/auth/logout"
+	resp, body := server.do(t, logout)
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+
+	assert.Equal(t, 401, stats(sessionToken(session)), "every token of the session should be revoked, as they have no jti")
+	assert.Equal(t, 200, stats(sessionToken(otherSession)), "other sessions should be kept")
+}
+
+func TestAdminReplaysFailedJobs(t *testing.T) {
+	server := newTestServer(t)
+	server.logQueue.failed = []queue.LogMessage{{JobID: failedJob.String(), UserID: owner.String(), FileURL: "fake://failed.log"}}
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/admin/failed-jobs/replay", user: sysAdmin})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Contains(t, string(body), `"replayed":1`)
+	require.Len(t, server.logQueue.sent, 1)
+	assert.Equal(t, failedJob.String(), server.logQueue.sent[0].JobID)
+	assert.Empty(t, server.logQueue.failed)
+
+	job, err := models.GetJobByID(server.db, failedJob.String())
+	require.NoError(t, err)
+	assert.Zero(t, job.Attempts, "replayed jobs should get their attempts back")
+
+	entries, err := models.GetAuditEntries(server.db, models.AuditFilter{Action: models.AuditJobReprocess})
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "replays should be audited")
+	assert.Equal(t, failedJob.String(), entries[0].TargetID)
+	assert.Equal(t, sysAdmin, *entries[0].ActorID)
+}
+
+func TestSystemRolesApply(t *testing.T) {
+	server := newTestServer(t)
+	queueStatus := func(test routeTest) int {
+		test.method, test.path = "GET", "/admin/queue-status"
+		resp, _ := server.do(t, test)
+		return resp.StatusCode
+	}
+
+	require.Equal(t, 403, queueStatus(routeTest{user: stranger}))
+	resp, body := server.do(t, routeTest{method: "PUT", path: fmt.Sprintf("/admin/users/%s/role", stranger), user: sysAdmin, body: map[string]any{"role": "admin"}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, 200, queueStatus(routeTest{user: stranger}), "promotions should apply right away")
+
+	resp, body = server.do(t, routeTest{method: "PUT", path: fmt.Sprintf("/admin/users/%s/role", sysAdmin), user: stranger, body: map[string]any{"role": "user"}})
+	require.Equal(t, 200, resp.StatusCode, "admins but the last one should be demoted, body: %s", body)
+	assert.Equal(t, 403, queueStatus(routeTest{user: sysAdmin}), "demotions should apply right away")
+
+	resp, body = server.do(t, routeTest{method: "GET", path: "/admin/users", user: stranger})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Contains(t, string(body), testEmail, "accounts of the local provider should be listed")
+	assert.Contains(t, string(body), stranger.String(), "users with a role should be listed")
+
+	adminKey, _ := server.createAPIKey(t, stranger, nil, map[string]any{"name": "ops", "scopes": []string{"admin"}})
+	assert.Equal(t, 200, queueStatus(routeTest{header: map[string]string{"X-API-Key": adminKey}}))
+	readKey, _ := server.createAPIKey(t, stranger, nil, map[string]any{"name": "dashboard", "scopes": []string{"read-stats"}})
+	assert.Equal(t, 403, queueStatus(routeTest{header: map[string]string{"X-API-Key": readKey}}), "keys should need the admin scope")
+
+	config.Env.AuthRolesClaim = "app_metadata.roles"
+	t.Cleanup(func() { config.Env.AuthRolesClaim = "" })
+	withRoles := func(roles any) routeTest {
+		token := signToken(t, jwt.MapClaims{"sub": owner.String(), "exp": time.Now().Add(time.Hour).Unix(), "app_metadata": map[string]any{"roles": roles}})
+		return routeTest{header: map[string]string{fiber.HeaderAuthorization: "Bearer " + token}}
+	}
+	assert.Equal(t, 200, queueStatus(withRoles([]string{"admin"})), "roles of the claim should apply")
+	assert.Equal(t, 200, queueStatus(withRoles("admin")))
+	assert.Equal(t, 403, queueStatus(withRoles([]string{"user"})))
+}
+
+func TestDeletedUsersLoseTheirTokensAndMemberships(t *testing.T) {
+	server := newTestServer(t)
+	resp, body := server.do(t, routeTest{method: "DELETE", path: fmt.Sprintf("/admin/users/%s", owner), user: sysAdmin})
+	require.Equal(t, 409, resp.StatusCode, "body: %s", body)
+	assert.Equal(t, "LAST_OWNER", respCode(body), "the last owner of an org shouldn't be deleted")
+
+	issuedAt := func(at time.Time) routeTest {
+		token := signToken(t, jwt.MapClaims{"sub": orgMember.String(), "exp": time.Now().Add(time.Hour).Unix(), "iat": at.Unix()})
+		return routeTest{method: "GET", path: "/api/stats", header: map[string]string{fiber.HeaderAuthorization: "Bearer " + token}}
+	}
+	resp, body = server.do(t, routeTest{method: "DELETE", path: fmt.Sprintf("/admin/users/%s", orgMember), user: sysAdmin})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+
+	resp, _ = server.do(t, issuedAt(time.Now().Add(-time.Minute)))
+	assert.Equal(t, 401, resp.StatusCode, "tokens issued before the deletion should be revoked")
+	resp, _ = server.do(t, issuedAt(time.Now().Add(time.Minute)))
+	assert.Equal(t, 200, resp.StatusCode, "tokens issued since should be valid")
+
+	resp, body = server.do(t, routeTest{method: "GET", path: fmt.Sprintf("/api/orgs/%s/members", org), user: orgAdmin})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.NotContains(t, string(body), orgMember.String(), "memberships of the user should be deleted")
+	sharedStats := func(at time.Time) routeTest {
+		token := signToken(t, jwt.MapClaims{"sub": sharee.String(), "exp": time.Now().Add(time.Hour).Unix(), "iat": at.Unix()})
+		return routeTest{method: "GET", path: fmt.Sprintf("/api/stats/%s", processedJob), header: map[string]string{fiber.HeaderAuthorization: "Bearer " + token}}
+	}
+	resp, body = server.do(t, sharedStats(time.Now()))
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body) //the access list of the job is cached from now on
+	resp, body = server.do(t, routeTest{method: "DELETE", path: fmt.Sprintf("/admin/users/%s", sharee), user: sysAdmin})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	shares, err := models.GetJobShares(server.db, processedJob.String())
+	require.NoError(t, err)
+	assert.Empty(t, shares, "shares with the user should be deleted, even without an account or role")
+	resp, _ = server.do(t, sharedStats(time.Now().Add(time.Minute)))
+	assert.Equal(t, 404, resp.StatusCode, "the cached access to the jobs shared with the user should be dropped")
+}
+
+func TestSystemStatsSpanAllWorkspaces(t *testing.T) {
+	server := newTestServer(t)
+	resp, body := server.do(t, routeTest{method: "GET", path: "/admin/stats", user: sysAdmin})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+
+	var stats struct {
+		Data struct {
+			Reports models.WholeLogReportsAggregate `json:"reports"`
+			Jobs    models.JobCounts                `json:"jobs"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &stats))
+	assert.Equal(t, 15, stats.Data.Reports.TotalLogs, "reports of personal and org jobs should be aggregated")
+	assert.Equal(t, models.JobCounts{Total: 4, Succeeded: 2, Failed: 1, Pending: 1, Uploaders: 2}, stats.Data.Jobs)
+}
+
+func TestJobsFailOnlyOnceTheirLastAttemptFails(t *testing.T) {
+	server := newTestServer(t)
+	countsOf := func() models.JobCounts {
+		counts, err := models.GetJobCounts(server.db, time.Now())
+		require.NoError(t, err)
+		return *counts
+	}
+	jobID := pendingJob.String()
+
+	for attempt := 1; attempt <= models.JobMaxAttempts; attempt++ {
+		delivery := amqp.Delivery{Headers: amqp.Table{"x-retry-count": int32(attempt - 1)}}
+		assert.Equal(t, attempt == models.JobMaxAttempts, queue.IsLastAttempt(delivery), "attempt %d", attempt)
+
+		// As a worker does: the job is leased, its attempt counted, then it fails and the lease is released
+		leased, err := models.ClaimJobLease(server.db, jobID, owner.String(), 1, time.Now().Add(time.Minute), time.Now())
+		require.NoError(t, err)
+		require.True(t, leased)
+		require.NoError(t, models.AddFailAttemptForJob(server.db, jobID))
+		counts := countsOf()
+		assert.Equal(t, 1, counts.Processing, "attempt %d should be processing", attempt)
+		assert.Equal(t, 1, counts.Failed, "attempt %d shouldn't be failed, only the fixture job", attempt)
+
+		require.NoError(t, models.ReleaseJobLease(server.db, jobID))
+		counts = countsOf()
+		if attempt < models.JobMaxAttempts {
+			assert.Equal(t, 1, counts.Pending, "the job should wait for a retry after attempt %d", attempt)
+			assert.Equal(t, 1, counts.Failed, "the job shouldn't be failed after attempt %d", attempt)
+		} else {
+			assert.Equal(t, 0, counts.Pending)
+			assert.Equal(t, 2, counts.Failed, "the job should be failed after its last attempt")
+		}
+	}
+}
+
+func TestAuditLogRecordsActionsOnJobs(t *testing.T) {
+	server := newTestServer(t)
+	client := map[string]string{"X-Real-IP": "203.0.113.7", fiber.HeaderUserAgent: "=cmd|' /C calc'!A0"}
+	statsPath := fmt.Sprintf("/api/stats/%s", processedJob)
+
+	resp, body := server.do(t, routeTest{method: "GET", path: statsPath, user: owner, header: client})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	resp, body = server.do(t, routeTest{method: "GET", path: statsPath, user: stranger, header: client})
+	require.Equal(t, 404, resp.StatusCode, "body: %s", body)
+	resp, body = server.do(t, routeTest{method: "POST", path: fmt.Sprintf("/api/jobs/%s/shares", processedJob), user: owner, body: map[string]any{"userID": stranger}})
+	require.Equal(t, 201, resp.StatusCode, "body: %s", body)
+	key, keyID := server.createAPIKey(t, owner, nil, map[string]any{"name": "ci", "scopes": []string{"upload"}})
+	resp, body = server.do(t, routeTest{method: "POST", path: "/api/upload-logs", header: map[string]string{"X-API-Key": key}, body: upload{"app.log", "line\n"}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	require.Len(t, server.logQueue.sent, 1)
+
+	list := func(query string) []models.AuditEntry {
+		resp, body := server.do(t, routeTest{method: "GET", path: "/admin/audit?" + query, user: sysAdmin})
+		require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+		var entries struct {
+			Data []models.AuditEntry `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &entries))
+		return entries.Data
+	}
+
+	views := list("action=job.view&target=" + processedJob.String())
+	require.Len(t, views, 2)
+	assert.Equal(t, stranger, *views[0].ActorID, "the latest entries should be listed first")
+	assert.Equal(t, models.AuditDenied, views[0].Result, "denied requests should be recorded")
+	assert.Equal(t, 404, views[0].Status)
+	assert.Equal(t, owner, *views[1].ActorID)
+	assert.Equal(t, models.AuditSuccess, views[1].Result)
+	assert.Equal(t, "203.0.113.7", views[1].IP)
+	assert.Equal(t, client[fiber.HeaderUserAgent], views[1].UserAgent)
+
+	assert.Len(t, list("action=job.share&actor="+owner.String()), 1)
+	uploads := list("action=job.upload")
+	require.Len(t, uploads, 1)
+	assert.Equal(t, server.logQueue.sent[0].JobID, uploads[0].TargetID, "uploads should be recorded with the job they created")
+	assert.Equal(t, keyID, uploads[0].APIKeyID.String(), "the key acted with should be recorded")
+
+	keys := list("action=api_key.create")
+	require.Len(t, keys, 1)
+	assert.Equal(t, keyID, keys[0].TargetID, "keys should be recorded with their ID")
+	assert.NotEmpty(t, list("action=audit.read&actor="+sysAdmin.String()), "reads of the audit log should be recorded")
+
+	all := list("actor=" + owner.String())
+	require.Len(t, all, 4, "the view, share, key and upload of the owner")
+	assert.Equal(t, all[1:], list(fmt.Sprintf("actor=%s&before=%d", owner, all[0].ID)), "pages should follow from the last ID")
+	assert.Len(t, list("limit=1"), 1)
+	var streamed []models.AuditEntry
+	require.NoError(t, models.EachAuditEntry(server.db, models.AuditFilter{ActorID: owner, Limit: 3}, 2, func(entry models.AuditEntry) error {
+		streamed = append(streamed, entry)
+		return nil
+	}))
+	assert.Equal(t, all[:3], streamed, "exports should read the entries in batches, up to the limit")
+
+	resp, body = server.do(t, routeTest{method: "GET", path: "/admin/audit?format=csv&action=job.view", user: sysAdmin})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	assert.Contains(t, resp.Header.Get(fiber.HeaderContentType), "text/csv")
+	assert.Contains(t, resp.Header.Get(fiber.HeaderContentDisposition), "attachment")
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3, "a header and the 2 views")
+	assert.Equal(t, "user_agent", rows[0][10])
+	assert.Equal(t, "'"+client[fiber.HeaderUserAgent], rows[2][10], "values of clients shouldn't be taken for formulas")
+	assert.Len(t, list("action=audit.export"), 1, "exports of the audit log should be recorded")
+}
+
+// usage of the quotas of a workspace, as returned by GET /api/usage
+type testUsage struct {
+	JobsToday struct {
+		Used  int64  `json:"used"`
+		Limit *int64 `json:"limit"`
+	} `json:"jobsToday"`
+	StorageBytes struct {
+		Used  int64  `json:"used"`
+		Limit *int64 `json:"limit"`
+	} `json:"storageBytes"`
+	ConcurrentJobs struct {
+		Used  int64  `json:"used"`
+		Limit *int64 `json:"limit"`
+	} `json:"concurrentJobs"`
+}
+
+func TestUploadsAreLimitedByQuotas(t *testing.T) {
+	server := newTestServer(t)
+	t.Cleanup(func() { config.Env.QuotaConfig = config.QuotaConfig{} })
+	uploadAs := func(user uuid.UUID, header map[string]string, size int) (*http.Response, string) {
+		resp, body := server.do(t, routeTest{method: "POST", path: "/api/upload-logs", user: user, header: header, body: upload{"app.log", strings.Repeat("x", size)}})
+		return resp, respCode(body)
+	}
+	usageOf := func(user uuid.UUID, header map[string]string) testUsage {
+		resp, body := server.do(t, routeTest{method: "GET", path: "/api/usage", user: user, header: header})
+		require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+		var usage struct {
+			Data testUsage `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &usage))
+		return usage.Data
+	}
+
+	// The owner has uploaded 3 jobs today, of which the pending one is active
+	usage := usageOf(owner, nil)
+	assert.Equal(t, int64(3), usage.JobsToday.Used)
+	assert.Equal(t, int64(1), usage.ConcurrentJobs.Used, "succeeded and failed jobs shouldn't be active")
+	assert.Nil(t, usage.JobsToday.Limit, "quotas should be unlimited by default")
+
+	config.Env.QuotaConfig = config.QuotaConfig{JobsPerDay: 3}
+	resp, code := uploadAs(owner, nil, 10)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "DAILY_JOB_QUOTA_EXCEEDED", code)
+	assert.NotEmpty(t, resp.Header.Get(fiber.HeaderRetryAfter))
+
+	config.Env.QuotaConfig = config.QuotaConfig{ConcurrentJobs: 1}
+	resp, code = uploadAs(owner, nil, 10)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "CONCURRENT_JOB_QUOTA_EXCEEDED", code)
+
+	config.Env.QuotaConfig = config.QuotaConfig{StorageMB: 1}
+	resp, code = uploadAs(owner, nil, 1024*1024+1)
+	assert.Equal(t, 413, resp.StatusCode)
+	assert.Equal(t, "STORAGE_QUOTA_EXCEEDED", code)
+	resp, code = uploadAs(owner, nil, 1000)
+	require.Equal(t, 200, resp.StatusCode, code)
+	usage = usageOf(owner, nil)
+	assert.Equal(t, int64(1000), usage.StorageBytes.Used, "uploads should count towards the storage quota")
+	require.NotNil(t, usage.StorageBytes.Limit)
+	assert.Equal(t, int64(1024*1024), *usage.StorageBytes.Limit)
+	assert.Empty(t, server.logQueue.sent[1:], "rejected uploads shouldn't be queued")
+
+	// Jobs that fail to be queued are deleted, not to stay active
+	config.Env.QuotaConfig = config.QuotaConfig{ConcurrentJobs: 3}
+	server.logQueue.mutex.Lock()
+	server.logQueue.sendErr = errors.New("queue down")
+	server.logQueue.mutex.Unlock()
+	resp, code = uploadAs(owner, nil, 10)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Equal(t, "QUEUE_ERROR", code)
+	server.logQueue.mutex.Lock()
+	server.logQueue.sendErr = nil
+	server.logQueue.mutex.Unlock()
+	usage = usageOf(owner, nil)
+	assert.Equal(t, int64(2), usage.ConcurrentJobs.Used, "the unqueued job shouldn't be active")
+	assert.Equal(t, int64(1000), usage.StorageBytes.Used, "the unqueued job shouldn't count towards the storage quota")
+
+	// A job out of attempts is still active while its last attempt is processing
+	require.NoError(t, models.RenewJobLease(server.db, failedJob.String(), time.Now().Add(time.Minute)))
+	assert.Equal(t, int64(3), usageOf(owner, nil).ConcurrentJobs.Used, "the job on its last attempt should be active")
+	resp, code = uploadAs(owner, nil, 10)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "CONCURRENT_JOB_QUOTA_EXCEEDED", code)
+	require.NoError(t, models.ReleaseJobLease(server.db, failedJob.String()))
+
+	// Quotas of organizations are apart from the ones of their members
+	config.Env.QuotaConfig = config.QuotaConfig{}
+	inOrg := map[string]string{"X-Workspace-ID": org.String()}
+	resp, body := server.do(t, routeTest{method: "PUT", path: fmt.Sprintf("/admin/orgs/%s/quota", org), user: sysAdmin, body: map[string]any{"jobsPerDay": 1}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	resp, code = uploadAs(orgMember, inOrg, 10)
+	assert.Equal(t, 429, resp.StatusCode, "the org has uploaded its job of the day")
+	assert.Equal(t, "DAILY_JOB_QUOTA_EXCEEDED", code)
+	resp, code = uploadAs(orgMember, nil, 10)
+	assert.Equal(t, 200, resp.StatusCode, "the personal workspace of members should have its own quotas, code: %s", code)
+
+	// Overrides of a user take precedence over the defaults, till reset
+	config.Env.QuotaConfig = config.QuotaConfig{JobsPerDay: 1}
+	resp, body = server.do(t, routeTest{method: "PUT", path: fmt.Sprintf("/admin/users/%s/quota", owner), user: sysAdmin, body: map[string]any{"jobsPerDay": 0}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	resp, code = uploadAs(owner, nil, 10)
+	assert.Equal(t, 200, resp.StatusCode, "0 should be no limit, code: %s", code)
+	resp, body = server.do(t, routeTest{method: "PUT", path: fmt.Sprintf("/admin/users/%s/quota", owner), user: sysAdmin, body: map[string]any{}})
+	require.Equal(t, 200, resp.StatusCode, "body: %s", body)
+	resp, _ = uploadAs(owner, nil, 10)
+	assert.Equal(t, 429, resp.StatusCode, "the defaults should apply once the overrides are reset")
+}
+
+func TestWebSocketRoutesAreRateLimited(t *testing.T) { //before the upgrade and ticket redemption
+	server := newTestServer(t)
+	config.Env.GeneralRateLimit = 2
+	t.Cleanup(func() { config.Env.GeneralRateLimit = 1000 })
+	app := fiber.New()
+	mountWebSocketRoutes(app, nil, server.db, nil, server.drain)
+
+	for i, wantStatus := range []int{426, 426, 429} {
+		req := httptest.NewRequest("GET", "/api/live-stats?ticket=guessed", nil)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, wantStatus, resp.StatusCode, "request %d", i+1)
+	}
+}
+
+// dialFeed opens the feed of all the jobs of the user, on a listener of the server
+func (server *testServer) dialFeed(t *testing.T, user uuid.UUID) *websocket.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.app.Listener(ln)
+	t.Cleanup(func() { server.app.Shutdown() })
+
+	resp, body := server.do(t, routeTest{method: "POST", path: "/api/ws-tickets", user: user})
+	require.Equal(t, 201, resp.StatusCode, "body: %s", body)
+	var ticket struct {
+		Data struct {
+			Ticket string `json:"ticket"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &ticket))
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/api/live-stats?ticket=%s", ln.Addr(), ticket.Data.Ticket), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestFeedFollowsJobsTheUserCanRead(t *testing.T) {
+	server := newTestServer(t)
+	publish := func(jobID, userID uuid.UUID, seq int64, status string, final bool) {
+		body, err := json.Marshal(liveprogress.Stats{JobID: jobID.String(), Status: status})
+		require.NoError(t, err)
+		server.hub.Publish(queue.LiveStatusMessage{JobID: jobID.String(), UserID: userID.String(), Seq: seq, Final: final, Body: body})
+	}
+	receive := func(conn *websocket.Conn) liveprogress.Envelope {
+		t.Helper()
+		var envelope liveprogress.Envelope
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.NoError(t, conn.ReadJSON(&envelope))
+		return envelope
+	}
+	subscribe := func(conn *websocket.Conn, jobID uuid.UUID) {
+		require.NoError(t, conn.WriteJSON(liveprogress.Request{Action: liveprogress.ActionSubscribe, JobIDs: []string{jobID.String()}}))
+	}
+
+	// Neither the sharee's nor the viewer's jobs, so not on their subscription of the user
+	publish(processedJob, owner, 1, liveprogress.StatusInProgress, false)
+	publish(orgJob, orgMember, 1, liveprogress.StatusInProgress, false)
+	for user, jobID := range map[uuid.UUID]uuid.UUID{sharee: processedJob, orgViewer: orgJob} {
+		conn := server.dialFeed(t, user)
+		subscribe(conn, jobID)
+		assert.Equal(t, liveprogress.EventSubscribed, receive(conn).Type)
+		snapshot := receive(conn)
+		assert.Equal(t, liveprogress.EventSnapshot, snapshot.Type)
+		assert.Equal(t, jobID.String(), snapshot.JobID)
+
+		publish(jobID, owner, 2, liveprogress.StatusCompleted, true)
+		assert.Equal(t, liveprogress.EventCompleted, receive(conn).Type)
+	}
+
+	conn := server.dialFeed(t, stranger)
+	subscribe(conn, pendingJob)
+	envelope := receive(conn)
+	assert.Equal(t, liveprogress.EventError, envelope.Type, "jobs the user can't read shouldn't be subscribed to")
+	var subscriptionErr liveprogress.Error
+	require.NoError(t, envelope.Decode(&subscriptionErr))
+	assert.Equal(t, liveprogress.ErrCodeInvalidRequest, subscriptionErr.Code)
+}
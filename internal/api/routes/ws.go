@@ -3,14 +3,25 @@ package routes
 import (
 	"log-flow/internal/api/handler"
 	"log-flow/internal/api/middleware"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/infrastructure/config"
+	"log-flow/internal/utils/shutdown"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-// func mountWebSocketRoutes(app, workers, liveProgressChannel)
-func mountWebSocketRoutes(app *fiber.App, websocketManager *handler.WebSocketManager) {
+// To be mounted before the /api group, so that the upgrades are authenticated by WebSocketAuth rather than AuthMiddleware.
+// Being mounted before the rate limiters of the other routes too, they have their own.
+func mountWebSocketRoutes(app *fiber.App, websocketManager *handler.WebSocketManager, db *gorm.DB, jobAccess *middleware.JobAccess, drain *shutdown.Drain) {
+	rateLimit := middleware.RateLimit(config.Env.GeneralRateLimit)
+	wsAuth := middleware.WebSocketAuth(db)
+	draining := middleware.RejectWhenDraining(drain)
+	readStats := middleware.RequireScope(models.ScopeReadStats)
+	wsConfig := websocket.Config{Subprotocols: []string{middleware.WsSubprotocol}}
 
 	// WebSocket route
-	app.Get("/api/live-stats/:jobID", middleware.JobAuthorCheck, websocket.New(websocketManager.LiveProgressLogs))
+	app.Get("/api/live-stats/:jobID", rateLimit, draining, wsAuth, middleware.Audit(db, models.AuditJobView, models.AuditTargetJob, "jobID"), readStats, jobAccess.Check, websocket.New(websocketManager.LiveProgressLogs, wsConfig))
+	app.Get("/api/live-stats", rateLimit, draining, wsAuth, readStats, middleware.RejectOrgAPIKeys, websocket.New(websocketManager.LiveProgressFeed, wsConfig)) //all of the user's jobs
 }
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scopes of API keys
+const (
+	ScopeUpload    = "upload"     //uploads logs
+	ScopeReadStats = "read-stats" //reads stats and follows the progress of jobs
+	ScopeAdmin     = "admin"      //anything the user can, including managing keys, shares and organizations
+)
+
+var apiKeyScopes = []string{ScopeUpload, ScopeReadStats, ScopeAdmin}
+
+func IsValidScope(scope string) bool {
+	return slices.Contains(apiKeyScopes, scope)
+}
+
+// APIKeyPrefix starts every API key, to tell them apart from JWTs
+const APIKeyPrefix = "lf_"
+
+// Updating the last use of a key on every request would cost a write per request, so it's tracked to this precision
+const apiKeyLastUsedPrecision = time.Minute
+
+// APIKey authenticates machines (CI pipelines, log shippers...) as the user who created it, within its scopes.
+// Keys of an organization only give access to its workspace. Only a hash of the key is stored.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" gorm:"column:id;primaryKey"`
+	UserID     uuid.UUID  `json:"userID" gorm:"column:user_id;not null;index"` //creator, who requests are made as
+	OrgID      *uuid.UUID `json:"orgID,omitempty" gorm:"column:org_id;index"`
+	Name       string     `json:"name" gorm:"column:name;not null"`
+	Prefix     string     `json:"prefix" gorm:"column:prefix;not null"` //first characters of the key, to recognize it by
+	KeyHash    []byte     `json:"-" gorm:"column:key_hash;not null;uniqueIndex"`
+	Scopes     string     `json:"scopes" gorm:"column:scopes;not null"` //comma separated
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" gorm:"column:expires_at"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" gorm:"column:last_used_at"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" gorm:"column:revoked_at"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"column:created_at"`
+}
+
+func (ak APIKey) TableName() string {
+	return "api_keys"
+}
+
+func HashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+func (ak *APIKey) Create(db *gorm.DB) error {
+	ak.ID = uuid.New()
+	ak.CreatedAt = time.Now()
+	return db.Create(ak).Error
+}
+
+func (ak APIKey) ScopeList() []string {
+	return strings.Split(ak.Scopes, ",")
+}
+
+// ErrInvalidAPIKey is returned for keys which don't exist, have expired or have been revoked
+var ErrInvalidAPIKey = errors.New("Invalid, expired or revoked API key")
+
+// AuthenticateAPIKey returns the key, once its use is recorded.
+func AuthenticateAPIKey(db *gorm.DB, key string, now time.Time) (*APIKey, error) {
+	var keys []APIKey
+	if err := db.Where("key_hash = ?", HashAPIKey(key)).Limit(1).Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrInvalidAPIKey
+	}
+	apiKey := &keys[0]
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedPrecision {
+		if err := db.Model(apiKey).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return apiKey, nil
+}
+
+// GetAPIKeys returns the keys of the organization, or the personal keys of the user if nil, revoked ones included.
+func GetAPIKeys(db *gorm.DB, userID, orgID uuid.UUID) ([]APIKey, error) {
+	query := db.Where("user_id = ? AND org_id IS NULL", userID)
+	if orgID != uuid.Nil {
+		query = db.Where("org_id = ?", orgID)
+	}
+	var keys []APIKey
+	err := query.Order("created_at").Find(&keys).Error
+	return keys, err
+}
+
+func GetAPIKeyByID(db *gorm.DB, keyID string) (*APIKey, error) {
+	var apiKey APIKey
+	if err := db.Where("id = ?", keyID).First(&apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// RevokeAPIKey revokes the key, if not revoked already.
+func RevokeAPIKey(db *gorm.DB, keyID uuid.UUID, now time.Time) error {
+	return db.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", keyID).Update("revoked_at", now).Error
+}
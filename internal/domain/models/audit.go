@@ -0,0 +1,149 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditJobUpload    = "job.upload"
+	AuditJobView      = "job.view" //of its stats, or of its live status
+	AuditJobShare     = "job.share"
+	AuditJobUnshare   = "job.unshare"
+	AuditJobTransfer  = "job.transfer"
+	AuditJobReprocess = "job.reprocess" //replayed from the failed queue
+	AuditUserSetRole  = "user.set_role"
+	AuditUserDelete   = "user.delete"
+
+	AuditOrgSetMember    = "org.set_member" //added, or with their role changed
+	AuditOrgRemoveMember = "org.remove_member"
+	AuditOrgSetKeywords  = "org.set_keywords"
+	AuditAPIKeyCreate    = "api_key.create"
+	AuditAPIKeyRevoke    = "api_key.revoke"
+	AuditQuotaSet        = "quota.set" //overrides of a user or organization
+	AuditLogRead         = "audit.read"
+	AuditLogExport       = "audit.export"
+)
+
+// Targets of audited actions
+const (
+	AuditTargetJob       = "job"
+	AuditTargetUser      = "user"
+	AuditTargetOrg       = "org"
+	AuditTargetOrgMember = "org_member" //the org and user IDs, as <org ID>/<user ID>
+	AuditTargetAPIKey    = "api_key"
+	AuditTargetAuditLog  = "audit_log" //without an ID
+)
+
+// Results of audited actions
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied" //not authorized, or the target not found. Requests failing authentication aren't recorded
+	AuditFailure = "failure"
+)
+
+// AuditEntry records an action of a user on a target, for compliance. Entries are only ever appended,
+// in the order of their ID, and are kept for good.
+type AuditEntry struct {
+	ID         int64      `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"column:created_at;not null;index"`
+	ActorID    *uuid.UUID `json:"actorID,omitempty" gorm:"column:actor_id;index"` //nil if not authenticated
+	APIKeyID   *uuid.UUID `json:"apiKeyID,omitempty" gorm:"column:api_key_id"`    //key the actor acted with, nil if with a token
+	Action     string     `json:"action" gorm:"column:action;not null;index"`
+	TargetType string     `json:"targetType" gorm:"column:target_type;not null"`
+	TargetID   string     `json:"targetID" gorm:"column:target_id;not null;index"`
+	Result     string     `json:"result" gorm:"column:result;not null"`
+	Status     int        `json:"status" gorm:"column:status"` //HTTP status of the response
+	IP         string     `json:"ip" gorm:"column:ip"`
+	UserAgent  string     `json:"userAgent" gorm:"column:user_agent"`
+}
+
+func (ae AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+func (ae *AuditEntry) Create(db *gorm.DB) error {
+	ae.CreatedAt = time.Now()
+	return db.Create(ae).Error
+}
+
+// AuditFilter narrows down the audit entries to list. Zero values mean no filter.
+type AuditFilter struct {
+	ActorID  uuid.UUID
+	Action   string
+	TargetID string
+	Result   string
+	From     time.Time //entries made at or after
+	To       time.Time //entries made before
+	BeforeID int64     //entries older than this one, to page through them
+	Limit    int       //all the entries if 0
+}
+
+// GetAuditEntries returns the entries of the filter, the latest first.
+func GetAuditEntries(db *gorm.DB, filter AuditFilter) ([]AuditEntry, error) {
+	query := db.Model(&AuditEntry{})
+	if filter.ActorID != uuid.Nil {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Result != "" {
+		query = query.Where("result = ?", filter.Result)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []AuditEntry
+	err := query.Order("id DESC").Find(&entries).Error
+	return entries, err
+}
+
+// EachAuditEntry calls fn with the entries of the filter, the latest first, reading batchSize entries at a time
+// rather than all of them at once. It stops at the first error of fn.
+func EachAuditEntry(db *gorm.DB, filter AuditFilter, batchSize int, fn func(AuditEntry) error) error {
+	remaining := filter.Limit //all the entries if 0
+	for {
+		batch := filter
+		batch.Limit = batchSize
+		if remaining > 0 && remaining < batchSize {
+			batch.Limit = remaining
+		}
+		entries, err := GetAuditEntries(db, batch)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		if len(entries) < batch.Limit {
+			return nil
+		}
+		if remaining > 0 {
+			if remaining -= len(entries); remaining == 0 {
+				return nil
+			}
+		}
+		filter.BeforeID = entries[len(entries)-1].ID
+	}
+}
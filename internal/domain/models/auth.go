@@ -0,0 +1,112 @@
+package models
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WsTicket is a short-lived, one-time credential for WebSocket upgrades, as browsers can't set headers on them.
+// Only a hash of the ticket is stored.
+type WsTicket struct {
+	TicketHash     []byte    `json:"-" gorm:"column:ticket_hash;primaryKey"`
+	UserID         uuid.UUID `json:"userID" gorm:"column:user_id;not null"`
+	ExpiresAt      time.Time `json:"expiresAt" gorm:"column:expires_at;not null"`
+	TokenExpiresAt time.Time `json:"tokenExpiresAt" gorm:"column:token_expires_at;not null"` //of the JWT the ticket was issued for
+}
+
+func (wt WsTicket) TableName() string {
+	return "ws_tickets"
+}
+
+func HashTicket(ticket string) []byte {
+	hash := sha256.Sum256([]byte(ticket))
+	return hash[:]
+}
+
+// Create saves the ticket, clearing the expired ones.
+func (wt *WsTicket) Create(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&WsTicket{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(wt).Error
+	})
+}
+
+// ConsumeWsTicket deletes the ticket and returns it, or nil if it doesn't exist or has expired.
+func ConsumeWsTicket(db *gorm.DB, ticket string) (*WsTicket, error) {
+	var consumed []WsTicket
+	result := db.Clauses(clause.Returning{}).
+		Where("ticket_hash = ? AND expires_at > ?", HashTicket(ticket), time.Now()).
+		Delete(&consumed)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(consumed) == 0 {
+		return nil, nil
+	}
+	return &consumed[0], nil
+}
+
+// RevokedToken is a JWT rejected before it expires, by its jti, or all the tokens of a session by "session:" and its ID.
+// It's kept until then only.
+type RevokedToken struct {
+	TokenID   string    `json:"tokenID" gorm:"column:token_id;primaryKey"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at;not null;index"`
+}
+
+func (rt RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// RevokeToken revokes the token till it expires, clearing the revocations of expired tokens.
+// It reports whether the token wasn't revoked already.
+func RevokeToken(db *gorm.DB, tokenID string, expiresAt time.Time) (bool, error) {
+	revoked := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{TokenID: tokenID, ExpiresAt: expiresAt})
+		revoked = result.RowsAffected > 0
+		return result.Error
+	})
+	return revoked, err
+}
+
+func IsTokenRevoked(db *gorm.DB, tokenID string) (bool, error) {
+	var count int64
+	err := db.Model(&RevokedToken{}).Where("token_id = ?", tokenID).Count(&count).Error
+	return count > 0, err
+}
+
+// UserRevocation rejects the tokens of the user issued before RevokedBefore, as the user was deleted then.
+// Tokens issued since, by an auth provider still keeping the user, are valid.
+type UserRevocation struct {
+	UserID        uuid.UUID `json:"userID" gorm:"column:user_id;primaryKey"`
+	RevokedBefore time.Time `json:"revokedBefore" gorm:"column:revoked_before;not null"`
+}
+
+func (ur UserRevocation) TableName() string {
+	return "user_revocations"
+}
+
+// RevokeUserTokens revokes the tokens of the user issued before the time.
+func RevokeUserTokens(db *gorm.DB, userID uuid.UUID, before time.Time) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before"}),
+	}).Create(&UserRevocation{UserID: userID, RevokedBefore: before}).Error
+}
+
+// IsUserTokenRevoked reports whether the tokens of the user issued at the time are revoked. Tokens without an issue time
+// (zero) are revoked as soon as any token of the user is.
+func IsUserTokenRevoked(db *gorm.DB, userID string, issuedAt time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&UserRevocation{}).Where("user_id = ? AND revoked_before > ?", userID, issuedAt).Count(&count).Error
+	return count > 0, err
+}
@@ -2,25 +2,34 @@ package models
 
 import (
 	"fmt"
+	"log-flow/internal/infrastructure/queue"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-const ()
+// Attempts after which a job has failed, as the queue stops retrying it.
+// Attempts are counted as they start, so a job out of attempts is still processing while it's leased.
+const JobMaxAttempts = queue.MaxAttempts
 
 type DbTablesWithName interface {
 	TableName() string
 }
 
 type Job struct {
-	ID         uuid.UUID `json:"id" gorm:"column:id;primaryKey"`
-	UserID     uuid.UUID `json:"userID" gorm:"column:user_id"`
-	FileURL    string    `json:"fileURL" gorm:"column:file_url;not null"`
-	Attempts   int       `json:"attempts" gorm:"column:attempts;default:0"`
-	Succeeded  bool      `json:"succeeded" gorm:"column:succeeded;default:false"`
-	UploadedAt time.Time `json:"uploadedAt" gorm:"column:uploaded_at"`
+	ID         uuid.UUID  `json:"id" gorm:"column:id;primaryKey"`
+	UserID     uuid.UUID  `json:"userID" gorm:"column:user_id"`               //uploader, or who the job was transferred to
+	OrgID      *uuid.UUID `json:"orgID,omitempty" gorm:"column:org_id;index"` //organization the job was uploaded into, nil for the uploader's personal workspace
+	FileURL    string     `json:"fileURL" gorm:"column:file_url;not null"`
+	FileSize   int64      `json:"fileSize" gorm:"column:file_size;not null;default:0"` //bytes, 0 for jobs uploaded before it was saved
+	Attempts   int        `json:"attempts" gorm:"column:attempts;default:0"`
+	Succeeded  bool       `json:"succeeded" gorm:"column:succeeded;default:false"`
+	UploadedAt time.Time  `json:"uploadedAt" gorm:"column:uploaded_at"`
+
+	// Set while a worker processes the job, and renewed by it, to limit the jobs of a user processed at once
+	LeaseExpiresAt *time.Time `json:"-" gorm:"column:lease_expires_at;index"`
 }
 
 func (j Job) TableName() string {
@@ -32,6 +41,21 @@ func (job *Job) Create(db *gorm.DB) error {
 	return db.Create(job).Error
 }
 
+// HasFailed tells whether the job failed out of attempts: its last attempt started, and isn't leased at now anymore.
+func (job *Job) HasFailed(now time.Time) bool {
+	return !job.Succeeded && job.Attempts >= JobMaxAttempts && (job.LeaseExpiresAt == nil || !job.LeaseExpiresAt.After(now))
+}
+
+// SetJobFileURL sets the URL of the file of the job, saved before the file was uploaded.
+func SetJobFileURL(db *gorm.DB, jobID uuid.UUID, fileURL string) error {
+	return db.Model(&Job{}).Where("id = ?", jobID).Update("file_url", fileURL).Error
+}
+
+// DeleteJob deletes a job that was never queued, not to count it in the quotas of its workspace.
+func DeleteJob(db *gorm.DB, jobID uuid.UUID) error {
+	return db.Where("id = ?", jobID).Delete(&Job{}).Error
+}
+
 type LogReport struct {
 	ID                   uuid.UUID      `json:"id" gorm:"column:id;primaryKey"`
 	JobID                uuid.UUID      `json:"jobID" gorm:"column:job_id"`
@@ -40,6 +64,8 @@ type LogReport struct {
 	WarnCount            int            `json:"warnCount" gorm:"column:warn_count"`
 	InfoCount            int            `json:"infoCount" gorm:"column:info_count"`
 	UniqueIPs            int            `json:"uniqueIPs" gorm:"column:unique_ips"`
+	UniqueIPSketch       []byte         `json:"-" gorm:"column:unique_ip_sketch"`                     //HyperLogLog sketch of the IPs, to estimate distinct IPs across reports
+	IPsPersisted         bool           `json:"-" gorm:"column:ips_persisted;not null;default:false"` //distinct IPs saved in job_ips, to count distinct IPs across reports exactly
 	InvalidLogs          int            `json:"invalidLogs" gorm:"column:invalid_logs"`
 	TrackedKeywordsCount map[string]int `json:"trackedKeywords_count" gorm:"-"`
 	CreatedAt            time.Time      `json:"createdAt" gorm:"column:created_at"`
@@ -79,12 +105,54 @@ func (lr *LogReport) Create(db *gorm.DB) error {
 			return fmt.Errorf("Error updating job: %v", err)
 		}
 
+		// The job is done, so its checkpoint won't be resumed from anymore
+		if err := tx.Where("job_id = ?", lr.JobID).Delete(&JobCheckpoint{}).Error; err != nil {
+			return fmt.Errorf("Error deleting job checkpoint: %v", err)
+		}
+
 		return nil
 	})
 	return err
 
 }
 
+// JobIP is a distinct IP found in the log file of a job. Saved while the job is processed,
+// so that IPs seen in several files are counted once in aggregated stats. Jobs with too many IPs
+// don't keep theirs, only the sketch of their report.
+type JobIP struct {
+	JobID uuid.UUID `json:"jobID" gorm:"column:job_id;primaryKey"`
+	IP    string    `json:"ip" gorm:"column:ip;primaryKey"`
+
+	Job Job `json:"-" gorm:"foreignKey:JobID;references:ID"`
+}
+
+func (ji JobIP) TableName() string {
+	return "job_ips"
+}
+
+// SaveJobIPs adds IPs to the distinct IPs of the job, and returns how many were new. IPs saved already are skipped.
+func SaveJobIPs(db *gorm.DB, jobID uuid.UUID, ips []string) (int64, error) {
+	if len(ips) == 0 {
+		return 0, nil
+	}
+	rows := make([]JobIP, 0, len(ips))
+	for _, ip := range ips {
+		rows = append(rows, JobIP{JobID: jobID, IP: ip})
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows)
+	return result.RowsAffected, result.Error
+}
+
+func CountJobIPs(db *gorm.DB, jobID uuid.UUID) (int64, error) {
+	var count int64
+	err := db.Model(&JobIP{}).Where("job_id = ?", jobID).Count(&count).Error
+	return count, err
+}
+
+func DeleteJobIPs(db *gorm.DB, jobID uuid.UUID) error {
+	return db.Where("job_id = ?", jobID).Delete(&JobIP{}).Error
+}
+
 type TrackedKeywordsCount struct {
 	LogReportID uuid.UUID `json:"logReportID" gorm:"column:log_report_id;primaryKey"`
 	Keyword     string    `json:"keyword" gorm:"column:keyword;primaryKey"`
@@ -96,3 +164,23 @@ type TrackedKeywordsCount struct {
 func (tkc TrackedKeywordsCount) TableName() string {
 	return "tracked_keywords_counts"
 }
+
+// JobCheckpoint is the progress of a job persisted while it is being processed,
+// so that a retried job can resume from where the previous attempt stopped.
+type JobCheckpoint struct {
+	JobID     uuid.UUID `json:"jobID" gorm:"column:job_id;primaryKey"`
+	State     []byte    `json:"-" gorm:"column:state;not null"` //serialized by the workers (byte offsets and partial metrics)
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at"`
+
+	Job Job `json:"-" gorm:"foreignKey:JobID;references:ID"`
+}
+
+func (jc JobCheckpoint) TableName() string {
+	return "job_checkpoints"
+}
+
+// Save creates the checkpoint, or overwrites the previous checkpoint of the job
+func (jc *JobCheckpoint) Save(db *gorm.DB) error {
+	jc.UpdatedAt = time.Now()
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(jc).Error
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles of the members of an organization, each allowed what the ones below it are
+const (
+	RoleViewer = "viewer" //reads the jobs and stats of the organization
+	RoleMember = "member" //uploads jobs too
+	RoleAdmin  = "admin"  //manages members (but owners), keywords, and the jobs of others
+	RoleOwner  = "owner"  //manages owners too
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleMember: 2, RoleAdmin: 3, RoleOwner: 4}
+
+func IsValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAtLeast reports whether role is allowed what minRole is
+func RoleAtLeast(role, minRole string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[minRole]
+}
+
+// Organization is a workspace shared by its members. Jobs uploaded into it belong to it, rather than to the uploader alone.
+type Organization struct {
+	ID        uuid.UUID `json:"id" gorm:"column:id;primaryKey"`
+	Name      string    `json:"name" gorm:"column:name;not null"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+func (o Organization) TableName() string {
+	return "organizations"
+}
+
+// Create saves the organization, with its creator as owner.
+func (o *Organization) Create(db *gorm.DB, creatorID uuid.UUID) error {
+	o.ID = uuid.New()
+	o.CreatedAt = time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(o).Error; err != nil {
+			return err
+		}
+		owner := OrgMember{OrgID: o.ID, UserID: creatorID, Role: RoleOwner}
+		return owner.Save(tx)
+	})
+}
+
+type OrgMember struct {
+	OrgID     uuid.UUID `json:"orgID" gorm:"column:org_id;primaryKey"`
+	UserID    uuid.UUID `json:"userID" gorm:"column:user_id;primaryKey;index"`
+	Role      string    `json:"role" gorm:"column:role;not null"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+func (om OrgMember) TableName() string {
+	return "org_members"
+}
+
+// Save adds the member, or updates its role.
+func (om *OrgMember) Save(db *gorm.DB) error {
+	om.CreatedAt = time.Now()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "org_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role"}),
+	}).Create(om).Error
+}
+
+func GetOrgMembers(db *gorm.DB, orgID uuid.UUID) ([]OrgMember, error) {
+	var members []OrgMember
+	err := db.Where("org_id = ?", orgID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+// ErrLastOwner is returned when removing or demoting the last owner of an organization, which would leave it unmanageable
+var ErrLastOwner = errors.New("the organization must keep an owner")
+
+// SaveOrgMember adds the member or updates its role, unless it's the last owner being demoted.
+func SaveOrgMember(db *gorm.DB, member *OrgMember) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if member.Role != RoleOwner {
+			if err := checkNotLastOwner(tx, member.OrgID, member.UserID); err != nil {
+				return err
+			}
+		}
+		return member.Save(tx)
+	})
+}
+
+// DeleteOrgMember removes the member unless it's the last owner, and reports whether it was one.
+func DeleteOrgMember(db *gorm.DB, orgID, userID uuid.UUID) (deleted bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+			return err
+		}
+		result := tx.Where("org_id = ? AND user_id = ?", orgID, userID).Delete(&OrgMember{})
+		deleted = result.RowsAffected > 0
+		return result.Error
+	})
+	return deleted, err
+}
+
+func checkNotLastOwner(tx *gorm.DB, orgID, userID uuid.UUID) error {
+	var owners []uuid.UUID
+	if err := tx.Model(&OrgMember{}).Where("org_id = ? AND role = ?", orgID, RoleOwner).Pluck("user_id", &owners).Error; err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0] == userID {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// GetOrgMember returns the member, or nil if the user isn't one.
+func GetOrgMember(db *gorm.DB, orgID, userID uuid.UUID) (*OrgMember, error) {
+	var members []OrgMember
+	if err := db.Where("org_id = ? AND user_id = ?", orgID, userID).Limit(1).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return &members[0], nil
+}
+
+// UserOrganization is an organization the user is a member of, with their role in it
+type UserOrganization struct {
+	Organization
+	Role string `json:"role" gorm:"column:role"`
+}
+
+func GetUserOrganizations(db *gorm.DB, userID uuid.UUID) ([]UserOrganization, error) {
+	var orgs []UserOrganization
+	err := db.Raw(`
+	SELECT organizations.*, org_members.role
+	FROM organizations
+	JOIN org_members ON org_members.org_id = organizations.id
+	WHERE org_members.user_id = ?
+	ORDER BY organizations.created_at
+	`, userID).Scan(&orgs).Error
+	return orgs, err
+}
+
+// OrgKeyword is a keyword tracked in the jobs of an organization. Together, they are the keyword profile of the organization,
+// tracked instead of the default keywords if set.
+type OrgKeyword struct {
+	OrgID   uuid.UUID `json:"-" gorm:"column:org_id;primaryKey"`
+	Keyword string    `json:"keyword" gorm:"column:keyword;primaryKey"`
+}
+
+func (ok OrgKeyword) TableName() string {
+	return "org_keywords"
+}
+
+func GetOrgKeywords(db *gorm.DB, orgID uuid.UUID) ([]string, error) {
+	var keywords []string
+	err := db.Model(&OrgKeyword{}).Where("org_id = ?", orgID).Order("keyword").Pluck("keyword", &keywords).Error
+	return keywords, err
+}
+
+// SetOrgKeywords replaces the keyword profile of the organization. Jobs already uploaded keep tracking the keywords they were uploaded with.
+func SetOrgKeywords(db *gorm.DB, orgID uuid.UUID, keywords []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id = ?", orgID).Delete(&OrgKeyword{}).Error; err != nil {
+			return err
+		}
+		if len(keywords) == 0 {
+			return nil
+		}
+		orgKeywords := make([]OrgKeyword, 0, len(keywords))
+		for _, keyword := range keywords {
+			orgKeywords = append(orgKeywords, OrgKeyword{OrgID: orgID, Keyword: keyword})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&orgKeywords).Error
+	})
+}
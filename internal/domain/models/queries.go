@@ -1,7 +1,10 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"log-flow/internal/utils/hyperloglog"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -48,7 +51,8 @@ func GetLogReportByJobID(db *gorm.DB, jobID string) (*LogReport, error) {
 }
 
 type WholeLogReportsAggregate struct {
-	UserID           uuid.UUID      `gorm:"column:user_id" json:"userID"`
+	UserID           uuid.UUID      `gorm:"-" json:"userID"`
+	OrgID            *uuid.UUID     `gorm:"-" json:"orgID,omitempty"` //of the workspace aggregated, nil for the personal one
 	TotalLogReports  int            `gorm:"column:total_log_reports" json:"totalLogReports"`
 	TotalJobs        int            `gorm:"column:total_jobs" json:"totalJobs"`
 	TotalLogs        int            `gorm:"column:total_logs" json:"totalLogs"`
@@ -56,31 +60,74 @@ type WholeLogReportsAggregate struct {
 	TotalErrorLogs   int            `gorm:"column:total_error_logs" json:"totalErrorLogs"`
 	TotalWarningLogs int            `gorm:"column:total_warning_logs" json:"totalWarningLogs"`
 	TotalInfoLogs    int            `gorm:"column:total_info_logs" json:"totalInfoLogs"`
-	TotalUniqueIPs   int            `gorm:"column:total_unique_ips" json:"totalUniqueIPs"`
+	TotalUniqueIPs   int            `gorm:"-" json:"totalUniqueIPs"`
+	UniqueIPsExact   bool           `gorm:"-" json:"uniqueIPsExact"` //false if estimated, or if some reports have no IP data to deduplicate across
 	TotalInvalidLogs int            `gorm:"column:total_invalid_logs" json:"totalInvalidLogs"`
+
+	// Reports saved before their distinct IPs were persisted, which can only be summed
+	ReportsWithoutIPData   int `gorm:"column:reports_without_ip_data" json:"-"`
+	UniqueIPsWithoutIPData int `gorm:"column:unique_ips_without_ip_data" json:"-"`
+	// Reports whose IPs weren't saved in job_ips, having too many, which only their sketch estimates
+	ReportsWithoutSavedIPs int `gorm:"column:reports_without_saved_ips" json:"-"`
+}
+
+// ReportsFilter narrows down the reports to aggregate. Zero times mean no bound.
+type ReportsFilter struct {
+	OrgID   uuid.UUID //jobs of the organization, or of the user's personal workspace if nil
+	AllJobs bool      //jobs of all users and organizations, for system-wide stats, instead of a workspace
+	From    time.Time //jobs uploaded at or after
+	To      time.Time //jobs uploaded before
 }
 
-func GetWholeLogReportsAggregate(db *gorm.DB, userID uuid.UUID) (*WholeLogReportsAggregate, error) {
+func (f ReportsFilter) whereClause(userID uuid.UUID) (string, []any) {
+	where := "jobs.user_id = ? AND jobs.org_id IS NULL"
+	args := []any{userID}
+	switch {
+	case f.AllJobs:
+		where = "TRUE"
+		args = nil
+	case f.OrgID != uuid.Nil:
+		where = "jobs.org_id = ?"
+		args = []any{f.OrgID}
+	}
+	if !f.From.IsZero() {
+		where += " AND jobs.uploaded_at >= ?"
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		where += " AND jobs.uploaded_at < ?"
+		args = append(args, f.To)
+	}
+	return where, args
+}
+
+func GetWholeLogReportsAggregate(db *gorm.DB, userID uuid.UUID, filter ReportsFilter) (*WholeLogReportsAggregate, error) {
+	where, args := filter.whereClause(userID)
+
 	var wholeLogReportsAggregate WholeLogReportsAggregate
 	result := db.Raw(`
 	SELECT
-		jobs.user_id,
 		COUNT(DISTINCT log_stats.id) AS total_log_reports,
 		COUNT(DISTINCT jobs.id) AS total_jobs,
 		COALESCE(SUM(log_stats.total_logs), 0) AS total_logs,
 		COALESCE(SUM(log_stats.error_count), 0) AS total_error_logs,
 		COALESCE(SUM(log_stats.warn_count), 0) AS total_warning_logs,
 		COALESCE(SUM(log_stats.info_count), 0) AS total_info_logs,
-		COALESCE(SUM(log_stats.unique_ips), 0) AS total_unique_ips,
-		COALESCE(SUM(log_stats.invalid_logs), 0) AS total_invalid_logs
+		COALESCE(SUM(log_stats.invalid_logs), 0) AS total_invalid_logs,
+		COALESCE(SUM(CASE WHEN log_stats.unique_ip_sketch IS NULL THEN 1 ELSE 0 END), 0) AS reports_without_ip_data,
+		COALESCE(SUM(CASE WHEN log_stats.unique_ip_sketch IS NULL THEN log_stats.unique_ips ELSE 0 END), 0) AS unique_ips_without_ip_data,
+		COALESCE(SUM(CASE WHEN log_stats.unique_ip_sketch IS NOT NULL AND NOT log_stats.ips_persisted THEN 1 ELSE 0 END), 0) AS reports_without_saved_ips
 	FROM log_stats
 	LEFT JOIN jobs ON log_stats.job_id = jobs.id
-	WHERE jobs.user_id = ?
-	GROUP BY jobs.user_id
-	`, userID).Scan(&wholeLogReportsAggregate)
+	WHERE `+where+`
+	`, args...).Scan(&wholeLogReportsAggregate)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	wholeLogReportsAggregate.UserID = userID
+	if filter.OrgID != uuid.Nil {
+		wholeLogReportsAggregate.OrgID = &filter.OrgID
+	}
 
 	type KeywordCount struct {
 		Keyword string
@@ -96,23 +143,129 @@ func GetWholeLogReportsAggregate(db *gorm.DB, userID uuid.UUID) (*WholeLogReport
 	FROM tracked_keywords_counts
 	JOIN log_stats ON tracked_keywords_counts.log_report_id = log_stats.id
 	JOIN jobs ON log_stats.job_id = jobs.id
-	WHERE jobs.user_id = ?
+	WHERE `+where+`
 	GROUP BY tracked_keywords_counts.keyword
-	`, userID).Scan(&keywordCounts)
+	`, args...).Scan(&keywordCounts)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 
-	fmt.Println("keywordCounts", keywordCounts)
-
 	wholeLogReportsAggregate.TrackedKeywords = make(map[string]int)
 	for _, kc := range keywordCounts {
 		wholeLogReportsAggregate.TrackedKeywords[kc.Keyword] = kc.Count
 	}
 
+	// Summing unique IPs would count an IP once per report it appears in, so the persisted
+	// distinct IPs of the reports are counted across them instead: exactly if all of them saved
+	// their IPs, otherwise estimated by merging their sketches.
+	uniqueIPs, exact := 0, true
+	if wholeLogReportsAggregate.ReportsWithoutSavedIPs == 0 {
+		result = db.Raw(`
+		SELECT COUNT(DISTINCT job_ips.ip)
+		FROM job_ips
+		JOIN log_stats ON log_stats.job_id = job_ips.job_id
+		JOIN jobs ON jobs.id = job_ips.job_id
+		WHERE `+where+` AND log_stats.ips_persisted
+		`, args...).Scan(&uniqueIPs)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+	} else {
+		merged, err := mergeUniqueIPSketches(db, where, args)
+		if err != nil {
+			return nil, err
+		}
+		uniqueIPs, exact = int(merged.Count()), merged.IsExact()
+	}
+	wholeLogReportsAggregate.TotalUniqueIPs = uniqueIPs + wholeLogReportsAggregate.UniqueIPsWithoutIPData
+	wholeLogReportsAggregate.UniqueIPsExact = exact && wholeLogReportsAggregate.ReportsWithoutIPData == 0
+
 	return &wholeLogReportsAggregate, nil
 }
 
+func mergeUniqueIPSketches(db *gorm.DB, where string, args []any) (*hyperloglog.Sketch, error) {
+	rows, err := db.Raw(`
+	SELECT log_stats.unique_ip_sketch
+	FROM log_stats
+	JOIN jobs ON log_stats.job_id = jobs.id
+	WHERE `+where+` AND log_stats.unique_ip_sketch IS NOT NULL
+	`, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	merged := hyperloglog.New()
+	sketch := hyperloglog.New()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("Error decoding unique IPs of report: %v", err)
+		}
+		merged.Merge(sketch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// JobCounts are the jobs of all users by state
+type JobCounts struct {
+	Total      int `gorm:"column:total" json:"total"`
+	Succeeded  int `gorm:"column:succeeded" json:"succeeded"`
+	Failed     int `gorm:"column:failed" json:"failed"`         //out of attempts, left in the failed queue
+	Processing int `gorm:"column:processing" json:"processing"` //leased by a worker
+	Pending    int `gorm:"column:pending" json:"pending"`       //queued, or waiting for a retry
+	Uploaders  int `gorm:"column:uploaders" json:"uploaders"`
+}
+
+func GetJobCounts(db *gorm.DB, now time.Time) (*JobCounts, error) {
+	var counts JobCounts
+	result := db.Raw(`
+	SELECT
+		COUNT(*) AS total,
+		COALESCE(SUM(CASE WHEN succeeded THEN 1 ELSE 0 END), 0) AS succeeded,
+		COALESCE(SUM(CASE WHEN NOT succeeded AND attempts >= ? AND (lease_expires_at IS NULL OR lease_expires_at <= ?) THEN 1 ELSE 0 END), 0) AS failed,
+		COALESCE(SUM(CASE WHEN NOT succeeded AND lease_expires_at > ? THEN 1 ELSE 0 END), 0) AS processing,
+		COUNT(DISTINCT user_id) AS uploaders
+	FROM jobs
+	`, JobMaxAttempts, now, now).Scan(&counts)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	counts.Pending = counts.Total - counts.Succeeded - counts.Failed - counts.Processing
+	return &counts, nil
+}
+
+// ResetJobAttempts makes the job failed out of attempts pending again, once it's replayed from the failed queue.
+// Jobs that succeeded since, processed from the replayed message, are left alone.
+func ResetJobAttempts(db *gorm.DB, jobID string) error {
+	return db.Exec("UPDATE jobs SET attempts = 0 WHERE id = ? AND NOT succeeded", jobID).Error
+}
+
+// GetJobCheckpoint returns the checkpoint of the job, or nil if it has none
+func GetJobCheckpoint(db *gorm.DB, jobID string) (*JobCheckpoint, error) {
+	var checkpoint JobCheckpoint
+	result := db.Where("job_id = ?", jobID).Limit(1).Find(&checkpoint)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &checkpoint, nil
+}
+
+func DeleteJobCheckpoint(db *gorm.DB, jobID string) error {
+	return db.Where("job_id = ?", jobID).Delete(&JobCheckpoint{}).Error
+}
+
 func AddFailAttemptForJob(db *gorm.DB, jobID string) error {
 	result := db.Exec("UPDATE jobs SET attempts = attempts + 1, succeeded = false WHERE id = ?", jobID)
 	if result.Error != nil {
@@ -123,3 +276,88 @@ func AddFailAttemptForJob(db *gorm.DB, jobID string) error {
 	}
 	return nil
 }
+
+// UndoFailAttemptForJob uncounts an attempt that was interrupted rather than failed.
+func UndoFailAttemptForJob(db *gorm.DB, jobID string) error {
+	return db.Exec("UPDATE jobs SET attempts = attempts - 1 WHERE id = ? AND attempts > 0", jobID).Error
+}
+
+// ErrJobNotFound is returned by ClaimJobLease for jobs without a row, which can't be processed
+var ErrJobNotFound = errors.New("job not found")
+
+// ClaimJobLease leases the job to a worker until leaseUntil, unless maxPerUser jobs of its user are leased already.
+// It reports whether the job was leased. Claims of the jobs of a user are serialized, so that concurrent workers
+// can't all see the user under its limit.
+func ClaimJobLease(db *gorm.DB, jobID, userID string, maxPerUser int, leaseUntil, now time.Time) (bool, error) {
+	leased := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockKey(tx, "job_lease:"+userID); err != nil {
+			return err
+		}
+
+		var jobIDs []string
+		if err := tx.Model(&Job{}).Where("id = ?", jobID).Limit(1).Pluck("id", &jobIDs).Error; err != nil {
+			return err
+		}
+		if len(jobIDs) == 0 {
+			return ErrJobNotFound
+		}
+
+		var leasedJobs int64
+		err := tx.Model(&Job{}).
+			Where("user_id = ? AND id <> ? AND lease_expires_at > ?", userID, jobID, now).
+			Count(&leasedJobs).Error
+		if err != nil || leasedJobs >= int64(maxPerUser) {
+			return err
+		}
+
+		leased = true
+		return tx.Exec("UPDATE jobs SET lease_expires_at = ? WHERE id = ?", leaseUntil, jobID).Error
+	})
+	return leased, err
+}
+
+// lockKey holds a lock of the key until the end of the transaction, to serialize the transactions reading
+// then writing what the key stands for. It's a Postgres advisory lock. SQLite, used by tests, has no such
+// locks, but only lets one transaction write at a time.
+func lockKey(tx *gorm.DB, key string) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", key).Error
+}
+
+func RenewJobLease(db *gorm.DB, jobID string, leaseUntil time.Time) error {
+	return db.Exec("UPDATE jobs SET lease_expires_at = ? WHERE id = ?", leaseUntil, jobID).Error
+}
+
+func ReleaseJobLease(db *gorm.DB, jobID string) error {
+	return db.Exec("UPDATE jobs SET lease_expires_at = NULL WHERE id = ?", jobID).Error
+}
+
+// LogReportWithoutIPData is a report saved before the distinct IPs of reports were persisted.
+type LogReportWithoutIPData struct {
+	ID      uuid.UUID `gorm:"column:id"`
+	JobID   uuid.UUID `gorm:"column:job_id"`
+	FileURL string    `gorm:"column:file_url"`
+}
+
+func GetLogReportsWithoutIPData(db *gorm.DB) ([]LogReportWithoutIPData, error) {
+	var reports []LogReportWithoutIPData
+	result := db.Raw(`
+	SELECT log_stats.id, log_stats.job_id, jobs.file_url
+	FROM log_stats
+	JOIN jobs ON log_stats.job_id = jobs.id
+	WHERE log_stats.unique_ip_sketch IS NULL
+	`).Scan(&reports)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return reports, nil
+}
+
+// SetLogReportIPData sets the unique IPs of the report, its sketch of them and whether they are saved in job_ips.
+func SetLogReportIPData(db *gorm.DB, reportID uuid.UUID, ipData LogReport) error {
+	return db.Exec("UPDATE log_stats SET unique_ips = ?, unique_ip_sketch = ?, ips_persisted = ? WHERE id = ?",
+		ipData.UniqueIPs, ipData.UniqueIPSketch, ipData.IPsPersisted, reportID).Error
+}
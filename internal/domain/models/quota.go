@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Types of workspaces quotas apply to
+const (
+	WorkspaceUser = "user" //the personal workspace of a user
+	WorkspaceOrg  = "org"
+)
+
+// Quota overrides the default quotas of a workspace. Nil limits are the default ones, and 0 is no limit.
+type Quota struct {
+	WorkspaceType  string    `json:"workspaceType" gorm:"column:workspace_type;primaryKey"`
+	WorkspaceID    uuid.UUID `json:"workspaceID" gorm:"column:workspace_id;primaryKey"` //user or org ID
+	JobsPerDay     *int      `json:"jobsPerDay" gorm:"column:jobs_per_day"`
+	StorageMB      *int64    `json:"storageMB" gorm:"column:storage_mb"`
+	ConcurrentJobs *int      `json:"concurrentJobs" gorm:"column:concurrent_jobs"`
+	UpdatedAt      time.Time `json:"updatedAt" gorm:"column:updated_at"`
+}
+
+func (q Quota) TableName() string {
+	return "quotas"
+}
+
+// Save sets the overrides of the workspace, or drops them if all are nil.
+func (q *Quota) Save(db *gorm.DB) error {
+	if q.JobsPerDay == nil && q.StorageMB == nil && q.ConcurrentJobs == nil {
+		return db.Where("workspace_type = ? AND workspace_id = ?", q.WorkspaceType, q.WorkspaceID).Delete(&Quota{}).Error
+	}
+	q.UpdatedAt = time.Now()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workspace_type"}, {Name: "workspace_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"jobs_per_day", "storage_mb", "concurrent_jobs", "updated_at"}),
+	}).Create(q).Error
+}
+
+// GetQuota returns the overrides of the quotas of the workspace, or nil if it has none.
+func GetQuota(db *gorm.DB, workspaceType string, workspaceID uuid.UUID) (*Quota, error) {
+	var quotas []Quota
+	if err := db.Where("workspace_type = ? AND workspace_id = ?", workspaceType, workspaceID).Limit(1).Find(&quotas).Error; err != nil {
+		return nil, err
+	}
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+	return &quotas[0], nil
+}
+
+// WorkspaceUsage is what a workspace uses of its quotas
+type WorkspaceUsage struct {
+	JobsToday    int   `gorm:"column:jobs_today"`    //uploaded since dayStart
+	StorageBytes int64 `gorm:"column:storage_bytes"` //of the files of all the jobs uploaded
+	ActiveJobs   int   `gorm:"column:active_jobs"`   //queued or processing, neither succeeded nor failed out of attempts
+}
+
+// GetWorkspaceUsage returns the usage of the personal workspace of the user, or of the organization if not nil.
+// Jobs leased at now are active, even on their last attempt.
+func GetWorkspaceUsage(db *gorm.DB, userID, orgID uuid.UUID, dayStart, now time.Time) (*WorkspaceUsage, error) {
+	where, args := ReportsFilter{OrgID: orgID}.whereClause(userID)
+
+	var usage WorkspaceUsage
+	result := db.Raw(`
+	SELECT
+		COALESCE(SUM(CASE WHEN jobs.uploaded_at >= ? THEN 1 ELSE 0 END), 0) AS jobs_today,
+		COALESCE(SUM(jobs.file_size), 0) AS storage_bytes,
+		COALESCE(SUM(CASE WHEN NOT jobs.succeeded AND (jobs.attempts < ? OR jobs.lease_expires_at > ?) THEN 1 ELSE 0 END), 0) AS active_jobs
+	FROM jobs
+	WHERE `+where+`
+	`, append([]any{dayStart, JobMaxAttempts, now}, args...)...).Scan(&usage)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &usage, nil
+}
+
+// CreateJobWithinQuota saves the job, unless check returns an error for the usage of the workspace of the job, returned then.
+// The usage is checked and the job saved under a lock of the workspace, so uploads made at the same time can't go over
+// its quotas together.
+func CreateJobWithinQuota(db *gorm.DB, job *Job, dayStart, now time.Time, check func(usage *WorkspaceUsage) error) error {
+	workspaceKey := "quota:" + WorkspaceUser + ":" + job.UserID.String()
+	orgID := uuid.Nil
+	if job.OrgID != nil {
+		orgID = *job.OrgID
+		workspaceKey = "quota:" + WorkspaceOrg + ":" + orgID.String()
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockKey(tx, workspaceKey); err != nil {
+			return err
+		}
+		usage, err := GetWorkspaceUsage(tx, job.UserID, orgID, dayStart, now)
+		if err != nil {
+			return err
+		}
+		if err := check(usage); err != nil {
+			return err
+		}
+		return job.Create(tx)
+	})
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobShare gives a user other than the owner of a job access to it.
+type JobShare struct {
+	JobID     uuid.UUID `json:"jobID" gorm:"column:job_id;primaryKey"`
+	UserID    uuid.UUID `json:"userID" gorm:"column:user_id;primaryKey;index"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+func (js JobShare) TableName() string {
+	return "job_shares"
+}
+
+// Create saves the share, if the job isn't shared with the user already.
+func (js *JobShare) Create(db *gorm.DB) error {
+	js.CreatedAt = time.Now()
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(js).Error
+}
+
+func GetJobShares(db *gorm.DB, jobID string) ([]JobShare, error) {
+	var shares []JobShare
+	err := db.Where("job_id = ?", jobID).Order("created_at").Find(&shares).Error
+	return shares, err
+}
+
+// GetJobIDsSharedWith returns the IDs of the jobs shared with the user.
+func GetJobIDsSharedWith(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	var jobIDs []string
+	err := db.Model(&JobShare{}).Where("user_id = ?", userID).Pluck("job_id", &jobIDs).Error
+	return jobIDs, err
+}
+
+// DeleteJobShare unshares the job with the user, and reports whether it was shared with them.
+func DeleteJobShare(db *gorm.DB, jobID string, userID uuid.UUID) (bool, error) {
+	result := db.Where("job_id = ? AND user_id = ?", jobID, userID).Delete(&JobShare{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ErrNotOrgMember is returned when transferring a job of an organization to a user who isn't a member of it
+var ErrNotOrgMember = errors.New("the new owner isn't a member of the organization of the job")
+
+// TransferJob makes the user the owner of the job. A share of the job with the new owner is dropped, as they own it now.
+// Jobs of an organization can only be transferred to its members.
+func TransferJob(db *gorm.DB, jobID string, newOwnerID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		job, err := GetJobByID(tx, jobID)
+		if err != nil {
+			return err
+		}
+		if job.OrgID != nil {
+			member, err := GetOrgMember(tx, *job.OrgID, newOwnerID)
+			if err != nil {
+				return err
+			}
+			if member == nil {
+				return ErrNotOrgMember
+			}
+		}
+
+		if err := tx.Exec("UPDATE jobs SET user_id = ? WHERE id = ?", newOwnerID, jobID).Error; err != nil {
+			return err
+		}
+		return tx.Where("job_id = ? AND user_id = ?", jobID, newOwnerID).Delete(&JobShare{}).Error
+	})
+}
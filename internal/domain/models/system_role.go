@@ -0,0 +1,171 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// System roles of users, apart from their roles in organizations. Users without one stored are plain users.
+const (
+	SystemRoleUser  = "user"
+	SystemRoleAdmin = "admin" //operates the deployment: queues, failed jobs, users and system-wide stats
+)
+
+func IsValidSystemRole(role string) bool {
+	return role == SystemRoleUser || role == SystemRoleAdmin
+}
+
+// UserRole is the system role of a user, stored for users with any but SystemRoleUser.
+// Users are kept by the auth provider, so roles are keyed by user ID only.
+type UserRole struct {
+	UserID    uuid.UUID `json:"userID" gorm:"column:user_id;primaryKey"`
+	Role      string    `json:"role" gorm:"column:role;not null"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
+
+func (ur UserRole) TableName() string {
+	return "user_roles"
+}
+
+// ErrLastAdmin is returned when demoting or deleting the last admin, which would leave the deployment without one
+var ErrLastAdmin = errors.New("there must be an admin left")
+
+// GetSystemRole returns the system role of the user, SystemRoleUser if none is stored.
+func GetSystemRole(db *gorm.DB, userID uuid.UUID) (string, error) {
+	var roles []string
+	if err := db.Model(&UserRole{}).Where("user_id = ?", userID).Limit(1).Pluck("role", &roles).Error; err != nil {
+		return "", err
+	}
+	if len(roles) == 0 {
+		return SystemRoleUser, nil
+	}
+	return roles[0], nil
+}
+
+// SetSystemRole sets the system role of the user, unless it's the last admin being demoted.
+func SetSystemRole(db *gorm.DB, userID uuid.UUID, role string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if role != SystemRoleAdmin {
+			if err := checkNotLastAdmin(tx, userID); err != nil {
+				return err
+			}
+		}
+		if role == SystemRoleUser {
+			return tx.Where("user_id = ?", userID).Delete(&UserRole{}).Error
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).Create(&UserRole{UserID: userID, Role: role, CreatedAt: time.Now()}).Error
+	})
+}
+
+// checkNotLastAdmin locks the rows of the admins till the end of the transaction, for concurrent demotions
+// of different admins not to both see the other one left.
+func checkNotLastAdmin(tx *gorm.DB, userID uuid.UUID) error {
+	var admins []uuid.UUID
+	if err := tx.Model(&UserRole{}).Clauses(clause.Locking{Strength: "UPDATE"}).Where("role = ?", SystemRoleAdmin).Pluck("user_id", &admins).Error; err != nil {
+		return err
+	}
+	if len(admins) == 1 && admins[0] == userID {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// GetUserRoles returns the stored system roles, by user ID.
+func GetUserRoles(db *gorm.DB) (map[uuid.UUID]string, error) {
+	var userRoles []UserRole
+	if err := db.Find(&userRoles).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[uuid.UUID]string, len(userRoles))
+	for _, userRole := range userRoles {
+		roles[userRole.UserID] = userRole.Role
+	}
+	return roles, nil
+}
+
+// AdminUser is a user as listed to admins: an account of the local auth provider, or a user known by a stored role only.
+type AdminUser struct {
+	ID        uuid.UUID  `json:"id"`
+	Email     string     `json:"email,omitempty"`
+	Role      string     `json:"role"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"` //of the account, nil for users kept by another auth provider
+}
+
+// GetAdminUsers lists the accounts of the local auth provider, and the users with a stored role,
+// as users kept by other auth providers aren't known otherwise.
+func GetAdminUsers(db *gorm.DB) ([]AdminUser, error) {
+	var accounts []User
+	if err := db.Order("created_at").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	roles, err := GetUserRoles(db)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]AdminUser, 0, len(accounts)+len(roles))
+	for _, account := range accounts {
+		user := AdminUser{ID: account.ID, Email: account.Email, Role: SystemRoleUser, CreatedAt: &account.CreatedAt}
+		if role, ok := roles[account.ID]; ok {
+			user.Role = role
+			delete(roles, account.ID)
+		}
+		users = append(users, user)
+	}
+	for userID, role := range roles {
+		users = append(users, AdminUser{ID: userID, Role: role})
+	}
+	return users, nil
+}
+
+// DeleteUser deletes the account of the local auth provider, the stored role, the API keys, the org memberships and
+// the job shares of the user, and revokes the tokens issued to them so far. It refuses to delete the last admin, or the
+// last owner of an organization. It reports whether there was an account, a role, a membership or a share. The jobs of the user are kept.
+func DeleteUser(db *gorm.DB, userID uuid.UUID) (deleted bool, err error) {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := checkNotLastAdmin(tx, userID); err != nil {
+			return err
+		}
+		var ownedOrgs []uuid.UUID
+		if err := tx.Model(&OrgMember{}).Where("user_id = ? AND role = ?", userID, RoleOwner).Pluck("org_id", &ownedOrgs).Error; err != nil {
+			return err
+		}
+		for _, orgID := range ownedOrgs {
+			if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+
+		roles := tx.Where("user_id = ?", userID).Delete(&UserRole{})
+		if roles.Error != nil {
+			return roles.Error
+		}
+		memberships := tx.Where("user_id = ?", userID).Delete(&OrgMember{})
+		if memberships.Error != nil {
+			return memberships.Error
+		}
+		shares := tx.Where("user_id = ?", userID).Delete(&JobShare{})
+		if shares.Error != nil {
+			return shares.Error
+		}
+		for _, model := range []any{&PasswordResetToken{}, &APIKey{}, &WsTicket{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := RevokeUserTokens(tx, userID, time.Now()); err != nil {
+			return err
+		}
+		accounts := tx.Where("id = ?", userID).Delete(&User{})
+		deleted = roles.RowsAffected > 0 || memberships.RowsAffected > 0 || shares.RowsAffected > 0 || accounts.RowsAffected > 0
+		return accounts.Error
+	})
+	return deleted, err
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// User is an account of the local auth provider. With Supabase, users are kept by Supabase instead.
+type User struct {
+	ID           uuid.UUID `json:"id" gorm:"column:id;primaryKey"`
+	Email        string    `json:"email" gorm:"column:email;not null;uniqueIndex"` //lower case
+	PasswordHash []byte    `json:"-" gorm:"column:password_hash;not null"`
+	CreatedAt    time.Time `json:"createdAt" gorm:"column:created_at"`
+	UpdatedAt    time.Time `json:"updatedAt" gorm:"column:updated_at"`
+}
+
+func (u User) TableName() string {
+	return "users"
+}
+
+// ErrEmailExists is returned when creating a user with the email of another one
+var ErrEmailExists = errors.New("a user with the email exists already")
+
+// Create saves the user, or returns ErrEmailExists if the email is taken, even by a user created at the same time.
+func (u *User) Create(db *gorm.DB) error {
+	u.ID = uuid.New()
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	result := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "email"}}, DoNothing: true}).Create(u)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrEmailExists
+	}
+	return nil
+}
+
+// GetUserByEmail returns the user, or nil if there is none with the email.
+func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
+	var users []User
+	if err := db.Where("email = ?", email).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+// GetUserByID returns the user, or nil if there is none with the ID.
+func GetUserByID(db *gorm.DB, userID string) (*User, error) {
+	var users []User
+	if err := db.Where("id = ?", userID).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+// PasswordResetToken lets the user set a new password, once. Only a hash of the token is stored.
+type PasswordResetToken struct {
+	TokenHash []byte    `json:"-" gorm:"column:token_hash;primaryKey"`
+	UserID    uuid.UUID `json:"userID" gorm:"column:user_id;not null;index"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at;not null"`
+}
+
+func (prt PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+func HashResetToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// Create saves the token, clearing the expired ones.
+func (prt *PasswordResetToken) Create(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(prt).Error
+	})
+}
+
+// ResetPassword sets the password hash of the user of the token, if the token is valid for the user,
+// and drops all the reset tokens of the user. It reports whether the token was valid.
+func ResetPassword(db *gorm.DB, token string, userID uuid.UUID, passwordHash []byte) (bool, error) {
+	reset := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var consumed []PasswordResetToken
+		result := tx.Clauses(clause.Returning{}).
+			Where("token_hash = ? AND user_id = ? AND expires_at > ?", HashResetToken(token), userID, time.Now()).
+			Delete(&consumed)
+		if result.Error != nil || len(consumed) == 0 {
+			return result.Error
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]any{
+			"password_hash": passwordHash,
+			"updated_at":    time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		reset = true
+		return tx.Where("user_id = ?", userID).Delete(&PasswordResetToken{}).Error
+	})
+	return reset, err
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WorkerPool is the scaling state of the workers of a process, saved periodically by the process,
+// for the queue status to show the workers of every process.
+type WorkerPool struct {
+	Process       string    `json:"process" gorm:"column:process;primaryKey"` //hostname and pid
+	Workers       int       `json:"workers" gorm:"column:workers"`
+	MinWorkers    int       `json:"minWorkers" gorm:"column:min_workers"`
+	MaxWorkers    int       `json:"maxWorkers" gorm:"column:max_workers"`
+	BusyWorkers   int       `json:"busyWorkers" gorm:"column:busy_workers"`
+	AvgJobSeconds float64   `json:"avgJobSeconds" gorm:"column:avg_job_seconds"`
+	LastDecision  string    `json:"lastDecision" gorm:"column:last_decision"`
+	LastScaledAt  time.Time `json:"lastScaledAt" gorm:"column:last_scaled_at"`
+	UpdatedAt     time.Time `json:"updatedAt" gorm:"column:updated_at"`
+}
+
+func (wp WorkerPool) TableName() string {
+	return "worker_pools"
+}
+
+// Save creates the pool, or overwrites the previous state of the process' pool
+func (wp *WorkerPool) Save(db *gorm.DB) error {
+	wp.UpdatedAt = time.Now()
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(wp).Error
+}
+
+func DeleteWorkerPool(db *gorm.DB, process string) error {
+	return db.Where("process = ?", process).Delete(&WorkerPool{}).Error
+}
+
+// GetActiveWorkerPools returns the pools saved since the given time, of the processes still running
+func GetActiveWorkerPools(db *gorm.DB, since time.Time) ([]WorkerPool, error) {
+	var pools []WorkerPool
+	result := db.Where("updated_at >= ?", since).Order("process").Find(&pools)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return pools, nil
+}
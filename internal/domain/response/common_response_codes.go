@@ -8,6 +8,7 @@ const (
 	InternalServerError = "INTERNAL_SERVER_ERROR"
 	Unauthorized        = "UNAUTHORIZED"
 	Forbidden           = "FORBIDDEN"
+	ServiceUnavailable  = "SERVICE_UNAVAILABLE"
 
 	ParsingFormDataErr   = "PARSING_FORM_DATA_ERROR"
 	BadRequest           = "BAD REQUEST DATA"
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log-flow/internal/domain/models"
+	jwttoken "log-flow/internal/utils/jwt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAccessTokenTTL  = time.Hour
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	resetTokenTTL          = time.Hour
+)
+
+// Compared with for unknown emails, for logins to take as long whether the email exists or not
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// ResetTokenSender delivers a password reset token to the user of the email
+type ResetTokenSender func(email, token string) error
+
+// LocalProvider keeps users in the database, with bcrypt hashed passwords, and issues its own access and refresh tokens,
+// signed with AUTH_JWT_SECRET. It doesn't need Supabase, for air-gapped deployments.
+type LocalProvider struct {
+	db              *gorm.DB
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	sendResetToken  ResetTokenSender
+}
+
+// NewLocalProvider returns a provider issuing tokens valid for the TTLs, defaults if 0.
+func NewLocalProvider(db *gorm.DB, accessTokenTTL, refreshTokenTTL time.Duration, sendResetToken ResetTokenSender) *LocalProvider {
+	if accessTokenTTL <= 0 {
+		accessTokenTTL = defaultAccessTokenTTL
+	}
+	if refreshTokenTTL <= 0 {
+		refreshTokenTTL = defaultRefreshTokenTTL
+	}
+	return &LocalProvider{
+		db:              db,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
+		sendResetToken:  sendResetToken,
+	}
+}
+
+func (p *LocalProvider) Login(email, password string) (*Session, error) {
+	user, err := models.GetUserByEmail(p.db, normalizeEmail(email))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user. %v", err)
+	}
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return p.newSession(user)
+}
+
+// Register creates the user, signed in right away as there are no emails to confirm.
+func (p *LocalProvider) Register(email, password string) (*Session, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash password. %v", err)
+	}
+	user := models.User{Email: normalizeEmail(email), PasswordHash: passwordHash}
+	err = user.Create(p.db)
+	if errors.Is(err, models.ErrEmailExists) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save user. %v", err)
+	}
+	return p.newSession(&user)
+}
+
+// Refresh issues a new session with the refresh token, which is revoked, as tokens are rotated on every refresh.
+func (p *LocalProvider) Refresh(refreshToken string) (*Session, error) {
+	claims, err := jwttoken.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w. %v", ErrInvalidRefreshToken, err)
+	}
+	//revoking first, for a token to be used once even when refreshed concurrently
+	revoked, err := models.RevokeToken(p.db, claims.TokenID, claims.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to revoke refresh token. %v", err)
+	}
+	if !revoked {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := models.GetUserByID(p.db, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user. %v", err)
+	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return p.newSession(user)
+}
+
+// Logout revokes the refresh token, if given and of the user. Revoking the access token is left to the caller.
+func (p *LocalProvider) Logout(userID, accessToken, refreshToken string) error {
+	if refreshToken == "" {
+		return nil
+	}
+	claims, err := jwttoken.ValidateRefreshToken(refreshToken)
+	if err != nil || claims.UserID != userID {
+		return fmt.Errorf("%w. Not a refresh token of the user", ErrInvalidRefreshToken)
+	}
+	if _, err := models.RevokeToken(p.db, claims.TokenID, claims.ExpiresAt); err != nil {
+		return fmt.Errorf("Failed to revoke refresh token. %v", err)
+	}
+	return nil
+}
+
+// RequestPasswordReset sends a reset token valid for an hour to the user, if there is one with the email.
+func (p *LocalProvider) RequestPasswordReset(email string) error {
+	email = normalizeEmail(email)
+	user, err := models.GetUserByEmail(p.db, email)
+	if err != nil {
+		return fmt.Errorf("Failed to get user. %v", err)
+	}
+	if user == nil { //not disclosing which emails are registered
+		return nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("Failed to generate reset token. %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	resetToken := models.PasswordResetToken{
+		TokenHash: models.HashResetToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	}
+	if err := resetToken.Create(p.db); err != nil {
+		return fmt.Errorf("Failed to save reset token. %v", err)
+	}
+	return p.sendResetToken(email, token)
+}
+
+// ResetPassword sets the password, if the token was sent to the email and hasn't expired or been used.
+func (p *LocalProvider) ResetPassword(email, token, password string) error {
+	user, err := models.GetUserByEmail(p.db, normalizeEmail(email))
+	if err != nil {
+		return fmt.Errorf("Failed to get user. %v", err)
+	}
+	if user == nil {
+		return ErrInvalidResetToken
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("Failed to hash password. %v", err)
+	}
+	reset, err := models.ResetPassword(p.db, token, user.ID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("Failed to reset password. %v", err)
+	}
+	if !reset {
+		return ErrInvalidResetToken
+	}
+	return nil
+}
+
+func (p *LocalProvider) newSession(user *models.User) (*Session, error) {
+	accessToken, accessClaims, err := jwttoken.SignToken(user.ID.String(), false, p.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, _, err := jwttoken.SignToken(user.ID.String(), true, p.refreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "bearer",
+		ExpiresIn:    int(p.accessTokenTTL.Seconds()),
+		ExpiresAt:    accessClaims.ExpiresAt.Unix(),
+		User:         User{ID: user.ID, Email: user.Email},
+	}, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
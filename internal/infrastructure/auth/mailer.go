@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// NewSMTPResetSender emails reset tokens through the mail server at addr (host:port), authenticating if user is set.
+// Emails link to resetURL with the token and email as query params, or hold the token alone without it.
+func NewSMTPResetSender(addr, user, password, from, resetURL string) ResetTokenSender {
+	return func(email, token string) error {
+		var smtpAuth smtp.Auth
+		if user != "" {
+			host, _, _ := net.SplitHostPort(addr)
+			smtpAuth = smtp.PlainAuth("", user, password, host)
+		}
+
+		instructions := "Your password reset code, valid for an hour: " + token
+		if resetURL != "" {
+			instructions = "Reset your password within an hour at " + resetURL + "?" + url.Values{"token": {token}, "email": {email}}.Encode()
+		}
+		message := strings.Join([]string{
+			"From: " + from,
+			"To: " + email,
+			"Subject: Reset your Log-Flow password",
+			"",
+			instructions,
+			"",
+			"If you didn't ask for it, you can ignore this email.",
+		}, "\r\n")
+
+		if err := smtp.SendMail(addr, smtpAuth, from, []string{email}, []byte(message)); err != nil {
+			return fmt.Errorf("Failed to send password reset email. %v", err)
+		}
+		return nil
+	}
+}
+
+// LogResetToken logs reset tokens instead of sending them, for development without a mail server.
+// Anyone reading the logs could then reset any password, so it's only used with AUTH_LOG_RESET_TOKENS set.
+func LogResetToken(email, token string) error {
+	log.Warnf("Password reset token of %s (AUTH_LOG_RESET_TOKENS set): %s", email, token)
+	return nil
+}
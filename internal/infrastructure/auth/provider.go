@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Provider signs users up, in and out, and resets their passwords.
+// Access tokens it issues are validated by jwttoken.ValidateToken, and revoked on logout by their jti,
+// or by their session_id if they have none.
+type Provider interface {
+	Login(email, password string) (*Session, error)
+	Register(email, password string) (*Session, error) //without tokens if the email has to be confirmed first
+	Refresh(refreshToken string) (*Session, error)     //a new session, the refresh token can't be used again
+	Logout(userID, accessToken, refreshToken string) error
+	RequestPasswordReset(email string) error //sends a reset token to the user, if there is one with the email
+	ResetPassword(email, token, password string) error
+}
+
+// Session is what users get on login, shaped as Supabase sessions for clients to work with either provider
+type Session struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"` //seconds
+	ExpiresAt    int64  `json:"expires_at,omitempty"` //unix time
+	User         User   `json:"user"`
+}
+
+type User struct {
+	ID    uuid.UUID `json:"id"`
+	Email string    `json:"email"`
+}
+
+var (
+	ErrInvalidCredentials  = errors.New("Invalid email or password")
+	ErrEmailTaken          = errors.New("Email is already registered")
+	ErrInvalidResetToken   = errors.New("Invalid or expired password reset token")
+	ErrInvalidRefreshToken = errors.New("Invalid, expired or used refresh token")
+)
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/supabase-community/gotrue-go"
+	"github.com/supabase-community/gotrue-go/types"
+)
+
+// SupabaseProvider keeps users in Supabase Auth.
+type SupabaseProvider struct {
+	client gotrue.Client
+}
+
+func NewSupabaseProvider(projectReference, apiKey string) *SupabaseProvider {
+	return &SupabaseProvider{client: gotrue.New(projectReference, apiKey)}
+}
+
+func (p *SupabaseProvider) Login(email, password string) (*Session, error) {
+	resp, err := p.client.SignInWithEmailPassword(email, password)
+	if err != nil {
+		return nil, rejectedAs(err, ErrInvalidCredentials)
+	}
+	return sessionOf(resp.Session), nil
+}
+
+func (p *SupabaseProvider) Register(email, password string) (*Session, error) {
+	resp, err := p.client.Signup(types.SignupRequest{
+		Email:    email,
+		Password: password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" { //to be confirmed first
+		return &Session{User: User{ID: resp.User.ID, Email: resp.User.Email}}, nil
+	}
+	return sessionOf(resp.Session), nil
+}
+
+func (p *SupabaseProvider) Refresh(refreshToken string) (*Session, error) {
+	resp, err := p.client.RefreshToken(refreshToken)
+	if err != nil {
+		return nil, rejectedAs(err, ErrInvalidRefreshToken)
+	}
+	return sessionOf(resp.Session), nil
+}
+
+// Logout ends the Supabase session of the access token, revoking its refresh tokens.
+func (p *SupabaseProvider) Logout(userID, accessToken, refreshToken string) error {
+	return p.client.WithToken(accessToken).Logout()
+}
+
+// RequestPasswordReset has Supabase email a recovery code to the user.
+func (p *SupabaseProvider) RequestPasswordReset(email string) error {
+	return p.client.Recover(types.RecoverRequest{Email: email})
+}
+
+// ResetPassword verifies the recovery code, and sets the password as the user it signs in.
+func (p *SupabaseProvider) ResetPassword(email, token, password string) error {
+	verified, err := p.client.VerifyForUser(types.VerifyForUserRequest{
+		Type:  types.VerificationTypeRecovery,
+		Token: token,
+		Email: email,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.client.WithToken(verified.AccessToken).UpdateUser(types.UpdateUserRequest{Password: &password})
+	return err
+}
+
+// gotrue reports failed requests only by the text of its errors
+var gotrueStatusPattern = regexp.MustCompile(`^response status code (\d+)`)
+
+// rejectedAs returns errRejected if Supabase rejected the request (400 or 401), for the input of the user
+// rather than a failure of Supabase, and the error as is otherwise.
+func rejectedAs(err, errRejected error) error {
+	match := gotrueStatusPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	status, _ := strconv.Atoi(match[1])
+	if status == http.StatusBadRequest || status == http.StatusUnauthorized {
+		return errRejected
+	}
+	return err
+}
+
+func sessionOf(session types.Session) *Session {
+	return &Session{
+		AccessToken:  session.AccessToken,
+		RefreshToken: session.RefreshToken,
+		TokenType:    session.TokenType,
+		ExpiresIn:    session.ExpiresIn,
+		ExpiresAt:    session.ExpiresAt,
+		User:         User{ID: session.User.ID, Email: session.User.Email},
+	}
+}
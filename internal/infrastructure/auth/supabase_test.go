@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejectedAsMapsRejectionsOfSupabase(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want error
+	}{
+		{err: fmt.Errorf("response status code 400: {\"error\":\"invalid_grant\"}"), want: ErrInvalidRefreshToken},
+		{err: fmt.Errorf("response status code 401"), want: ErrInvalidRefreshToken},
+		{err: fmt.Errorf("response status code 500: {}")},
+		{err: errors.New("dial tcp: connection refused")},
+	} {
+		got := rejectedAs(test.err, ErrInvalidRefreshToken)
+		if test.want != nil {
+			assert.ErrorIs(t, got, test.want, test.err.Error())
+		} else {
+			assert.Equal(t, test.err, got, "failures of Supabase should be returned as is")
+		}
+	}
+}
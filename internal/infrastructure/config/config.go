@@ -5,6 +5,8 @@ type AppSettings struct {
 	LogLevel               string `mapstructure:"LOG_LEVEL"`
 	GeneralRateLimit       int    `mapstructure:"GENERAL_RATE_LIMIT"`
 	AuthEndpointsRateLimit int    `mapstructure:"AUTH_ENDPOINTS_RATE_LIMIT"`
+	ShutdownTimeoutSeconds int    `mapstructure:"SHUTDOWN_TIMEOUT_SECONDS"` //how long jobs in progress get to finish on shutdown, before being interrupted
+	MetricsPort            string `mapstructure:"METRICS_PORT"`             //if set, expvar metrics are served on this port, at /debug/vars
 }
 
 type SupaBase struct {
@@ -15,6 +17,36 @@ type SupaBase struct {
 	SupaBaseProjectReference string `mapstructure:"SUPABASE_PROJECT_REFERENCE"`
 }
 
+// Auth providers
+const (
+	AuthProviderSupabase = "supabase"
+	AuthProviderLocal    = "local" //users kept in the database, for deployments without Supabase
+)
+
+type AuthConfig struct {
+	AuthProvider          string `mapstructure:"AUTH_PROVIDER"`     //supabase if not set
+	AuthJwtSecret         string `mapstructure:"AUTH_JWT_SECRET"`   //signs the tokens of the local provider
+	AuthJwtIssuer         string `mapstructure:"AUTH_JWT_ISSUER"`   //iss tokens must have, log-flow for the local provider and Supabase Auth of SUPABASE_URL otherwise if not set
+	AuthJwtAudience       string `mapstructure:"AUTH_JWT_AUDIENCE"` //aud tokens must have, log-flow for the local provider and authenticated otherwise if not set
+	AuthRolesClaim        string `mapstructure:"AUTH_ROLES_CLAIM"`  //claim of tokens holding system roles of the user, a dotted path for nested ones. Roles are stored in the database only if not set
+	AccessTokenTTLMinutes int    `mapstructure:"AUTH_ACCESS_TOKEN_TTL_MINUTES"`
+	RefreshTokenTTLHours  int    `mapstructure:"AUTH_REFRESH_TOKEN_TTL_HOURS"`
+
+	// Verification of tokens of identity providers signing with asymmetric keys, instead of the HS256 secret
+	AuthJwtAlgorithm     string `mapstructure:"AUTH_JWT_ALGORITHM"`      //the only one accepted, RS256 with a public key if not set, HS256 otherwise
+	AuthJwtPublicKey     string `mapstructure:"AUTH_JWT_PUBLIC_KEY"`     //PEM encoded, or the path of a file holding it
+	AuthJwksURL          string `mapstructure:"AUTH_JWKS_URL"`           //to fetch rotating keys from, instead of a public key
+	AuthJwksCacheMinutes int    `mapstructure:"AUTH_JWKS_CACHE_MINUTES"` //keys are refetched this often, and for tokens of unknown keys
+
+	// Password reset emails of the local provider, required by it unless reset tokens are logged instead, for development.
+	PasswordResetURL string `mapstructure:"PASSWORD_RESET_URL"` //page the emails link to, with the token and email as query params
+	SMTPAddr         string `mapstructure:"SMTP_ADDR"`          //host:port
+	SMTPUser         string `mapstructure:"SMTP_USER"`
+	SMTPPassword     string `mapstructure:"SMTP_PASSWORD"`
+	SMTPFrom         string `mapstructure:"SMTP_FROM"`
+	LogResetTokens   bool   `mapstructure:"AUTH_LOG_RESET_TOKENS"` //development only: reset tokens are logged in plain text instead of emailed
+}
+
 type Postgres struct {
 	Host     string `mapstructure:"DB_HOST"`
 	Port     string `mapstructure:"DB_PORT"`
@@ -32,5 +64,39 @@ type RabbitMQConfig struct {
 }
 
 type LogConfig struct {
-	Keywords []string `mapstructure:"KEYWORDS"`
+	Keywords                  []string `mapstructure:"KEYWORDS"`
+	ChunkSizeMB               int      `mapstructure:"LOG_CHUNK_SIZE_MB"`           //files larger than this are processed as parallel chunks of this size
+	ChunkConcurrency          int      `mapstructure:"LOG_CHUNK_CONCURRENCY"`       //max chunks of a file processed in parallel
+	CheckpointIntervalSeconds int      `mapstructure:"CHECKPOINT_INTERVAL_SECONDS"` //how often the progress of a job is persisted, to resume from on retry
+}
+
+type WorkerConfig struct {
+	Concurrency            int  `mapstructure:"WORKER_CONCURRENCY"`       //jobs processed at once per process running workers
+	DisableEmbeddedWorkers bool `mapstructure:"DISABLE_EMBEDDED_WORKERS"` //the API runs no workers, leaving jobs to cmd/worker
+
+	// Autoscaling on the queue depth, between min and max workers, if max is set
+	MinConcurrency           int `mapstructure:"WORKER_MIN_CONCURRENCY"`
+	MaxConcurrency           int `mapstructure:"WORKER_MAX_CONCURRENCY"`
+	ScaleUpCooldownSeconds   int `mapstructure:"WORKER_SCALE_UP_COOLDOWN_SECONDS"`   //min time between scaling decisions, for scaling up
+	ScaleDownCooldownSeconds int `mapstructure:"WORKER_SCALE_DOWN_COOLDOWN_SECONDS"` //same, for scaling down
+	TargetQueueWaitSeconds   int `mapstructure:"WORKER_TARGET_QUEUE_WAIT_SECONDS"`   //workers are added for queued jobs to start within this
+
+	// Fair scheduling of the jobs of users
+	SchedulingQuantumMB int `mapstructure:"FAIR_SCHEDULING_QUANTUM_MB"` //bytes of jobs a user is credited per round robin round
+	SchedulingBuffer    int `mapstructure:"FAIR_SCHEDULING_BUFFER"`     //jobs received ahead per process, to schedule fairly among
+	MaxJobsPerUser      int `mapstructure:"MAX_JOBS_PER_USER"`          //jobs of a user processed at once, across processes. 0 for no limit
+}
+
+type WebSocketConfig struct {
+	PingIntervalSeconds int `mapstructure:"WS_PING_INTERVAL_SECONDS"` //clients not answering pings within twice this are disconnected
+	WriteTimeoutSeconds int `mapstructure:"WS_WRITE_TIMEOUT_SECONDS"` //clients not reading a message within this are disconnected
+	SendBufferSize      int `mapstructure:"WS_SEND_BUFFER_SIZE"`      //messages queued per socket, beyond which older progress is dropped
+}
+
+// Default quotas of workspaces: the personal one of each user, and each organization. 0 for no limit.
+// They can be overridden per workspace by admins.
+type QuotaConfig struct {
+	JobsPerDay     int   `mapstructure:"QUOTA_JOBS_PER_DAY"`    //jobs uploaded per UTC day
+	StorageMB      int64 `mapstructure:"QUOTA_STORAGE_MB"`      //total size of the files of the jobs uploaded
+	ConcurrentJobs int   `mapstructure:"QUOTA_CONCURRENT_JOBS"` //jobs queued or processing at once
 }
@@ -14,11 +14,15 @@ func init() {
 }
 
 var Env struct {
-	AppSettings    `mapstructure:",squash"`
-	SupaBase       `mapstructure:",squash"`
-	Postgres       `mapstructure:",squash"`
-	RabbitMQConfig `mapstructure:",squash"`
-	LogConfig      `mapstructure:",squash"`
+	AppSettings     `mapstructure:",squash"`
+	SupaBase        `mapstructure:",squash"`
+	AuthConfig      `mapstructure:",squash"`
+	Postgres        `mapstructure:",squash"`
+	RabbitMQConfig  `mapstructure:",squash"`
+	LogConfig       `mapstructure:",squash"`
+	WorkerConfig    `mapstructure:",squash"`
+	WebSocketConfig `mapstructure:",squash"`
+	QuotaConfig     `mapstructure:",squash"`
 }
 
 var Dev struct {
@@ -42,6 +46,8 @@ func loadEnv() error {
 		viper.BindEnv("LOG_LEVEL")
 		viper.BindEnv("GENERAL_RATE_LIMIT")
 		viper.BindEnv("AUTH_ENDPOINTS_RATE_LIMIT")
+		viper.BindEnv("SHUTDOWN_TIMEOUT_SECONDS")
+		viper.BindEnv("METRICS_PORT")
 
 		viper.BindEnv("SUPABASE_URL")
 		viper.BindEnv("SUPABASE_KEY")
@@ -49,6 +55,24 @@ func loadEnv() error {
 		viper.BindEnv("SUPABASE_JWT_SECRET_KEY")
 		viper.BindEnv("SUPABASE_PROJECT_REFERENCE")
 
+		viper.BindEnv("AUTH_PROVIDER")
+		viper.BindEnv("AUTH_JWT_SECRET")
+		viper.BindEnv("AUTH_JWT_ISSUER")
+		viper.BindEnv("AUTH_JWT_AUDIENCE")
+		viper.BindEnv("AUTH_ROLES_CLAIM")
+		viper.BindEnv("AUTH_ACCESS_TOKEN_TTL_MINUTES")
+		viper.BindEnv("AUTH_REFRESH_TOKEN_TTL_HOURS")
+		viper.BindEnv("AUTH_JWT_ALGORITHM")
+		viper.BindEnv("AUTH_JWT_PUBLIC_KEY")
+		viper.BindEnv("AUTH_JWKS_URL")
+		viper.BindEnv("AUTH_JWKS_CACHE_MINUTES")
+		viper.BindEnv("PASSWORD_RESET_URL")
+		viper.BindEnv("SMTP_ADDR")
+		viper.BindEnv("SMTP_USER")
+		viper.BindEnv("SMTP_PASSWORD")
+		viper.BindEnv("SMTP_FROM")
+		viper.BindEnv("AUTH_LOG_RESET_TOKENS")
+
 		viper.BindEnv("DB_HOST")
 		viper.BindEnv("DB_PORT")
 		viper.BindEnv("DB_USER")
@@ -62,6 +86,28 @@ func loadEnv() error {
 		viper.BindEnv("RABBITMQ_PASSWORD")
 
 		viper.BindEnv("KEYWORDS")
+		viper.BindEnv("LOG_CHUNK_SIZE_MB")
+		viper.BindEnv("LOG_CHUNK_CONCURRENCY")
+		viper.BindEnv("CHECKPOINT_INTERVAL_SECONDS")
+
+		viper.BindEnv("WORKER_CONCURRENCY")
+		viper.BindEnv("DISABLE_EMBEDDED_WORKERS")
+		viper.BindEnv("WORKER_MIN_CONCURRENCY")
+		viper.BindEnv("WORKER_MAX_CONCURRENCY")
+		viper.BindEnv("WORKER_SCALE_UP_COOLDOWN_SECONDS")
+		viper.BindEnv("WORKER_SCALE_DOWN_COOLDOWN_SECONDS")
+		viper.BindEnv("WORKER_TARGET_QUEUE_WAIT_SECONDS")
+		viper.BindEnv("FAIR_SCHEDULING_QUANTUM_MB")
+		viper.BindEnv("FAIR_SCHEDULING_BUFFER")
+		viper.BindEnv("MAX_JOBS_PER_USER")
+
+		viper.BindEnv("WS_PING_INTERVAL_SECONDS")
+		viper.BindEnv("WS_WRITE_TIMEOUT_SECONDS")
+		viper.BindEnv("WS_SEND_BUFFER_SIZE")
+
+		viper.BindEnv("QUOTA_JOBS_PER_DAY")
+		viper.BindEnv("QUOTA_STORAGE_MB")
+		viper.BindEnv("QUOTA_CONCURRENT_JOBS")
 
 		viper.BindEnv("DEV_SIMULATE_LOG_PROCESSING_LAG_MS")
 
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Serve serves the expvar metrics of the process at /debug/vars, on a port of its own
+// so that they aren't exposed with the API. Nothing is served if port is not set.
+func Serve(port string) {
+	if port == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%s", port), mux); err != nil {
+			log.Errorf("Couldn't serve metrics: %v", err)
+		}
+	}()
+}
@@ -18,17 +18,26 @@ type (
 	LogQueueSender interface {
 		SendToQueue(logMsg LogMessage) error
 		GetQueueStatus() (map[string]any, error)
+
+		// ReplayFailed requeues up to limit messages of the failed queue for processing, with their retries reset, and
+		// returns how many were. afterReplay is called with each message once requeued, and stops the replay if it fails.
+		ReplayFailed(limit int, afterReplay func(LogMessage) error) (int, error)
 	}
 
+	// LogQueueReceiver delivers messages to be acked (or nacked) once handled,
+	// so that messages of a consumer stopping midway are redelivered.
 	LogQueueReceiver interface {
-		RecieveLogFileDetails() (<-chan amqp.Delivery, error)
+		RecieveLogFileDetails(consumerTag string, prefetch int) (<-chan amqp.Delivery, error) //at most prefetch messages unacked at once
+		StopReceiving(consumerTag string) error                                               //the deliveries channel is closed once the messages already received are delivered
 		SentForRetry(msg amqp.Delivery)
 		SendToFailedQueue(msg amqp.Delivery)
+		QueueDepth() (messages int, consumers int, err error) //jobs waiting, and consumers of all processes
 	}
 
 	LogQueue interface {
 		LogQueueReceiver
 		LogQueueSender
+		Close() error
 	}
 
 	rabbitMqLogFileQueue struct {
@@ -38,8 +47,15 @@ type (
 
 	LogMessage struct {
 		JobID    string `json:"job_id"`
+		UserID   string `json:"user_id"`
 		FileURL  string `json:"file_url"`
+		FileSize int64  `json:"file_size"` //0 for messages queued before it was set
 		Priority uint8  `json:"priority"`
+		OrgID    string `json:"org_id,omitempty"`
+
+		// Keywords to track, from the keyword profile of the organization the job was uploaded into.
+		// The default keywords are tracked if not set.
+		Keywords []string `json:"keywords,omitempty"`
 	}
 )
 
@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	subscriptionBufferSize = 16
+
+	// How long the final status of a job is kept for late subscribers,
+	// covering the moment between it being sent and the job's report being saved.
+	finalStatusRetention = time.Minute
+
+	// How long the latest status of a job in progress is kept without a newer one. Jobs of crashed workers,
+	// or requeued to another one, never get a final status here. Progress is sent every second while watched.
+	staleStatusRetention = 10 * time.Minute
+)
+
+// LiveStatusMessage is a status update of a job. Each one is a full snapshot, not a delta.
+type LiveStatusMessage struct {
+	JobID  string
+	UserID string //owner of the job
+	Seq    int64  //increasing across the status updates of a job
+	Final  bool   //the last status of the job
+	Body   []byte
+}
+
+// LiveStatusHub fans out the live status of jobs to any number of subscribers within the process.
+// It keeps the latest status of each job, sent to subscribers as soon as they subscribe.
+type LiveStatusHub struct {
+	mutex           sync.Mutex
+	subscribers     map[string]map[*LiveStatusSubscription]struct{} //by job ID
+	userSubscribers map[string]map[*LiveStatusSubscription]struct{} //by user ID
+	latest          map[string]LiveStatusMessage
+	receivedAt      map[string]time.Time //of the latest status of each job
+	lastSweep       time.Time            //of stale statuses, see sweepStale
+}
+
+func NewLiveStatusHub() *LiveStatusHub {
+	return &LiveStatusHub{
+		subscribers:     make(map[string]map[*LiveStatusSubscription]struct{}),
+		userSubscribers: make(map[string]map[*LiveStatusSubscription]struct{}),
+		latest:          make(map[string]LiveStatusMessage),
+		receivedAt:      make(map[string]time.Time),
+	}
+}
+
+// LiveStatusSubscription receives the status updates of a job, until its final status or Close,
+// or of all the jobs of a user, until Close.
+type LiveStatusSubscription struct {
+	hub      *LiveStatusHub
+	jobID    string
+	userID   string //set for subscriptions to all the jobs of a user
+	messages chan LiveStatusMessage
+	closed   bool //guarded by hub.mutex
+}
+
+// Messages is closed after the final status of the job, or on Close.
+func (s *LiveStatusSubscription) Messages() <-chan LiveStatusMessage {
+	return s.messages
+}
+
+func (s *LiveStatusSubscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	s.hub.unsubscribe(s)
+}
+
+func (h *LiveStatusHub) Subscribe(jobID string) *LiveStatusSubscription {
+	sub := &LiveStatusSubscription{
+		hub:      h,
+		jobID:    jobID,
+		messages: make(chan LiveStatusMessage, subscriptionBufferSize),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if latest, ok := h.latest[jobID]; ok {
+		sub.messages <- latest
+		if latest.Final {
+			sub.closed = true
+			close(sub.messages)
+			return sub
+		}
+	}
+
+	if h.subscribers[jobID] == nil {
+		h.subscribers[jobID] = make(map[*LiveStatusSubscription]struct{})
+	}
+	h.subscribers[jobID][sub] = struct{}{}
+	return sub
+}
+
+// SubscribeUser subscribes to the status updates of all the jobs of a user,
+// starting with the latest status of each of their jobs in progress.
+func (h *LiveStatusHub) SubscribeUser(userID string) *LiveStatusSubscription {
+	sub := &LiveStatusSubscription{
+		hub:      h,
+		userID:   userID,
+		messages: make(chan LiveStatusMessage, subscriptionBufferSize),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, latest := range h.latest {
+		if latest.UserID == userID && !latest.Final {
+			sub.deliver(latest)
+		}
+	}
+
+	if h.userSubscribers[userID] == nil {
+		h.userSubscribers[userID] = make(map[*LiveStatusSubscription]struct{})
+	}
+	h.userSubscribers[userID][sub] = struct{}{}
+	return sub
+}
+
+// Latest returns the latest status of a job, kept for a while after its final one.
+func (h *LiveStatusHub) Latest(jobID string) (LiveStatusMessage, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	latest, ok := h.latest[jobID]
+	return latest, ok
+}
+
+// Publish sends msg to the subscribers of its job. It never blocks: a subscriber that fell behind
+// skips older statuses, as the latest one supersedes them, but always gets the final one.
+func (h *LiveStatusHub) Publish(msg LiveStatusMessage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	h.sweepStale(now)
+	h.latest[msg.JobID] = msg
+	h.receivedAt[msg.JobID] = now
+	if msg.Final {
+		time.AfterFunc(finalStatusRetention, func() {
+			h.mutex.Lock()
+			defer h.mutex.Unlock()
+			if h.latest[msg.JobID].Final {
+				delete(h.latest, msg.JobID)
+				delete(h.receivedAt, msg.JobID)
+			}
+		})
+	}
+
+	for sub := range h.subscribers[msg.JobID] {
+		sub.deliver(msg)
+		if msg.Final {
+			h.unsubscribe(sub)
+		}
+	}
+	if msg.UserID != "" {
+		for sub := range h.userSubscribers[msg.UserID] {
+			sub.deliver(msg)
+		}
+	}
+}
+
+// sweepStale forgets the statuses of jobs in progress not updated for staleStatusRetention, at most once per minute.
+// To be called with the mutex held.
+func (h *LiveStatusHub) sweepStale(now time.Time) {
+	if now.Sub(h.lastSweep) < time.Minute {
+		return
+	}
+	h.lastSweep = now
+	for jobID, receivedAt := range h.receivedAt {
+		if !h.latest[jobID].Final && now.Sub(receivedAt) > staleStatusRetention {
+			delete(h.latest, jobID)
+			delete(h.receivedAt, jobID)
+		}
+	}
+}
+
+// deliver drops the oldest buffered statuses while the buffer is full. To be called with the hub's mutex held.
+func (s *LiveStatusSubscription) deliver(msg LiveStatusMessage) {
+	for {
+		select {
+		case s.messages <- msg:
+			return
+		default:
+		}
+
+		select {
+		case <-s.messages:
+		default:
+		}
+	}
+}
+
+// To be called with the mutex held
+func (h *LiveStatusHub) unsubscribe(sub *LiveStatusSubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.messages)
+
+	subscribers, key := h.subscribers, sub.jobID
+	if sub.userID != "" {
+		subscribers, key = h.userSubscribers, sub.userID
+	}
+	delete(subscribers[key], sub)
+	if len(subscribers[key]) == 0 {
+		delete(subscribers, key)
+	}
+}
@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveStatusHubFansOutToEverySubscriber(t *testing.T) {
+	hub := NewLiveStatusHub()
+	first, second := hub.Subscribe("job"), hub.Subscribe("job")
+	other := hub.Subscribe("other-job")
+	defer other.Close()
+
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 1, Body: []byte("progress")})
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 2, Final: true, Body: []byte("completed")})
+
+	for _, sub := range []*LiveStatusSubscription{first, second} {
+		var received []int64
+		for msg := range sub.Messages() { //closed after the final status
+			received = append(received, msg.Seq)
+		}
+		assert.Equal(t, []int64{1, 2}, received)
+	}
+	assert.Empty(t, other.Messages(), "other jobs' subscribers should get nothing")
+}
+
+func TestLiveStatusHubSendsLatestStatusToLateSubscribers(t *testing.T) {
+	hub := NewLiveStatusHub()
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 1})
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 2})
+
+	late := hub.Subscribe("job")
+	defer late.Close()
+	assert.Equal(t, int64(2), (<-late.Messages()).Seq)
+
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 3, Final: true})
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 4}) //should not reach a closed subscription
+	assert.Equal(t, int64(3), (<-late.Messages()).Seq)
+	_, open := <-late.Messages()
+	assert.False(t, open)
+
+	hub.Publish(LiveStatusMessage{JobID: "done", Seq: 1, Final: true})
+	afterFinal := hub.Subscribe("done")
+	assert.Equal(t, int64(1), (<-afterFinal.Messages()).Seq, "the final status should be kept for a while")
+	_, open = <-afterFinal.Messages()
+	assert.False(t, open)
+}
+
+func TestLiveStatusHubDoesNotBlockOnSlowSubscribers(t *testing.T) {
+	hub := NewLiveStatusHub()
+	slow := hub.Subscribe("job")
+
+	for i := 1; i <= 10*subscriptionBufferSize; i++ {
+		hub.Publish(LiveStatusMessage{JobID: "job", Seq: int64(i), Body: []byte(fmt.Sprint(i))})
+	}
+	hub.Publish(LiveStatusMessage{JobID: "job", Seq: 1000, Final: true})
+
+	var last LiveStatusMessage
+	count := 0
+	for msg := range slow.Messages() {
+		last = msg
+		count++
+	}
+	assert.LessOrEqual(t, count, subscriptionBufferSize)
+	assert.True(t, last.Final, "the final status should never be dropped")
+}
+
+func TestLiveStatusHubUserSubscriptions(t *testing.T) {
+	hub := NewLiveStatusHub()
+	hub.Publish(LiveStatusMessage{JobID: "running", UserID: "user", Seq: 1})
+	hub.Publish(LiveStatusMessage{JobID: "done", UserID: "user", Seq: 1, Final: true})
+	hub.Publish(LiveStatusMessage{JobID: "someone else's", UserID: "other", Seq: 1})
+
+	sub := hub.SubscribeUser("user")
+	assert.Equal(t, "running", (<-sub.Messages()).JobID, "should start with the latest status of the user's running jobs")
+
+	hub.Publish(LiveStatusMessage{JobID: "running", UserID: "user", Seq: 2, Final: true})
+	hub.Publish(LiveStatusMessage{JobID: "new", UserID: "user", Seq: 1})
+	hub.Publish(LiveStatusMessage{JobID: "someone else's", UserID: "other", Seq: 2})
+	assert.Equal(t, "running", (<-sub.Messages()).JobID)
+	assert.Equal(t, "new", (<-sub.Messages()).JobID, "a job's final status should not end the subscription")
+	assert.Empty(t, sub.Messages())
+
+	sub.Close()
+	_, open := <-sub.Messages()
+	assert.False(t, open)
+	assert.Empty(t, hub.userSubscribers)
+}
+
+func TestLiveStatusHubForgetsStaleStatuses(t *testing.T) { //of jobs which never get a final status
+	hub := NewLiveStatusHub()
+	hub.Publish(LiveStatusMessage{JobID: "crashed", UserID: "user", Seq: 1})
+	hub.Publish(LiveStatusMessage{JobID: "running", UserID: "user", Seq: 1})
+
+	hub.receivedAt["crashed"] = time.Now().Add(-staleStatusRetention - time.Second)
+	hub.lastSweep = time.Time{}
+	hub.Publish(LiveStatusMessage{JobID: "running", UserID: "user", Seq: 2})
+
+	_, ok := hub.Latest("crashed")
+	assert.False(t, ok, "the status of a job not updated for long should be forgotten")
+	latest, ok := hub.Latest("running")
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), latest.Seq)
+
+	sub := hub.SubscribeUser("user")
+	defer sub.Close()
+	assert.Equal(t, "running", (<-sub.Messages()).JobID)
+	assert.Empty(t, sub.Messages(), "forgotten jobs shouldn't be sent to user feeds")
+}
@@ -20,8 +20,13 @@ const (
 	failedQueue       = "failed_queue"
 	failedRoutingKey  = "failed_routing_key"
 	failedQueueTTL    = 259200000 // 3 days (in milliseconds)
+
+	maxRetries = 3
 )
 
+// MaxAttempts is the number of times a message is processed, retries included, before it's sent to the failed queue
+const MaxAttempts = maxRetries + 1
+
 func NewRabbitMQLogQueue(rabbitConfig RabbitMQConfig) (*rabbitMqLogFileQueue, error) {
 
 	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%s/",
@@ -127,8 +132,21 @@ func (rq *rabbitMqLogFileQueue) SendToQueue(logMsg LogMessage) error {
 	return nil
 }
 
-func (rq *rabbitMqLogFileQueue) RecieveLogFileDetails() (<-chan amqp.Delivery, error) {
-	return rq.ch.Consume(logProcessingQueue, "", true, false, false, false, nil)
+func (rq *rabbitMqLogFileQueue) RecieveLogFileDetails(consumerTag string, prefetch int) (<-chan amqp.Delivery, error) {
+	// Bounds the messages held by the consumer, leaving the rest to other processes
+	if err := rq.ch.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("failed to set QoS: %v", err)
+	}
+	return rq.ch.Consume(logProcessingQueue, consumerTag, false, false, false, false, nil)
+}
+
+func (rq *rabbitMqLogFileQueue) StopReceiving(consumerTag string) error {
+	return rq.ch.Cancel(consumerTag, false)
+}
+
+// Close closes the connection. Unacked messages are requeued by RabbitMQ.
+func (rq *rabbitMqLogFileQueue) Close() error {
+	return rq.conn.Close()
 }
 
 func (rq *rabbitMqLogFileQueue) GetQueueStatus() (map[string]any, error) {
@@ -137,26 +155,70 @@ func (rq *rabbitMqLogFileQueue) GetQueueStatus() (map[string]any, error) {
 		return nil, fmt.Errorf("failed to inspect queue: %v", err)
 	}
 
+	failedInfo, err := rq.ch.QueueInspect(failedQueue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %v", failedQueue, err)
+	}
+
 	return map[string]any{
-		"name":           queueInfo.Name,      //queue name
-		"message_count":  queueInfo.Messages,  //no. of msgs
-		"consumer_count": queueInfo.Consumers, //no. of consumers
+		"name":                 queueInfo.Name,      //queue name
+		"message_count":        queueInfo.Messages,  //no. of msgs
+		"consumer_count":       queueInfo.Consumers, //no. of consumers
+		"failed_message_count": failedInfo.Messages, //jobs out of retries, to be replayed or to expire
 	}, nil
 }
 
-func (rq *rabbitMqLogFileQueue) SentForRetry(msg amqp.Delivery) {
-	log.Debug("🔄 Sending message to DLX for retry")
-	retryCount := 0
+func (rq *rabbitMqLogFileQueue) ReplayFailed(limit int, afterReplay func(LogMessage) error) (int, error) {
+	replayed := 0
+	for replayed < limit {
+		msg, ok, err := rq.ch.Get(failedQueue, false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to get message of %s: %v", failedQueue, err)
+		}
+		if !ok {
+			break
+		}
 
-	if msg.Headers != nil {
-		if val, ok := msg.Headers["x-retry-count"].(int32); ok {
-			retryCount = int(val)
+		var logMsg LogMessage
+		if err := json.Unmarshal(msg.Body, &logMsg); err != nil {
+			log.Errorf("Dropping unreadable message of %s: %v", failedQueue, err)
+			msg.Nack(false, false)
+			continue
+		}
+		// Published anew, without the retry count of the failed attempts
+		if err := rq.SendToQueue(logMsg); err != nil {
+			msg.Nack(false, true)
+			return replayed, err
+		}
+		if err := msg.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to ack message of %s: %v", failedQueue, err)
+		}
+		replayed++
+		if err := afterReplay(logMsg); err != nil {
+			return replayed, err
 		}
-	} else {
+	}
+
+	log.Debug("🔁 Replayed ", replayed, " messages of ", failedQueue)
+	return replayed, nil
+}
+
+func (rq *rabbitMqLogFileQueue) QueueDepth() (messages int, consumers int, err error) {
+	queueInfo, err := rq.ch.QueueInspect(logProcessingQueue)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to inspect queue: %v", err)
+	}
+	return queueInfo.Messages, queueInfo.Consumers, nil
+}
+
+func (rq *rabbitMqLogFileQueue) SentForRetry(msg amqp.Delivery) {
+	log.Debug("🔄 Sending message to DLX for retry")
+	retryCount := getRetryCount(msg)
+	if msg.Headers == nil {
 		msg.Headers = amqp.Table{}
 	}
 
-	if retryCount >= 3 {
+	if retryCount >= maxRetries {
 		log.Debug("❌ Retry count exceeded, sending message to ", failedQueue)
 		rq.SendToFailedQueue(msg)
 		return
@@ -203,3 +265,15 @@ func (rq *rabbitMqLogFileQueue) SendToFailedQueue(msg amqp.Delivery) {
 
 	log.Trace("📌 Message moved to ", failedQueue, " for manual inspection")
 }
+
+// IsLastAttempt tells whether a failure processing msg would send it to the failed queue rather than for retry.
+func IsLastAttempt(msg amqp.Delivery) bool {
+	return getRetryCount(msg) >= maxRetries
+}
+
+func getRetryCount(msg amqp.Delivery) int {
+	if val, ok := msg.Headers["x-retry-count"].(int32); ok {
+		return int(val)
+	}
+	return 0
+}
@@ -1,25 +1,61 @@
 package queue
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/streadway/amqp"
 )
 
-var (
-	ErrCtxCancelled = fmt.Errorf("Context cancelled")
+const (
+	liveStatusExchange = "live_status_exchange"
+
+	seqHeader    = "x-seq"
+	finalHeader  = "x-final"
+	userIDHeader = "x-user-id"
 )
 
 type (
+	// LiveStatusQueue carries the live status of jobs from the workers processing them
+	// to every process with subscribers for them.
 	LiveStatusQueue interface {
-		StartQueue(jobID string) (*LiveStatusQueueSession, error)
-		WaitAndRecieveProgressMsgsQueue(ctx context.Context, jobID string) (<-chan amqp.Delivery, error)
+		StartQueue(jobID, userID string) (*LiveStatusQueueSession, error)
+
+		// StartSubscriptions starts receiving the live status of all jobs, for processes serving subscribers.
+		// Statuses published before are missed, so it's to be called before the first subscription.
+		StartSubscriptions() error
+		Subscribe(jobID string) (*LiveStatusSubscription, error)
+		SubscribeUser(userID string) (*LiveStatusSubscription, error)
+
+		// Progress is only published for watched jobs. Subscribers watch jobs (or users) from any process,
+		// and workers check whether they are watched by any process.
+		WatchJob(jobID string) (unwatch func())
+		WatchUser(userID string) (unwatch func())
+		IsWatched(jobID, userID string) bool
+
+		Close() error
 	}
 
 	RabbitMqLiveStatusQueue struct {
-		Ch *amqp.Channel
+		conn      *amqp.Connection
+		Ch        *amqp.Channel
+		closed    chan struct{}
+		closeOnce sync.Once
+
+		hubOnce sync.Once
+		hub     *LiveStatusHub
+		hubErr  error
+
+		localWatches   *localWatches
+		announceOnce   sync.Once
+		announceNow    chan struct{}
+		remoteOnce     sync.Once
+		remoteWatches  *remoteWatches
+		remoteWatchErr error
 	}
 )
 
@@ -35,49 +71,248 @@ func NewRabbitMqLiveStatusQueue(rabbitConfig RabbitMQConfig) (LiveStatusQueue, e
 		return nil, fmt.Errorf("RabbitMQ Channel Error: %v", err)
 	}
 
-	return &RabbitMqLiveStatusQueue{Ch: ch}, nil
+	// Messages are routed by job ID, to the queue of every process having subscribers
+	err = ch.ExchangeDeclare(liveStatusExchange, "topic", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Exchange Declare Error: %v", err)
+	}
+
+	// Watch announcements go to every process checking for watched jobs
+	err = ch.ExchangeDeclare(liveWatchExchange, "fanout", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Exchange Declare Error: %v", err)
+	}
+
+	return &RabbitMqLiveStatusQueue{
+		conn:         conn,
+		Ch:           ch,
+		closed:       make(chan struct{}),
+		localWatches: newLocalWatches(),
+		announceNow:  make(chan struct{}, 1),
+	}, nil
+}
+
+func (rpm *RabbitMqLiveStatusQueue) StartQueue(jobID, userID string) (*LiveStatusQueueSession, error) {
+	session := &LiveStatusQueueSession{
+		ch:     rpm.Ch,
+		jobID:  jobID,
+		userID: userID,
+	}
+	// Starting from the time keeps seqs increasing across the attempts of a job
+	session.seq.Store(time.Now().UnixMicro())
+	return session, nil
+}
+
+// StartSubscriptions starts the consumer of the live status of all jobs. It's left to the processes
+// having subscribers (the API), so that processes which only publish (the workers) don't receive every job's status.
+// Starting it with the first subscription instead would miss the statuses published just before it, such as
+// the final status of a job completing between the check of its report and the subscription.
+func (rpm *RabbitMqLiveStatusQueue) StartSubscriptions() error {
+	_, err := rpm.getHub()
+	return err
+}
+
+// Subscribe subscribes to the live status of a job, starting the consumer if StartSubscriptions wasn't called.
+func (rpm *RabbitMqLiveStatusQueue) Subscribe(jobID string) (*LiveStatusSubscription, error) {
+	hub, err := rpm.getHub()
+	if err != nil {
+		return nil, err
+	}
+	return hub.Subscribe(jobID), nil
 }
 
-func (rpm *RabbitMqLiveStatusQueue) StartQueue(jobID string) (*LiveStatusQueueSession, error) {
-	queueName := fmt.Sprintf("result_queue_%s", jobID)
-	// log.Debug("Creating queue:", queueName)
-	_, err := rpm.Ch.QueueDeclare(queueName, false, false, false, false, nil)
+// SubscribeUser subscribes to the live status of all the jobs of a user.
+func (rpm *RabbitMqLiveStatusQueue) SubscribeUser(userID string) (*LiveStatusSubscription, error) {
+	hub, err := rpm.getHub()
+	if err != nil {
+		return nil, err
+	}
+	return hub.SubscribeUser(userID), nil
+}
+
+// WatchJob marks a job as watched, for every process, until the returned function is called.
+func (rpm *RabbitMqLiveStatusQueue) WatchJob(jobID string) (unwatch func()) {
+	unwatch, added := rpm.localWatches.watch(rpm.localWatches.jobs, jobID)
+	rpm.announce(added)
+	return unwatch
+}
+
+// WatchUser marks all the jobs of a user as watched, for every process, until the returned function is called.
+func (rpm *RabbitMqLiveStatusQueue) WatchUser(userID string) (unwatch func()) {
+	unwatch, added := rpm.localWatches.watch(rpm.localWatches.users, userID)
+	rpm.announce(added)
+	return unwatch
+}
+
+// IsWatched reports whether the job, or its user, is watched by any process. The announcements of other
+// processes are consumed from the first call, so a job may be reported unwatched during the first seconds.
+func (rpm *RabbitMqLiveStatusQueue) IsWatched(jobID, userID string) bool {
+	if rpm.localWatches.isWatched(jobID, userID) {
+		return true
+	}
+
+	rpm.remoteOnce.Do(func() {
+		rpm.remoteWatches, rpm.remoteWatchErr = rpm.consumeWatchAnnouncements()
+		if rpm.remoteWatchErr != nil {
+			log.Errorf("Failed to consume watch announcements, publishing progress of all jobs: %v", rpm.remoteWatchErr)
+		}
+	})
+	if rpm.remoteWatchErr != nil {
+		return true
+	}
+	return rpm.remoteWatches.isWatched(jobID, userID, time.Now())
+}
+
+// Close closes the connection. It can be called more than once, by the several shutdown paths; later calls do nothing.
+func (rpm *RabbitMqLiveStatusQueue) Close() error {
+	var err error
+	rpm.closeOnce.Do(func() {
+		close(rpm.closed)
+		err = rpm.conn.Close()
+	})
+	return err
+}
+
+func (rpm *RabbitMqLiveStatusQueue) getHub() (*LiveStatusHub, error) {
+	rpm.hubOnce.Do(func() {
+		rpm.hub, rpm.hubErr = rpm.startHub()
+	})
+	return rpm.hub, rpm.hubErr
+}
+
+func (rpm *RabbitMqLiveStatusQueue) startHub() (*LiveStatusHub, error) {
+	ch, err := rpm.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Channel Error: %v", err)
+	}
+
+	// Exclusive to this process, and deleted with its connection
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
 	if err != nil {
 		return nil, fmt.Errorf("RabbitMQ Queue Declare Error: %v", err)
 	}
 
-	return &LiveStatusQueueSession{
-		ch:        rpm.Ch,
-		queueName: queueName,
-	}, nil
+	err = ch.QueueBind(q.Name, "#", liveStatusExchange, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Queue Bind Error: %v", err)
+	}
+
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Consume Error: %v", err)
+	}
+
+	hub := NewLiveStatusHub()
+	go func() {
+		for msg := range msgs {
+			hub.Publish(liveStatusMessageFromDelivery(msg))
+		}
+	}()
+
+	return hub, nil
 }
 
-func (rpm *RabbitMqLiveStatusQueue) WaitAndRecieveProgressMsgsQueue(ctx context.Context, jobID string) (<-chan amqp.Delivery, error) {
+// announce starts announcing the watches of the process, and announces them right away if now is set.
+func (rpm *RabbitMqLiveStatusQueue) announce(now bool) {
+	rpm.announceOnce.Do(func() {
+		go rpm.announceWatches()
+	})
+	if now {
+		select {
+		case rpm.announceNow <- struct{}{}:
+		default: //already due
+		}
+	}
+}
 
-	queueName := fmt.Sprintf("result_queue_%s", jobID)
+func (rpm *RabbitMqLiveStatusQueue) announceWatches() {
+	ch, err := rpm.conn.Channel()
+	if err != nil {
+		log.Errorf("Failed to announce watched jobs. RabbitMQ Channel Error: %v", err)
+		return
+	}
+	defer ch.Close()
 
-	// Poll for queue existence with a timeoutr
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(watchAnnounceInterval)
 	defer ticker.Stop()
-
 	for {
 		select {
-		case <-ctx.Done(): // Context cancelled (e.g., WebSocket closed)
-			fmt.Println("Context cancelled: stopping queue wait for ", queueName)
+		case <-rpm.closed:
+			return
+		case <-ticker.C:
+		case <-rpm.announceNow:
+		}
+
+		announcement := rpm.localWatches.announcement()
+		if len(announcement.JobIDs) == 0 && len(announcement.UserIDs) == 0 {
+			continue
+		}
+		body, err := json.Marshal(announcement)
+		if err != nil {
+			log.Errorf("Error marshalling watch announcement: %v", err)
+			continue
+		}
+		err = ch.Publish(liveWatchExchange, "", false, false, amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+			Expiration:  strconv.Itoa(int(watchTTL.Milliseconds())), //stale once expired
+		})
+		if err != nil {
+			log.Errorf("RabbitMQ Publish Error: %v", err)
+		}
+	}
+}
 
-			return nil, ErrCtxCancelled
+func (rpm *RabbitMqLiveStatusQueue) consumeWatchAnnouncements() (*remoteWatches, error) {
+	ch, err := rpm.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Channel Error: %v", err)
+	}
 
-		case <-ticker.C: // Periodically check for queue existence
-			_, err := rpm.Ch.QueueInspect(queueName)
-			if err == nil {
-				// Queue exists, start consuming
-				msgs, err := rpm.Ch.Consume(queueName, "", true, false, false, false, nil)
-				if err != nil {
-					return nil, fmt.Errorf("RabbitMQ Consume Error: %v", err)
-				}
+	// Exclusive to this process, and deleted with its connection
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Queue Declare Error: %v", err)
+	}
 
-				return msgs, nil
+	err = ch.QueueBind(q.Name, "", liveWatchExchange, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Queue Bind Error: %v", err)
+	}
+
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RabbitMQ Consume Error: %v", err)
+	}
+
+	watches := newRemoteWatches()
+	go func() {
+		for msg := range msgs {
+			announcement, err := decodeWatchAnnouncement(msg.Body)
+			if err != nil {
+				log.Errorf("Error unmarshalling watch announcement: %v", err)
+				continue
 			}
+			watches.add(announcement, time.Now())
 		}
+	}()
+
+	return watches, nil
+}
+
+func liveStatusMessageFromDelivery(msg amqp.Delivery) LiveStatusMessage {
+	liveStatus := LiveStatusMessage{
+		JobID: msg.RoutingKey,
+		Body:  msg.Body,
+	}
+	if seq, ok := msg.Headers[seqHeader].(int64); ok {
+		liveStatus.Seq = seq
+	}
+	if final, ok := msg.Headers[finalHeader].(bool); ok {
+		liveStatus.Final = final
+	}
+	if userID, ok := msg.Headers[userIDHeader].(string); ok {
+		liveStatus.UserID = userID
 	}
+	return liveStatus
 }
@@ -1,37 +1,44 @@
 package queue
 
 import (
-	"fmt"
-	"time"
+	"sync/atomic"
 
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/streadway/amqp"
 )
 
 type (
+	// LiveStatusQueueSession publishes the live status of a job being processed.
 	LiveStatusQueueSession struct {
-		ch        *amqp.Channel
-		queueName string
+		ch     *amqp.Channel
+		jobID  string
+		userID string
+		seq    atomic.Int64
 	}
 )
 
 func (q *LiveStatusQueueSession) SendIntermediateResult(result string) {
-	err := q.ch.Publish("", q.queueName, false, false, amqp.Publishing{
-		ContentType: "text/plain",
-		Body:        []byte(result),
-	})
-	if err != nil {
-		fmt.Println("RabbitMQ Publish Error:", err)
-	}
-
+	q.publish(result, false)
 	log.Trace("✅ Sent intermediate result to RabbitMQ:", result)
 }
 
-func (q *LiveStatusQueueSession) Delete() {
-	time.Sleep(3 * time.Second) // Wait for 3 seconds before deleting the queue, so that the client can consume all messages//Temporary
-	_, err := q.ch.QueueDelete(q.queueName, false, false, false)
+// SendFinalResult sends the last status of the job, after which subscriptions to it end.
+func (q *LiveStatusQueueSession) SendFinalResult(result string) {
+	q.publish(result, true)
+	log.Trace("✅ Sent final result to RabbitMQ:", result)
+}
+
+func (q *LiveStatusQueueSession) publish(result string, final bool) {
+	err := q.ch.Publish(liveStatusExchange, q.jobID, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        []byte(result),
+		Headers: amqp.Table{
+			seqHeader:    q.seq.Add(1),
+			finalHeader:  final,
+			userIDHeader: q.userID,
+		},
+	})
 	if err != nil {
-		log.Error("❌ Failed to delete queue: %v", err)
-		return
+		log.Errorf("RabbitMQ Publish Error: %v", err)
 	}
 }
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	liveWatchExchange = "live_watch_exchange"
+
+	// Processes with subscribers announce the jobs and users watched this often,
+	// and workers consider them watched until a while after the last announcement.
+	watchAnnounceInterval = 5 * time.Second
+	watchTTL              = 3 * watchAnnounceInterval
+)
+
+// WatchAnnouncement lists the jobs and users watched by the subscribers of a process
+type WatchAnnouncement struct {
+	JobIDs  []string `json:"job_ids"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// localWatches counts the live status subscribers of jobs and of users within the process.
+type localWatches struct {
+	mutex sync.Mutex
+	jobs  map[string]int
+	users map[string]int
+}
+
+func newLocalWatches() *localWatches {
+	return &localWatches{
+		jobs:  make(map[string]int),
+		users: make(map[string]int),
+	}
+}
+
+// watch registers a subscriber of key until the returned func is called, and reports whether key wasn't watched yet.
+func (w *localWatches) watch(counts map[string]int, key string) (unwatch func(), added bool) {
+	w.mutex.Lock()
+	counts[key]++
+	added = counts[key] == 1
+	w.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			if counts[key]--; counts[key] <= 0 {
+				delete(counts, key)
+			}
+		})
+	}, added
+}
+
+func (w *localWatches) isWatched(jobID, userID string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.jobs[jobID] > 0 || w.users[userID] > 0
+}
+
+func (w *localWatches) announcement() WatchAnnouncement {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var announcement WatchAnnouncement
+	for jobID := range w.jobs {
+		announcement.JobIDs = append(announcement.JobIDs, jobID)
+	}
+	for userID := range w.users {
+		announcement.UserIDs = append(announcement.UserIDs, userID)
+	}
+	return announcement
+}
+
+// remoteWatches holds the jobs and users announced as watched by any process, until their announcements expire.
+type remoteWatches struct {
+	mutex sync.Mutex
+	jobs  map[string]time.Time //expiry, by job ID
+	users map[string]time.Time //expiry, by user ID
+}
+
+func newRemoteWatches() *remoteWatches {
+	return &remoteWatches{
+		jobs:  make(map[string]time.Time),
+		users: make(map[string]time.Time),
+	}
+}
+
+func (w *remoteWatches) add(announcement WatchAnnouncement, now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	expiry := now.Add(watchTTL)
+	for _, jobID := range announcement.JobIDs {
+		w.jobs[jobID] = expiry
+	}
+	for _, userID := range announcement.UserIDs {
+		w.users[userID] = expiry
+	}
+
+	// Pruned along the way, as announcements keep coming
+	for jobID, jobExpiry := range w.jobs {
+		if now.After(jobExpiry) {
+			delete(w.jobs, jobID)
+		}
+	}
+	for userID, userExpiry := range w.users {
+		if now.After(userExpiry) {
+			delete(w.users, userID)
+		}
+	}
+}
+
+func (w *remoteWatches) isWatched(jobID, userID string, now time.Time) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if expiry, ok := w.jobs[jobID]; ok && !now.After(expiry) {
+		return true
+	}
+	if expiry, ok := w.users[userID]; ok && !now.After(expiry) {
+		return true
+	}
+	return false
+}
+
+func decodeWatchAnnouncement(body []byte) (WatchAnnouncement, error) {
+	var announcement WatchAnnouncement
+	err := json.Unmarshal(body, &announcement)
+	return announcement, err
+}
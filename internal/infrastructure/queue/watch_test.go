@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalWatchesCountSubscribers(t *testing.T) {
+	watches := newLocalWatches()
+	unwatchFirst, added := watches.watch(watches.jobs, "job")
+	assert.True(t, added, "the first subscriber should have the job announced right away")
+	unwatchSecond, added := watches.watch(watches.jobs, "job")
+	assert.False(t, added)
+	unwatchUser, _ := watches.watch(watches.users, "user")
+
+	assert.Equal(t, WatchAnnouncement{JobIDs: []string{"job"}, UserIDs: []string{"user"}}, watches.announcement())
+
+	unwatchFirst()
+	unwatchFirst() //harmless
+	assert.True(t, watches.isWatched("job", ""), "the job is still watched by the second subscriber")
+	unwatchSecond()
+	assert.False(t, watches.isWatched("job", ""))
+	assert.True(t, watches.isWatched("other-job", "user"), "all the jobs of a watched user are watched")
+	unwatchUser()
+	assert.Equal(t, WatchAnnouncement{}, watches.announcement())
+}
+
+func TestRemoteWatchesExpire(t *testing.T) {
+	watches := newRemoteWatches()
+	now := time.Now()
+	watches.add(WatchAnnouncement{JobIDs: []string{"job"}, UserIDs: []string{"user"}}, now)
+
+	assert.True(t, watches.isWatched("job", "", now))
+	assert.True(t, watches.isWatched("other-job", "user", now))
+	assert.False(t, watches.isWatched("other-job", "other-user", now))
+
+	// Announced again, by another process
+	watches.add(WatchAnnouncement{JobIDs: []string{"job"}}, now.Add(watchAnnounceInterval))
+	later := now.Add(watchTTL + time.Second)
+	assert.True(t, watches.isWatched("job", "", later), "should be kept by the latest announcement")
+	assert.False(t, watches.isWatched("other-job", "user", later), "should expire without announcements")
+
+	watches.add(WatchAnnouncement{}, later)
+	assert.NotContains(t, watches.users, "user", "expired watches should be pruned")
+}
@@ -1,27 +1,44 @@
 package server
 
 import (
+	"context"
 	"log-flow/internal/api/handler"
+	"log-flow/internal/api/middleware"
 	"log-flow/internal/api/routes"
+	"log-flow/internal/infrastructure/auth"
 	"log-flow/internal/infrastructure/config"
 	"log-flow/internal/infrastructure/db"
 	"log-flow/internal/infrastructure/queue"
 	"log-flow/internal/infrastructure/storage"
+	jwttoken "log-flow/internal/utils/jwt"
+	"log-flow/internal/utils/shutdown"
 	"log-flow/internal/workers"
+	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/logger"
-	"github.com/supabase-community/gotrue-go"
+	"gorm.io/gorm"
 )
 
 const (
-	gigaByte     = 1024 * 1024 * 1024
-	bodyLimit    = 2 * gigaByte
-	numOfWorkers = 4
-	appName      = "Log-Flow"
+	gigaByte  = 1024 * 1024 * 1024
+	bodyLimit = 2 * gigaByte
+	appName   = "Log-Flow"
 )
 
-func InitializeServer() *fiber.App {
+type Server struct {
+	app              *fiber.App
+	drain            *shutdown.Drain
+	workers          *workers.Worker //nil if workers run in separate processes
+	websocketManager *handler.WebSocketManager
+	logFileQueue     queue.LogQueue
+	liveStatusQueue  queue.LiveStatusQueue
+	database         *gorm.DB
+}
+
+func InitializeServer() *Server {
 
 	app := fiber.New(fiber.Config{
 		AppName:       appName,
@@ -35,18 +52,117 @@ func InitializeServer() *fiber.App {
 	fileStore := storage.NewSupabaseStorage(config.Env.SupaBaseURL, config.Env.SupaBaseKey, config.Env.SupaBaseBucket)
 	logFileQueue := queue.InitLogQueue()
 	liveProgressMessenger := queue.InitLiveStatusQueue()
-	supabaseAuth := gotrue.New(config.Env.SupaBaseProjectReference, config.Env.SupaBaseKey)
+	if err := liveProgressMessenger.StartSubscriptions(); err != nil {
+		log.Fatalf("Failed to subscribe to live status of jobs: %v", err)
+	}
+	verifier, err := jwttoken.NewVerifierFromConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwttoken.UseVerifier(verifier)
+	authProvider := newAuthProvider(database)
+	drain := shutdown.NewDrain()
 
-	//workers
-	workers := workers.NewWorkers(database, fileStore, logFileQueue, liveProgressMessenger, config.Env.LogConfig.Keywords)
-	workers.StartMany(numOfWorkers)
+	//workers, unless left to separate worker processes
+	var logWorkers *workers.Worker
+	if !config.Env.WorkerConfig.DisableEmbeddedWorkers {
+		logWorkers = workers.NewWorkers(database, fileStore, logFileQueue, liveProgressMessenger, config.Env.LogConfig.Keywords)
+		logWorkers.Start()
+	}
 
 	//handlers
-	httpHandler := handler.NewHttpHandler(logFileQueue, fileStore, database, supabaseAuth)
-	websocketManager := handler.NewWebSocketManager(liveProgressMessenger, database)
+	orgAccess := middleware.NewOrgAccess(database)
+	adminAccess := middleware.NewAdminAccess(database)
+	jobAccess := middleware.NewJobAccess(database, orgAccess)
+	httpHandler := handler.NewHttpHandler(logFileQueue, liveProgressMessenger, fileStore, database, authProvider, drain, jobAccess, orgAccess, adminAccess)
+	websocketManager := handler.NewWebSocketManager(liveProgressMessenger, database, jobAccess)
 
 	//initialize routes
-	routes.MountRoutes(app, httpHandler, websocketManager)
+	routes.MountRoutes(app, httpHandler, websocketManager, database, jobAccess, orgAccess, adminAccess, drain)
+
+	return &Server{
+		app:              app,
+		drain:            drain,
+		workers:          logWorkers,
+		websocketManager: websocketManager,
+		logFileQueue:     logFileQueue,
+		liveStatusQueue:  liveProgressMessenger,
+		database:         database,
+	}
+}
+
+// newAuthProvider returns the provider of AUTH_PROVIDER, Supabase if not set
+func newAuthProvider(database *gorm.DB) auth.Provider {
+	authConfig := config.Env.AuthConfig
+	switch authConfig.AuthProvider {
+	case config.AuthProviderLocal:
+		if authConfig.AuthJwtSecret == "" {
+			log.Fatal("AUTH_JWT_SECRET is required by the local auth provider")
+		}
+		var sendResetToken auth.ResetTokenSender
+		switch {
+		case authConfig.SMTPAddr != "":
+			sendResetToken = auth.NewSMTPResetSender(authConfig.SMTPAddr, authConfig.SMTPUser, authConfig.SMTPPassword, authConfig.SMTPFrom, authConfig.PasswordResetURL)
+		case authConfig.LogResetTokens:
+			log.Warn("AUTH_LOG_RESET_TOKENS is set: password reset tokens are logged in plain text. Never set it in production")
+			sendResetToken = auth.LogResetToken
+		default:
+			log.Fatal("SMTP_ADDR is required by the local auth provider to email password reset tokens (or AUTH_LOG_RESET_TOKENS for development)")
+		}
+		return auth.NewLocalProvider(database,
+			time.Duration(authConfig.AccessTokenTTLMinutes)*time.Minute,
+			time.Duration(authConfig.RefreshTokenTTLHours)*time.Hour,
+			sendResetToken)
+	case config.AuthProviderSupabase, "":
+		return auth.NewSupabaseProvider(config.Env.SupaBaseProjectReference, config.Env.SupaBaseKey)
+	default:
+		log.Fatalf("Unknown AUTH_PROVIDER %q, expected %s or %s", authConfig.AuthProvider, config.AuthProviderSupabase, config.AuthProviderLocal)
+		return nil
+	}
+}
+
+func (s *Server) Listen(addr string) error {
+	return s.app.Listen(addr)
+}
+
+// Shutdown stops the server, in order:
+//   - new uploads and websockets are refused, and event streams are ended
+//   - open websockets are closed with a going away close frame, for clients to reconnect to another instance
+//   - jobs and requests in progress get until ctx is done to finish. Jobs still in progress then are
+//     checkpointed and requeued.
+//   - the queue and database connections are closed
+func (s *Server) Shutdown(ctx context.Context) {
+	s.drain.Start()
+	s.websocketManager.CloseAll()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if s.workers == nil {
+			return
+		}
+		if err := s.workers.Shutdown(ctx); err != nil {
+			log.Warnf("Workers shutdown: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := s.app.ShutdownWithContext(ctx); err != nil {
+			log.Errorf("Error shutting down http server: %v", err)
+		}
+	}()
+	wg.Wait()
 
-	return app
+	if err := s.logFileQueue.Close(); err != nil {
+		log.Errorf("Error closing log queue: %v", err)
+	}
+	if err := s.liveStatusQueue.Close(); err != nil {
+		log.Errorf("Error closing live status queue: %v", err)
+	}
+	if sqlDB, err := s.database.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			log.Errorf("Error closing database: %v", err)
+		}
+	}
 }
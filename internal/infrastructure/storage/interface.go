@@ -8,5 +8,6 @@ import (
 type Storage interface {
 	UploadFile(fileHeader *multipart.FileHeader) (url string, err error)
 	StreamLogs(fileURL string) (io.ReadCloser, error)
+	StreamLogsFrom(fileURL string, offset int64) (io.ReadCloser, error) //streams from the byte offset till the end of the file
 	GetFileSize(fileURL string) (int64, error)
 }
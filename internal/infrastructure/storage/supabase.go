@@ -107,6 +107,28 @@ func (sb *SupabaseStorage) StreamLogs(fileURL string) (io.ReadCloser, error) {
 	return resp.RawBody(), nil
 }
 
+func (sb *SupabaseStorage) StreamLogsFrom(fileURL string, offset int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		return sb.StreamLogs(fileURL)
+	}
+
+	resp, err := resty.New().R().
+		SetDoNotParseResponse(true).
+		SetHeader("Range", fmt.Sprintf("bytes=%d-", offset)).
+		Get(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch file stream from offset %d: %v", offset, err)
+	}
+
+	// A 200 would mean that the range was ignored, and the stream starts from byte zero
+	if resp.StatusCode() != http.StatusPartialContent {
+		resp.RawBody().Close()
+		return nil, fmt.Errorf("failed to fetch file from offset %d. Status: %d", offset, resp.StatusCode())
+	}
+
+	return resp.RawBody(), nil
+}
+
 func (sb *SupabaseStorage) GetFileSize(fileURL string) (int64, error) {
 	resp, err := resty.New().R().Head(fileURL)
 	if err != nil || resp.RawResponse == nil {
@@ -0,0 +1,441 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	LogLevelInfo  = "INFO"
+	LogLevelDebug = "DEBUG"
+	LogLevelWarn  = "WARN"
+	LogLevelError = "ERROR"
+)
+
+var (
+	ErrInvalidLogFormat = errors.New("invalid log format")
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+)
+
+// timestampLen is the length of "2006-01-02T15:04:05Z"
+const timestampLen = 20
+
+// LogLine is a parsed log entry of the built-in format:
+//
+//	[2025-02-20T10:05:23Z] ERROR Database timeout {"userId": 123, "ip": "192.168.1.1"}
+//
+// Message points into the buffer given to ParseLogLine, so it is only valid until that buffer is reused.
+type LogLine struct {
+	Level   string
+	Message []byte
+}
+
+// ParseLogLine is a hand-written, allocation free equivalent of ExtractLogDetails.
+// It accepts exactly the lines the regexp in ExtractLogDetails accepts, but leaves
+// JSON field extraction to the caller (see ExtractJSONStringField).
+func ParseLogLine(line []byte) (LogLine, error) {
+	// "[" + timestamp + "]" + at least one space + level
+	if len(line) < timestampLen+3 || line[0] != '[' || line[timestampLen+1] != ']' {
+		return LogLine{}, ErrInvalidLogFormat
+	}
+	if !isValidTimestamp(line[1 : timestampLen+1]) {
+		if !hasTimestampShape(line[1 : timestampLen+1]) {
+			return LogLine{}, ErrInvalidLogFormat
+		}
+		return LogLine{}, ErrInvalidTimestamp
+	}
+
+	rest := line[timestampLen+2:]
+	spaces := countLeadingSpaces(rest)
+	if spaces == 0 {
+		return LogLine{}, ErrInvalidLogFormat
+	}
+	rest = rest[spaces:]
+
+	level := matchLogLevel(rest)
+	if level == "" {
+		return LogLine{}, ErrInvalidLogFormat
+	}
+	rest = rest[len(level):]
+
+	spaces = countLeadingSpaces(rest)
+	switch {
+	case spaces == 0:
+		return LogLine{}, ErrInvalidLogFormat
+	case spaces < len(rest):
+		rest = rest[spaces:]
+	case spaces >= 2:
+		// Message made of whitespace only: the regexp hands its last character to the message group.
+		rest = rest[spaces-1:]
+	default:
+		return LogLine{}, ErrInvalidLogFormat
+	}
+
+	if bytes.IndexByte(rest, '\n') >= 0 {
+		return LogLine{}, ErrInvalidLogFormat
+	}
+
+	return LogLine{Level: level, Message: rest}, nil
+}
+
+// TrimLineEnding drops a trailing "\n" or "\r\n" from line.
+func TrimLineEnding(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func matchLogLevel(b []byte) string {
+	for _, level := range [...]string{LogLevelInfo, LogLevelDebug, LogLevelWarn, LogLevelError} {
+		if len(b) >= len(level) && string(b[:len(level)]) == level {
+			return level
+		}
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func countLeadingSpaces(b []byte) int {
+	n := 0
+	for n < len(b) && isSpace(b[n]) {
+		n++
+	}
+	return n
+}
+
+// hasTimestampShape reports whether ts looks like dddd-dd-ddTdd:dd:ddZ, regardless of the values.
+func hasTimestampShape(ts []byte) bool {
+	const shape = "dddd-dd-ddTdd:dd:ddZ"
+	for i := 0; i < len(shape); i++ {
+		if shape[i] == 'd' {
+			if ts[i] < '0' || ts[i] > '9' {
+				return false
+			}
+		} else if ts[i] != shape[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidTimestamp validates ts the same way time.Parse(time.RFC3339, ...) would for the shape above.
+func isValidTimestamp(ts []byte) bool {
+	if !hasTimestampShape(ts) {
+		return false
+	}
+
+	year := digits(ts[0:4])
+	month := digits(ts[5:7])
+	day := digits(ts[8:10])
+	hour := digits(ts[11:13])
+	minute := digits(ts[14:16])
+	second := digits(ts[17:19])
+
+	if month < 1 || month > 12 || day < 1 || day > daysIn(month, year) {
+		return false
+	}
+	return hour < 24 && minute < 60 && second < 60
+}
+
+func digits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+func daysIn(month, year int) int {
+	switch month {
+	case 2:
+		if year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+			return 29
+		}
+		return 28
+	case 4, 6, 9, 11:
+		return 30
+	default:
+		return 31
+	}
+}
+
+// ExtractJSONStringField lazily looks up a top level string field in the JSON object that
+// starts at the first '{' of message, without building a map of the whole object.
+// Like json.Unmarshal, the object must be valid and followed only by whitespace, and the
+// last occurrence of a duplicated key wins. It returns nil if the field is missing or not a string.
+// The returned slice points into message unless the value had to be unescaped.
+func ExtractJSONStringField(message []byte, field string) []byte {
+	start := bytes.IndexByte(message, '{')
+	if start < 0 {
+		return nil
+	}
+
+	s := jsonScanner{data: message, pos: start + 1}
+	var value []byte
+	valueIsString := false
+
+	s.skipSpaces()
+	if s.peek() == '}' {
+		s.pos++
+	} else {
+		for {
+			key, ok := s.readString()
+			if !ok {
+				return nil
+			}
+			s.skipSpaces()
+			if s.peek() != ':' {
+				return nil
+			}
+			s.pos++
+			s.skipSpaces()
+
+			isField := jsonStringEquals(key, field)
+			if isField && s.peek() == '"' {
+				raw, ok := s.readString()
+				if !ok {
+					return nil
+				}
+				value, valueIsString = raw, true
+			} else {
+				if !s.skipValue(0) {
+					return nil
+				}
+				if isField {
+					value, valueIsString = nil, false
+				}
+			}
+
+			s.skipSpaces()
+			switch s.peek() {
+			case ',':
+				s.pos++
+				s.skipSpaces()
+				continue
+			case '}':
+				s.pos++
+			default:
+				return nil
+			}
+			break
+		}
+	}
+
+	s.skipSpaces()
+	if s.pos != len(s.data) || !valueIsString {
+		return nil
+	}
+
+	if bytes.IndexByte(value, '\\') < 0 {
+		return value
+	}
+	return unescapeJSONString(value)
+}
+
+// jsonStringEquals compares the raw contents of a JSON string (without quotes) with s.
+func jsonStringEquals(raw []byte, s string) bool {
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw) == s
+	}
+	return string(unescapeJSONString(raw)) == s
+}
+
+func unescapeJSONString(raw []byte) []byte {
+	quoted := make([]byte, 0, len(raw)+2)
+	quoted = append(quoted, '"')
+	quoted = append(quoted, raw...)
+	quoted = append(quoted, '"')
+
+	var unquoted string
+	if err := json.Unmarshal(quoted, &unquoted); err != nil {
+		return nil
+	}
+	return []byte(unquoted)
+}
+
+// maxJSONDepth mirrors the nesting limit of encoding/json
+const maxJSONDepth = 10000
+
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *jsonScanner) peek() byte {
+	if s.pos >= len(s.data) {
+		return 0
+	}
+	return s.data[s.pos]
+}
+
+func (s *jsonScanner) skipSpaces() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\n', '\r':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// readString reads a JSON string at the current position and returns its raw contents, without quotes.
+func (s *jsonScanner) readString() ([]byte, bool) {
+	if s.peek() != '"' {
+		return nil, false
+	}
+	s.pos++
+	start := s.pos
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case c == '"':
+			s.pos++
+			return s.data[start : s.pos-1], true
+		case c == '\\':
+			if s.pos+1 >= len(s.data) {
+				return nil, false
+			}
+			switch s.data[s.pos+1] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				s.pos += 2
+			case 'u':
+				if s.pos+6 > len(s.data) {
+					return nil, false
+				}
+				for _, h := range s.data[s.pos+2 : s.pos+6] {
+					if !isHexDigit(h) {
+						return nil, false
+					}
+				}
+				s.pos += 6
+			default:
+				return nil, false
+			}
+		case c < 0x20:
+			return nil, false
+		default:
+			s.pos++
+		}
+	}
+	return nil, false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func (s *jsonScanner) skipValue(depth int) bool {
+	if depth > maxJSONDepth {
+		return false
+	}
+	switch c := s.peek(); {
+	case c == '"':
+		_, ok := s.readString()
+		return ok
+	case c == '{':
+		return s.skipComposite('}', depth, true)
+	case c == '[':
+		return s.skipComposite(']', depth, false)
+	case c == 't':
+		return s.skipLiteral("true")
+	case c == 'f':
+		return s.skipLiteral("false")
+	case c == 'n':
+		return s.skipLiteral("null")
+	case c == '-' || (c >= '0' && c <= '9'):
+		return s.skipNumber()
+	default:
+		return false
+	}
+}
+
+func (s *jsonScanner) skipComposite(closing byte, depth int, isObject bool) bool {
+	s.pos++
+	s.skipSpaces()
+	if s.peek() == closing {
+		s.pos++
+		return true
+	}
+	for {
+		if isObject {
+			if _, ok := s.readString(); !ok {
+				return false
+			}
+			s.skipSpaces()
+			if s.peek() != ':' {
+				return false
+			}
+			s.pos++
+			s.skipSpaces()
+		}
+		if !s.skipValue(depth + 1) {
+			return false
+		}
+		s.skipSpaces()
+		switch s.peek() {
+		case ',':
+			s.pos++
+			s.skipSpaces()
+		case closing:
+			s.pos++
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (s *jsonScanner) skipLiteral(literal string) bool {
+	if len(s.data)-s.pos < len(literal) || string(s.data[s.pos:s.pos+len(literal)]) != literal {
+		return false
+	}
+	s.pos += len(literal)
+	return true
+}
+
+func (s *jsonScanner) skipNumber() bool {
+	if s.peek() == '-' {
+		s.pos++
+	}
+	switch c := s.peek(); {
+	case c == '0':
+		s.pos++
+	case c >= '1' && c <= '9':
+		s.skipDigits()
+	default:
+		return false
+	}
+	if s.peek() == '.' {
+		s.pos++
+		if !s.skipDigits() {
+			return false
+		}
+	}
+	if c := s.peek(); c == 'e' || c == 'E' {
+		s.pos++
+		if c := s.peek(); c == '+' || c == '-' {
+			s.pos++
+		}
+		if !s.skipDigits() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *jsonScanner) skipDigits() bool {
+	start := s.pos
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+	return s.pos > start
+}
@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ParseLogLine and ExtractJSONStringField must agree with the regexp based ExtractLogDetails.
+func TestParseLogLineMatchesExtractLogDetails(t *testing.T) {
+	lines := []string{
+		`[2025-02-20T10:00:00Z] INFO Server started  `,
+		`[2025-02-20T10:01:15Z] DEBUG Initializing database connection`,
+		`[2025-02-20T10:02:30Z] INFO User login successful {"userId": 101, "ip": "192.168.1.5"}  `,
+		`[2025-02-20T10:05:23Z]   ERROR	Database timeout {"userId": 123, "ip": "192.168.1.1"}`,
+		`[2025-02-20T10:09:30Z] ERROR Payment gateway unreachable {"error": "Connection refused", "orderId": 56789}`,
+		`[2025-02-20T10:09:30Z] WARN nested {"a": {"ip": "10.0.0.1"}, "b": [1, -2.5e3, true, null], "ip": "10.0.0.2"}`,
+		`[2025-02-20T10:09:30Z] WARN escaped {"\u0069p": "10\u002e0.0.3", "x": "a\"b"}`,
+		`[2025-02-20T10:09:30Z] WARN duplicate {"ip": "10.0.0.1", "ip": 5}`,
+		`[2025-02-20T10:09:30Z] WARN duplicate {"ip": 5, "ip": "10.0.0.1"}`,
+		`[2025-02-20T10:09:30Z] WARN trailing {"ip": "10.0.0.1"} trailing`,
+		`[2025-02-20T10:09:30Z] WARN broken {"ip": "10.0.0.1"`,
+		`[2025-02-20T10:09:30Z] WARN bad number {"n": 01, "ip": "10.0.0.1"}`,
+		`[2025-02-20T10:09:30Z] WARN empty {}`,
+		`[2025-02-20T10:09:30Z] WARN empty ip {"ip": ""}`,
+		`[2025-02-20T10:09:30Z] INFO  `,
+		`[2025-02-20T10:09:30Z] INFO `,
+		`[2025-02-20T10:09:30Z] INFO`,
+		`[2025-02-20T10:09:30Z] INFOX message`,
+		`[2025-02-20T10:09:30Z] TRACE message`,
+		`[2025-02-20T10:09:30Z]INFO message`,
+		`[2024-02-29T23:59:59Z] INFO leap day`,
+		`[2025-02-29T10:00:00Z] INFO not a leap year`,
+		`[2025-13-01T10:00:00Z] INFO bad month`,
+		`[2025-01-01T24:00:00Z] INFO bad hour`,
+		`[2025-01-01T10:00:60Z] INFO bad second`,
+		`[2025-01-01 10:00:00Z] INFO bad shape`,
+		`2025-01-01T10:00:00Z INFO no brackets`,
+		`Invalid log format`,
+		``,
+	}
+
+	for _, line := range lines {
+		t.Run(line, func(t *testing.T) {
+			wantLevel, wantMessage, wantIP, wantErr := ExtractLogDetails(line)
+
+			parsed, err := ParseLogLine([]byte(line))
+			if wantErr != nil {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, wantLevel, parsed.Level)
+			assert.Equal(t, wantMessage, string(parsed.Message))
+			assert.Equal(t, wantIP, string(ExtractJSONStringField(parsed.Message, "ip")))
+		})
+	}
+}
@@ -15,7 +15,8 @@ func IsValidLogFile(filename string) bool {
 	return strings.HasSuffix(filename, ".log")
 }
 
-// Extract log level, log payload and IP from log entry
+// Extract log level, log payload and IP from log entry.
+// This is the regexp based reference implementation. The workers use the faster ParseLogLine and ExtractJSONStringField.
 func ExtractLogDetails(logEntry string) (string, string, string, error) {
 	pattern := `^\[(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z)\]\s+(INFO|DEBUG|WARN|ERROR)\s+(.+)$`
 	re := regexp.MustCompile(pattern)
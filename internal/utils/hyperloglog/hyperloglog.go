@@ -0,0 +1,274 @@
+// Package hyperloglog counts the distinct items in a stream using a bounded amount of memory.
+// Sketches built separately (per chunk, per file, per process) can be merged, and the merged sketch
+// counts the distinct items across all of them, counting items seen in several sketches once.
+//
+// While a sketch has seen at most ExactLimit distinct items, it keeps their 64 bit hashes and its
+// count is exact (barring hash collisions). Beyond that, it is a HyperLogLog estimate.
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	precision     = 14
+	registerCount = 1 << precision // 16384 registers of 1 byte, for a standard error of about 0.8%
+
+	// ExactLimit is the number of distinct items up to which counts are exact
+	ExactLimit = 4096
+
+	formatDense  byte = 1 // all registers
+	formatSparse byte = 2 // (index, value) pairs of the non-zero registers
+	formatExact  byte = 3 // hashes of the items
+)
+
+// Sketch is a HyperLogLog sketch. The zero value is not usable, use New.
+type Sketch struct {
+	registers []uint8
+	hashes    map[uint64]struct{} //hashes of the distinct items, nil once there are more than ExactLimit
+}
+
+func New() *Sketch {
+	return &Sketch{
+		registers: make([]uint8, registerCount),
+		hashes:    make(map[uint64]struct{}),
+	}
+}
+
+// Add adds an item to the sketch. Past ExactLimit distinct items, it doesn't allocate.
+func (s *Sketch) Add(item []byte) {
+	s.addHash(hash(item))
+}
+
+func (s *Sketch) AddString(item string) {
+	s.addHash(hashString(item))
+}
+
+func (s *Sketch) addHash(h uint64) {
+	if s.hashes != nil {
+		s.hashes[h] = struct{}{}
+		if len(s.hashes) > ExactLimit {
+			s.hashes = nil
+		}
+	}
+
+	index := h >> (64 - precision)
+	rank := uint8(bits.LeadingZeros64(h<<precision|1<<(precision-1)) + 1)
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge adds the items of other into s.
+func (s *Sketch) Merge(other *Sketch) {
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+
+	if s.hashes == nil || other.hashes == nil {
+		s.hashes = nil
+		return
+	}
+	for h := range other.hashes {
+		s.hashes[h] = struct{}{}
+	}
+	if len(s.hashes) > ExactLimit {
+		s.hashes = nil
+	}
+}
+
+func (s *Sketch) Clone() *Sketch {
+	clone := New()
+	copy(clone.registers, s.registers)
+	if s.hashes == nil {
+		clone.hashes = nil
+	}
+	for h := range s.hashes {
+		clone.hashes[h] = struct{}{}
+	}
+	return clone
+}
+
+// IsExact reports whether Count is exact rather than an estimate.
+func (s *Sketch) IsExact() bool {
+	return s.hashes != nil
+}
+
+// Count returns the number of distinct items added to the sketch, exact or estimated (see IsExact).
+func (s *Sketch) Count() uint64 {
+	if s.hashes != nil {
+		return uint64(len(s.hashes))
+	}
+
+	const m = float64(registerCount)
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Small cardinalities are estimated more accurately by linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(estimate))
+}
+
+// MarshalBinary encodes the sketch, compactly when only a few registers are set.
+// Exact sketches are encoded as their hashes, from which the registers are rebuilt.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	if s.hashes != nil {
+		data := make([]byte, 0, 4+8*len(s.hashes))
+		data = append(data, formatExact, precision)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(s.hashes)))
+		for h := range s.hashes {
+			data = binary.BigEndian.AppendUint64(data, h)
+		}
+		return data, nil
+	}
+
+	nonZero := 0
+	for _, rank := range s.registers {
+		if rank != 0 {
+			nonZero++
+		}
+	}
+
+	if 3*nonZero >= registerCount {
+		data := make([]byte, 0, 2+registerCount)
+		data = append(data, formatDense, precision)
+		return append(data, s.registers...), nil
+	}
+
+	data := make([]byte, 0, 4+3*nonZero)
+	data = append(data, formatSparse, precision)
+	data = binary.BigEndian.AppendUint16(data, uint16(nonZero))
+	for i, rank := range s.registers {
+		if rank != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, rank)
+		}
+	}
+	return data, nil
+}
+
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("hyperloglog: sketch too short")
+	}
+	if data[1] != precision {
+		return fmt.Errorf("hyperloglog: unsupported precision %d", data[1])
+	}
+
+	decoded := &Sketch{registers: make([]uint8, registerCount)}
+	registers := decoded.registers
+	switch data[0] {
+	case formatDense:
+		if len(data) != 2+registerCount {
+			return fmt.Errorf("hyperloglog: invalid dense sketch length %d", len(data))
+		}
+		copy(registers, data[2:])
+
+	case formatSparse:
+		if len(data) < 4 {
+			return fmt.Errorf("hyperloglog: sparse sketch too short")
+		}
+		nonZero := int(binary.BigEndian.Uint16(data[2:4]))
+		pairs := data[4:]
+		if len(pairs) != 3*nonZero {
+			return fmt.Errorf("hyperloglog: invalid sparse sketch length %d", len(data))
+		}
+		for i := 0; i < len(pairs); i += 3 {
+			index := binary.BigEndian.Uint16(pairs[i : i+2])
+			if int(index) >= registerCount {
+				return fmt.Errorf("hyperloglog: register index %d out of range", index)
+			}
+			registers[index] = pairs[i+2]
+		}
+
+	case formatExact:
+		if len(data) < 4 {
+			return fmt.Errorf("hyperloglog: exact sketch too short")
+		}
+		count := int(binary.BigEndian.Uint16(data[2:4]))
+		hashes := data[4:]
+		if len(hashes) != 8*count || count > ExactLimit {
+			return fmt.Errorf("hyperloglog: invalid exact sketch length %d", len(data))
+		}
+		decoded.hashes = make(map[uint64]struct{}, count)
+		for i := 0; i < len(hashes); i += 8 {
+			decoded.addHash(binary.BigEndian.Uint64(hashes[i : i+8]))
+		}
+
+	default:
+		return fmt.Errorf("hyperloglog: unknown format %d", data[0])
+	}
+
+	*s = *decoded
+	return nil
+}
+
+// MarshalJSON encodes the sketch as a base64 string of its binary encoding.
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func (s *Sketch) UnmarshalJSON(text []byte) error {
+	var data []byte
+	if err := json.Unmarshal(text, &data); err != nil {
+		return err
+	}
+	return s.UnmarshalBinary(data)
+}
+
+// hash is 64 bit FNV-1a, followed by the murmur3 finalizer to spread FNV's weak high bits,
+// which pick the register. It must never change, as sketches are persisted and merged later.
+func hash(item []byte) uint64 {
+	h := uint64(offset64)
+	for _, c := range item {
+		h ^= uint64(c)
+		h *= prime64
+	}
+	return mix(h)
+}
+
+func hashString(item string) uint64 {
+	h := uint64(offset64)
+	for i := 0; i < len(item); i++ {
+		h ^= uint64(item[i])
+		h *= prime64
+	}
+	return mix(h)
+}
+
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,104 @@
+package hyperloglog
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCount(t *testing.T) {
+	for _, distinct := range []int{0, 1, 2, 10, 100, 1000, ExactLimit, ExactLimit + 1, 10000, 100000, 1000000} {
+		t.Run(fmt.Sprint(distinct), func(t *testing.T) {
+			sketch := New()
+			for i := 0; i < distinct; i++ {
+				ip := []byte(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255))
+				sketch.Add(ip)
+				sketch.Add(ip) //duplicates don't count
+			}
+
+			count := float64(sketch.Count())
+			if distinct <= ExactLimit {
+				assert.True(t, sketch.IsExact())
+				assert.Equal(t, float64(distinct), count, "counts up to ExactLimit should be exact")
+			} else {
+				assert.False(t, sketch.IsExact())
+				assert.InDelta(t, distinct, count, 0.03*float64(distinct), "should be within 3%")
+			}
+		})
+	}
+}
+
+func TestMergeCountsSharedItemsOnce(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 30000; i++ {
+		a.AddString(fmt.Sprint("ip-", i))
+	}
+	for i := 20000; i < 50000; i++ {
+		b.AddString(fmt.Sprint("ip-", i))
+	}
+
+	merged := a.Clone()
+	merged.Merge(b)
+	assert.InDelta(t, 50000, float64(merged.Count()), 0.03*50000)
+	assert.InDelta(t, 30000, float64(a.Count()), 0.03*30000, "merging into a clone should leave the original alone")
+}
+
+func TestMergeStaysExactUpToExactLimit(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 3000; i++ {
+		a.AddString(fmt.Sprint("ip-", i))
+		b.AddString(fmt.Sprint("ip-", i+1000))
+	}
+
+	merged := a.Clone()
+	merged.Merge(b)
+	assert.True(t, merged.IsExact())
+	assert.Equal(t, uint64(4000), merged.Count())
+
+	b.AddString("one more")
+	b.AddString("and another")
+	for i := 0; i < 200; i++ {
+		b.AddString(fmt.Sprint("extra-", i))
+	}
+	merged.Merge(b)
+	assert.False(t, merged.IsExact(), "past ExactLimit distinct items, the count is an estimate")
+	assert.InDelta(t, 4202, float64(merged.Count()), 0.03*4202)
+}
+
+func TestAddAndAddStringAgree(t *testing.T) {
+	a, b := New(), New()
+	a.Add([]byte("192.168.1.1"))
+	b.AddString("192.168.1.1")
+	assert.Equal(t, a, b)
+}
+
+func TestEncoding(t *testing.T) {
+	for _, distinct := range []int{0, 5, 4000, 5000, 100000} { //exact, sparse and dense
+		t.Run(fmt.Sprint(distinct), func(t *testing.T) {
+			sketch := New()
+			for i := 0; i < distinct; i++ {
+				sketch.AddString(fmt.Sprint(i))
+			}
+
+			data, err := sketch.MarshalBinary()
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, len(data), max(2+registerCount, 4+8*ExactLimit))
+
+			decoded := New()
+			assert.NoError(t, decoded.UnmarshalBinary(data))
+			assert.Equal(t, sketch, decoded)
+
+			text, err := json.Marshal(sketch)
+			assert.NoError(t, err)
+			decoded = New()
+			assert.NoError(t, json.Unmarshal(text, decoded))
+			assert.Equal(t, sketch, decoded)
+		})
+	}
+
+	assert.Error(t, New().UnmarshalBinary([]byte{formatSparse, precision, 0, 1, 0xff, 0xff, 1}), "register index out of range")
+	assert.Error(t, New().UnmarshalBinary([]byte{9, precision}), "unknown format")
+	assert.Error(t, New().UnmarshalBinary(nil))
+}
@@ -3,35 +3,301 @@ package jwttoken
 import (
 	"fmt"
 	"log-flow/internal/infrastructure/config"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// Claim telling refresh tokens apart from access tokens, as the local auth provider issues both
+const (
+	tokenTypeClaim   = "token_type"
+	refreshTokenType = "refresh"
+)
+
+// Issuer and audience of the tokens of the local auth provider, unless set by AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE
+const (
+	localIssuer   = "log-flow"
+	localAudience = "log-flow"
+
+	supabaseAudience = "authenticated"
+)
+
+// Claims are what a request is authenticated as, out of a token
+type Claims struct {
+	UserID    string
+	TokenID   string    //jti, empty if the token has none
+	SessionID string    //session_id of Supabase tokens, which have no jti
+	ExpiresAt time.Time //zero if the token doesn't expire
+	IssuedAt  time.Time //zero if the token has no iat
+	Roles     []string  //system roles of the user, from the claim of AUTH_ROLES_CLAIM
+}
+
+// Verifier set by UseVerifier, if any
+var configuredVerifier atomic.Pointer[Verifier]
+
+// UseVerifier makes ValidateToken and ValidateRefreshToken validate tokens with the verifier,
+// instead of the HS256 secret of the auth provider.
+func UseVerifier(verifier *Verifier) {
+	configuredVerifier.Store(verifier)
+}
+
+// RevocationID is what the token is revoked by: its jti, or its session for tokens without one,
+// revoking all the tokens of the session. Empty if the token has neither.
+func (c *Claims) RevocationID() string {
+	if c.TokenID != "" {
+		return c.TokenID
+	}
+	if c.SessionID != "" {
+		return "session:" + c.SessionID
+	}
+	return ""
+}
+
 // ValidateTokenAndGetUserID validates the JWT token and returns the User ID
 func ValidateTokenAndGetUserID(tokenStr string) (string, error) {
+	claims, err := ValidateToken(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// ValidateToken validates an access token: its algorithm, signature, expiry, issuer and audience. Refresh tokens are rejected.
+// Whether the token has been revoked is left to the caller.
+func ValidateToken(tokenStr string) (*Claims, error) {
+	return verifier().ValidateToken(tokenStr)
+}
+
+// ValidateRefreshToken validates a refresh token of the local auth provider, like ValidateToken.
+func ValidateRefreshToken(tokenStr string) (*Claims, error) {
+	return verifier().ValidateRefreshToken(tokenStr)
+}
+
+// SignToken issues a token of the user valid for ttl, signed with the secret of the local auth provider,
+// and returns it with its claims.
+func SignToken(userID string, refresh bool, ttl time.Duration) (string, *Claims, error) {
+	now := time.Now()
+	issued := &Claims{
+		UserID:    userID,
+		TokenID:   uuid.NewString(),
+		ExpiresAt: now.Add(ttl),
+	}
+	claims := jwt.MapClaims{
+		"sub": issued.UserID,
+		"jti": issued.TokenID,
+		"iss": issuer(),
+		"aud": audience(),
+		"iat": now.Unix(),
+		"exp": issued.ExpiresAt.Unix(),
+	}
+	if refresh {
+		claims[tokenTypeClaim] = refreshTokenType
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingSecret())
+	if err != nil {
+		return "", nil, fmt.Errorf("Error signing token: %v", err)
+	}
+	return token, issued, nil
+}
+
+// Verifier validates tokens signed with a single algorithm, by keys given by its keyfunc, for an issuer and audience.
+type Verifier struct {
+	algorithm string
+	keyfunc   jwt.Keyfunc
+	issuer    string //not validated if empty
+	audience  string
+}
+
+func NewVerifier(algorithm string, keyfunc jwt.Keyfunc, issuer, audience string) *Verifier {
+	return &Verifier{
+		algorithm: algorithm,
+		keyfunc:   keyfunc,
+		issuer:    issuer,
+		audience:  audience,
+	}
+}
+
+// NewVerifierFromConfig returns the verifier of the tokens of the auth provider: by the public key of AUTH_JWT_PUBLIC_KEY,
+// or the keys of AUTH_JWKS_URL, for identity providers signing with asymmetric keys, or by the HS256 secret otherwise.
+func NewVerifierFromConfig() (*Verifier, error) {
+	authConfig := config.Env.AuthConfig
+	if authConfig.AuthJwtPublicKey == "" && authConfig.AuthJwksURL == "" {
+		if authConfig.AuthJwtAlgorithm != "" && authConfig.AuthJwtAlgorithm != jwt.SigningMethodHS256.Alg() {
+			return nil, fmt.Errorf("AUTH_JWT_ALGORITHM %s needs AUTH_JWT_PUBLIC_KEY or AUTH_JWKS_URL", authConfig.AuthJwtAlgorithm)
+		}
+		return NewVerifier(jwt.SigningMethodHS256.Alg(), secretKeyfunc, issuer(), audience()), nil
+	}
+
+	if authConfig.AuthProvider == config.AuthProviderLocal {
+		return nil, fmt.Errorf("The local auth provider signs tokens with AUTH_JWT_SECRET, AUTH_JWT_PUBLIC_KEY and AUTH_JWKS_URL can't be used with it")
+	}
+	if authConfig.AuthJwtIssuer == "" || authConfig.AuthJwtAudience == "" {
+		return nil, fmt.Errorf("AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE are required with AUTH_JWT_PUBLIC_KEY or AUTH_JWKS_URL")
+	}
+	algorithm := authConfig.AuthJwtAlgorithm
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodRS256.Alg()
+	}
+	if !isAsymmetric(algorithm) {
+		return nil, fmt.Errorf("AUTH_JWT_ALGORITHM %s isn't an asymmetric algorithm, as needed with a public key", algorithm)
+	}
+
+	var keyfunc jwt.Keyfunc
+	if authConfig.AuthJwksURL != "" {
+		keyfunc = NewJWKS(authConfig.AuthJwksURL, time.Duration(authConfig.AuthJwksCacheMinutes)*time.Minute).Keyfunc
+	} else {
+		key, err := LoadPublicKey(authConfig.AuthJwtPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keyfunc = func(*jwt.Token) (any, error) { return key, nil }
+	}
+	return NewVerifier(algorithm, keyfunc, authConfig.AuthJwtIssuer, authConfig.AuthJwtAudience), nil
+}
+
+func (v *Verifier) ValidateToken(tokenStr string) (*Claims, error) {
+	claims, err := v.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims[tokenTypeClaim] == refreshTokenType {
+		return nil, fmt.Errorf("Refresh tokens can't be used as access tokens")
+	}
+	return claimsOf(claims)
+}
+
+func (v *Verifier) ValidateRefreshToken(tokenStr string) (*Claims, error) {
+	claims, err := v.parse(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims[tokenTypeClaim] != refreshTokenType {
+		return nil, fmt.Errorf("Not a refresh token")
+	}
+	return claimsOf(claims)
+}
+
+func (v *Verifier) parse(tokenStr string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{v.algorithm}), //pinned, for tokens not to pick how they are verified
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(v.audience),
+	}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
 
 	// Parse the JWT token
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Env.SupaBaseJwtSecret), nil
-	})
+	token, err := jwt.Parse(tokenStr, v.keyfunc, options...)
 	if err != nil {
-		return "", fmt.Errorf("Error parsing token: %v", err)
+		return nil, fmt.Errorf("Error parsing token: %v", err)
 	}
 	if !token.Valid {
-		return "", fmt.Errorf("Invalid token")
+		return nil, fmt.Errorf("Invalid token")
 	}
 
 	// Extract claims from token
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", fmt.Errorf("Invalid token claims")
+		return nil, fmt.Errorf("Invalid token claims")
 	}
+	return claims, nil
+}
 
+func claimsOf(claims jwt.MapClaims) (*Claims, error) {
 	// Extract `sub` (Considering it as User ID, as it is unique)
 	userID, ok := claims["sub"].(string)
 	if !ok {
-		return "", fmt.Errorf("Subject (sub) claim missing or invalid")
+		return nil, fmt.Errorf("Subject (sub) claim missing or invalid")
 	}
 
-	return userID, nil
+	parsed := &Claims{UserID: userID}
+	parsed.TokenID, _ = claims["jti"].(string)
+	parsed.SessionID, _ = claims["session_id"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		parsed.ExpiresAt = exp.Time
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		parsed.IssuedAt = iat.Time
+	}
+	if config.Env.AuthRolesClaim != "" {
+		parsed.Roles = rolesOf(claims, config.Env.AuthRolesClaim)
+	}
+	return parsed, nil
+}
+
+// rolesOf returns the roles in the claim at the dotted path, given as a string or a list of them.
+func rolesOf(claims jwt.MapClaims, path string) []string {
+	var value any = map[string]any(claims)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		roles := make([]string, 0, len(value))
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
+
+// verifier is the one set by UseVerifier, or the HS256 one of the current config
+func verifier() *Verifier {
+	if configured := configuredVerifier.Load(); configured != nil {
+		return configured
+	}
+	return NewVerifier(jwt.SigningMethodHS256.Alg(), secretKeyfunc, issuer(), audience())
+}
+
+func secretKeyfunc(*jwt.Token) (any, error) {
+	return signingSecret(), nil
+}
+
+// signingSecret is the secret of the tokens of the auth provider in use
+func signingSecret() []byte {
+	if config.Env.AuthProvider == config.AuthProviderLocal {
+		return []byte(config.Env.AuthJwtSecret)
+	}
+	return []byte(config.Env.SupaBaseJwtSecret)
+}
+
+// issuer tokens must have been issued by: Supabase Auth of the project, if not set. Not validated if empty.
+func issuer() string {
+	switch {
+	case config.Env.AuthJwtIssuer != "":
+		return config.Env.AuthJwtIssuer
+	case config.Env.AuthProvider == config.AuthProviderLocal:
+		return localIssuer
+	case config.Env.SupaBaseURL != "":
+		return strings.TrimSuffix(config.Env.SupaBaseURL, "/") + "/auth/v1"
+	default:
+		return ""
+	}
+}
+
+// audience tokens must have been issued for
+func audience() string {
+	switch {
+	case config.Env.AuthJwtAudience != "":
+		return config.Env.AuthJwtAudience
+	case config.Env.AuthProvider == config.AuthProviderLocal:
+		return localAudience
+	default:
+		return supabaseAudience
+	}
 }
@@ -0,0 +1,216 @@
+package jwttoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "log-flow"
+)
+
+// jwksServer serves the public keys of its RSA keys by kid, counting fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	server := &jwksServer{keys: make(map[string]*rsa.PrivateKey)}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.fetches++
+		var keys []map[string]string
+		for kid, key := range server.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// rotate replaces the keys of the server by a new key of the kid
+func (s *jwksServer) rotate(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = map[string]*rsa.PrivateKey{kid: key}
+	return key
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	base := jwt.MapClaims{"sub": "user", "iss": testIssuer, "aud": testAudience, "exp": time.Now().Add(time.Hour).Unix()}
+	for claim, value := range claims {
+		base[claim] = value
+	}
+	token := jwt.NewWithClaims(method, base)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWKSVerifiesAndFollowsKeyRotation(t *testing.T) {
+	server := newJWKSServer(t)
+	firstKey := server.rotate(t, "first")
+	jwks := NewJWKS(server.URL, time.Hour)
+	verifier := NewVerifier("RS256", jwks.Keyfunc, testIssuer, testAudience)
+
+	claims, err := verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "first", firstKey, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.UserID)
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "first", firstKey, nil))
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.fetchCount(), "keys should be cached")
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodRS512, "first", firstKey, nil))
+	assert.Error(t, err, "tokens of other algorithms should be rejected, even signed with the key")
+
+	secondKey := server.rotate(t, "second")
+	jwks.minRefreshInterval = 0
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "second", secondKey, nil))
+	require.NoError(t, err, "tokens of a new key should trigger a refetch")
+	assert.Equal(t, 2, server.fetchCount())
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "first", firstKey, nil))
+	assert.Error(t, err, "tokens of a key rotated out should be rejected")
+}
+
+func TestJWKSRefetchesForUnknownKeysAtMostEveryInterval(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.rotate(t, "current")
+	verifier := NewVerifier("RS256", NewJWKS(server.URL, time.Hour).Keyfunc, testIssuer, testAudience)
+
+	for i := 0; i < 5; i++ {
+		_, err := verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "unknown", key, nil))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 1, server.fetchCount())
+	_, err := verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "current", key, nil))
+	assert.NoError(t, err, "known keys should still be served from the cache")
+}
+
+func TestJWKSKeepsCachedKeysWhenUnreachable(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.rotate(t, "current")
+	jwks := NewJWKS(server.URL, time.Hour)
+	verifier := NewVerifier("RS256", jwks.Keyfunc, testIssuer, testAudience)
+	_, err := verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "current", key, nil))
+	require.NoError(t, err)
+
+	server.Close()
+	jwks.fetchedAt = time.Now().Add(-2 * time.Hour) //stale
+	jwks.attemptedAt = time.Time{}
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "current", key, nil))
+	assert.NoError(t, err)
+}
+
+func TestJWKSKeepsCachedKeysWhenFetchedSetIsEmpty(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.rotate(t, "current")
+	jwks := NewJWKS(server.URL, time.Hour)
+	verifier := NewVerifier("RS256", jwks.Keyfunc, testIssuer, testAudience)
+	_, err := verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "current", key, nil))
+	require.NoError(t, err)
+
+	server.mu.Lock()
+	server.keys = map[string]*rsa.PrivateKey{}
+	server.mu.Unlock()
+	jwks.fetchedAt = time.Now().Add(-2 * time.Hour) //stale
+	jwks.attemptedAt = time.Time{}
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodRS256, "current", key, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.fetchCount())
+}
+
+func TestJWKSFetchesOnceForConcurrentTokensOfUnknownKeys(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.rotate(t, "current")
+	verifier := NewVerifier("RS256", NewJWKS(server.URL, time.Hour).Keyfunc, testIssuer, testAudience)
+	token := sign(t, jwt.SigningMethodRS256, "current", key, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.ValidateToken(token)
+			assert.NoError(t, err, "tokens should wait on the fetch in flight")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, server.fetchCount())
+}
+
+func TestVerifierPinsAlgorithmAndValidatesClaims(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	publicKey, err := LoadPublicKey(string(publicPEM))
+	require.NoError(t, err)
+	verifier := NewVerifier("ES256", func(*jwt.Token) (any, error) { return publicKey, nil }, testIssuer, testAudience)
+
+	_, err = verifier.ValidateToken(sign(t, jwt.SigningMethodES256, "", key, nil))
+	assert.NoError(t, err)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	for name, token := range map[string]string{
+		"HS256 with the public key as secret": sign(t, jwt.SigningMethodHS256, "", publicPEM, nil),
+		"other algorithm":                     sign(t, jwt.SigningMethodES384, "", otherKey, nil),
+		"other key":                           sign(t, jwt.SigningMethodES256, "", mustECKey(t), nil),
+		"other issuer":                        sign(t, jwt.SigningMethodES256, "", key, jwt.MapClaims{"iss": "https://evil.example.com"}),
+		"other audience":                      sign(t, jwt.SigningMethodES256, "", key, jwt.MapClaims{"aud": "other-app"}),
+		"expired":                             sign(t, jwt.SigningMethodES256, "", key, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"refresh token":                       sign(t, jwt.SigningMethodES256, "", key, jwt.MapClaims{"token_type": "refresh"}),
+	} {
+		_, err := verifier.ValidateToken(token)
+		assert.Error(t, err, name)
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
@@ -0,0 +1,255 @@
+package jwttoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWKSMaxAge = time.Hour
+
+	// Tokens signed with keys not in the cache trigger a refetch at most this often, not to flood the provider with them
+	jwksMinRefreshInterval = 30 * time.Second
+	jwksFetchTimeout       = 10 * time.Second
+)
+
+func isAsymmetric(algorithm string) bool {
+	switch jwt.GetSigningMethod(algorithm).(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		return true
+	default:
+		return false
+	}
+}
+
+// LoadPublicKey parses a PEM encoded RSA, ECDSA or Ed25519 public key, given as is or as the path of a file holding it.
+func LoadPublicKey(pemOrPath string) (any, error) {
+	pemData := []byte(pemOrPath)
+	if !strings.HasPrefix(strings.TrimSpace(pemOrPath), "-----BEGIN") {
+		var err error
+		if pemData, err = os.ReadFile(pemOrPath); err != nil {
+			return nil, fmt.Errorf("Failed to read public key. %v", err)
+		}
+	}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pemData); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(pemData); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(pemData); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Invalid public key, expected a PEM encoded RSA, ECDSA or Ed25519 public key")
+}
+
+// JWKS gives the signing keys of an identity provider, fetched from its JSON Web Key Set URL and cached by kid.
+// Keys are refetched once the cache is older than its max age, or for tokens signed with a key not in the cache,
+// as providers rotate their keys. If the provider can't be reached or serves no usable key, the cached keys are kept.
+// Keys are fetched one request at a time, without holding up tokens of cached keys.
+type JWKS struct {
+	url                string
+	maxAge             time.Duration
+	minRefreshInterval time.Duration
+	client             *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]any //by kid
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetching    chan struct{} //closed once the fetch in flight is done, nil if none
+}
+
+// NewJWKS returns the keys of the URL, cached for maxAge, an hour if 0.
+func NewJWKS(url string, maxAge time.Duration) *JWKS {
+	if maxAge <= 0 {
+		maxAge = defaultJWKSMaxAge
+	}
+	return &JWKS{
+		url:                url,
+		maxAge:             maxAge,
+		minRefreshInterval: jwksMinRefreshInterval,
+		client:             &http.Client{Timeout: jwksFetchTimeout},
+	}
+}
+
+// Keyfunc returns the key of the kid of the token, or the only key of the set for tokens without one.
+func (j *JWKS) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	key, found := j.lookup(kid)
+	if (!found || now.Sub(j.fetchedAt) >= j.maxAge) && j.fetching == nil && now.Sub(j.attemptedAt) >= j.minRefreshInterval {
+		j.attemptedAt = now
+		j.refresh(now)
+		key, found = j.lookup(kid)
+	} else if !found && j.fetching != nil { //the fetch in flight may bring the key
+		fetching := j.fetching
+		j.mu.Unlock()
+		<-fetching
+		j.mu.Lock()
+		key, found = j.lookup(kid)
+	}
+	if !found {
+		return nil, fmt.Errorf("Unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (j *JWKS) lookup(kid string) (any, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, found := j.keys[kid]
+	return key, found
+}
+
+// refresh fetches the keys and swaps them in, unless the fetch fails or brings no usable key.
+// It's called with j.mu held, which is released while fetching. Other callers wait on j.fetching meanwhile.
+func (j *JWKS) refresh(now time.Time) {
+	fetching := make(chan struct{})
+	j.fetching = fetching
+	j.mu.Unlock()
+	keys, err := j.fetch()
+	j.mu.Lock()
+	j.fetching = nil
+	close(fetching)
+
+	switch {
+	case err != nil:
+		log.Warnf("Failed to fetch JWKS, keeping the cached keys: %v", err)
+	case len(keys) == 0:
+		log.Warn("Fetched JWKS has no usable key, keeping the cached keys")
+	default:
+		j.keys = keys
+		j.fetchedAt = now
+	}
+}
+
+// fetch returns the signing keys of the set at the URL, by kid
+func (j *JWKS) fetch() (map[string]any, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("Invalid JWKS. %v", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warnf("Skipping key %q of JWKS: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// jwk is a public key of a JWKS (RFC 7517), of the RSA, EC or OKP (Ed25519) type
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("Invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if _, err := key.ECDH(); err != nil { //checks the point is on the curve
+			return nil, fmt.Errorf("Invalid EC key. %v", err)
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("Unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(encoded string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("Invalid key parameter")
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
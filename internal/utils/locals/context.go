@@ -1,19 +1,110 @@
 package locals
 
 import (
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
 const (
-	UserIdKey = "userID"
+	UserIdKey         = "userID"
+	TokenExpiresAtKey = "tokenExpiresAt"
+	TokenIdKey        = "tokenID"
+	TokenRolesKey     = "tokenRoles"
+	OrgIdKey          = "orgID"
+	OrgRoleKey        = "orgRole"
+	APIKeyIdKey       = "apiKeyID"
+	APIKeyScopesKey   = "apiKeyScopes"
+	APIKeyOrgIdKey    = "apiKeyOrgID"
+	AuditTargetKey    = "auditTarget"
 )
 
+// GetUserID returns the user the request is authenticated as, nil if it isn't.
 func GetUserID(c *fiber.Ctx) uuid.UUID {
-	userID, _ := uuid.Parse(c.Locals(UserIdKey).(string))
+	userIDStr, _ := c.Locals(UserIdKey).(string)
+	userID, _ := uuid.Parse(userIDStr)
 	return userID
 }
 
 func SetUserID(c *fiber.Ctx, userID string) {
 	c.Locals(UserIdKey, userID)
 }
+
+// GetTokenExpiresAt returns when the token the request was authenticated with expires, zero if it doesn't.
+func GetTokenExpiresAt(c *fiber.Ctx) time.Time {
+	expiresAt, _ := c.Locals(TokenExpiresAtKey).(time.Time)
+	return expiresAt
+}
+
+func SetTokenExpiresAt(c *fiber.Ctx, expiresAt time.Time) {
+	c.Locals(TokenExpiresAtKey, expiresAt)
+}
+
+// GetTokenID returns what the JWT the request was authenticated with is revoked by, its jti or its session, empty if none.
+func GetTokenID(c *fiber.Ctx) string {
+	tokenID, _ := c.Locals(TokenIdKey).(string)
+	return tokenID
+}
+
+func SetTokenID(c *fiber.Ctx, tokenID string) {
+	c.Locals(TokenIdKey, tokenID)
+}
+
+// GetTokenRoles returns the system roles carried by the JWT the request was authenticated with, if any.
+func GetTokenRoles(c *fiber.Ctx) []string {
+	roles, _ := c.Locals(TokenRolesKey).([]string)
+	return roles
+}
+
+func SetTokenRoles(c *fiber.Ctx, roles []string) {
+	c.Locals(TokenRolesKey, roles)
+}
+
+// GetOrgID returns the organization of the workspace of the request, nil for the user's personal workspace.
+func GetOrgID(c *fiber.Ctx) uuid.UUID {
+	orgID, _ := c.Locals(OrgIdKey).(uuid.UUID)
+	return orgID
+}
+
+// GetOrgRole returns the role of the user in the organization of the request, empty if none.
+func GetOrgRole(c *fiber.Ctx) string {
+	role, _ := c.Locals(OrgRoleKey).(string)
+	return role
+}
+
+func SetOrg(c *fiber.Ctx, orgID uuid.UUID, role string) {
+	c.Locals(OrgIdKey, orgID)
+	c.Locals(OrgRoleKey, role)
+}
+
+// SetAPIKey records that the request is authenticated by the API key with the scopes, of the organization if not nil.
+func SetAPIKey(c *fiber.Ctx, keyID uuid.UUID, scopes []string, orgID uuid.UUID) {
+	c.Locals(APIKeyIdKey, keyID)
+	c.Locals(APIKeyScopesKey, scopes)
+	c.Locals(APIKeyOrgIdKey, orgID)
+}
+
+// GetAPIKey returns the scopes and the organization of the API key the request is authenticated by, ok false if it isn't.
+func GetAPIKey(c *fiber.Ctx) (scopes []string, orgID uuid.UUID, ok bool) {
+	scopes, ok = c.Locals(APIKeyScopesKey).([]string)
+	orgID, _ = c.Locals(APIKeyOrgIdKey).(uuid.UUID)
+	return scopes, orgID, ok
+}
+
+// GetAPIKeyID returns the API key the request is authenticated by, nil if it isn't.
+func GetAPIKeyID(c *fiber.Ctx) uuid.UUID {
+	keyID, _ := c.Locals(APIKeyIdKey).(uuid.UUID)
+	return keyID
+}
+
+// GetAuditTarget returns the target of the audited action of the request, as set by its handler, empty if not set.
+func GetAuditTarget(c *fiber.Ctx) string {
+	target, _ := c.Locals(AuditTargetKey).(string)
+	return target
+}
+
+// SetAuditTarget sets the target of the audited action of the request, for targets which aren't in its params, as created by it.
+func SetAuditTarget(c *fiber.Ctx, target string) {
+	c.Locals(AuditTargetKey, target)
+}
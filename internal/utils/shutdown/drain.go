@@ -0,0 +1,32 @@
+package shutdown
+
+import "sync"
+
+// Drain signals that the server is shutting down, for long-lived requests to end, and new work to be refused.
+type Drain struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func NewDrain() *Drain {
+	return &Drain{done: make(chan struct{})}
+}
+
+// Start marks the server as draining. Calling it more than once is harmless.
+func (d *Drain) Start() {
+	d.once.Do(func() { close(d.done) })
+}
+
+// Done is closed once draining starts
+func (d *Drain) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *Drain) IsDraining() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
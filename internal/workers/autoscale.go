@@ -0,0 +1,194 @@
+package workers
+
+import (
+	"expvar"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/infrastructure/config"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	scalingInterval          = 10 * time.Second
+	defaultScaleUpCooldown   = 30 * time.Second
+	defaultScaleDownCooldown = 2 * time.Minute
+	defaultTargetQueueWait   = time.Minute
+
+	// Pools not saved within this are of stopped processes
+	WorkerPoolStaleAfter = 3 * scalingInterval
+)
+
+// Latest state of the pool of the process, served by expvar
+var latestPool atomic.Pointer[models.WorkerPool]
+
+func init() {
+	expvar.Publish("worker_pool", expvar.Func(func() any {
+		return latestPool.Load()
+	}))
+}
+
+// ScalingConfig bounds the number of workers of a process. Min and Max are equal for a fixed number of workers.
+type ScalingConfig struct {
+	Min               int
+	Max               int
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+	TargetQueueWait   time.Duration //workers are added for queued jobs to start within this
+}
+
+func scalingConfigFromEnv() ScalingConfig {
+	workerConfig := config.Env.WorkerConfig
+	scaling := ScalingConfig{
+		Min:               workerConfig.MinConcurrency,
+		Max:               workerConfig.MaxConcurrency,
+		ScaleUpCooldown:   time.Duration(workerConfig.ScaleUpCooldownSeconds) * time.Second,
+		ScaleDownCooldown: time.Duration(workerConfig.ScaleDownCooldownSeconds) * time.Second,
+		TargetQueueWait:   time.Duration(workerConfig.TargetQueueWaitSeconds) * time.Second,
+	}
+	if scaling.Max <= 0 { //fixed
+		concurrency := workerConfig.Concurrency
+		if concurrency <= 0 {
+			concurrency = defaultConcurrency
+		}
+		scaling.Min, scaling.Max = concurrency, concurrency
+	}
+	if scaling.Min <= 0 {
+		scaling.Min = 1
+	}
+	scaling.Max = max(scaling.Max, scaling.Min)
+	if scaling.ScaleUpCooldown <= 0 {
+		scaling.ScaleUpCooldown = defaultScaleUpCooldown
+	}
+	if scaling.ScaleDownCooldown <= 0 {
+		scaling.ScaleDownCooldown = defaultScaleDownCooldown
+	}
+	if scaling.TargetQueueWait <= 0 {
+		scaling.TargetQueueWait = defaultTargetQueueWait
+	}
+	return scaling
+}
+
+// scalingInput is what the number of workers is decided on
+type scalingInput struct {
+	workers        int
+	busy           int
+	queued         int //jobs waiting in the queue, for all processes
+	consumers      int //processes receiving jobs
+	runnable       int //jobs received by this process, which could be started right away
+	avgJobDuration time.Duration
+}
+
+// desiredWorkers returns enough workers for the jobs in progress, and for the share of the queued jobs
+// of this process to be started within the target queue wait, given the average job duration.
+func (c ScalingConfig) desiredWorkers(in scalingInput) int {
+	// Other processes take their share of the queue
+	queued := in.runnable + ceilDiv(in.queued, max(in.consumers, 1))
+	jobsPerWorker := 1 //until the duration of jobs is known
+	if in.avgJobDuration > 0 {
+		jobsPerWorker = max(1, int(c.TargetQueueWait/in.avgJobDuration))
+	}
+	desired := in.busy + ceilDiv(queued, jobsPerWorker)
+	return min(max(desired, c.Min), c.Max)
+}
+
+// scaler decides on the number of workers, scaling up at once, and down one worker at a time, within cooldowns.
+type scaler struct {
+	config       ScalingConfig
+	lastScaledAt time.Time
+}
+
+func (s *scaler) decide(in scalingInput, now time.Time) (workers int, decision string) {
+	desired := s.config.desiredWorkers(in)
+	load := fmt.Sprintf("%d jobs queued, %d received, %d of %d workers busy", in.queued, in.runnable, in.busy, in.workers)
+	switch {
+	case desired > in.workers && now.Sub(s.lastScaledAt) < s.config.ScaleUpCooldown:
+		return in.workers, fmt.Sprintf("holding at %d, in cooldown to scale up to %d: %s", in.workers, desired, load)
+	case desired > in.workers:
+		s.lastScaledAt = now
+		return desired, fmt.Sprintf("scaled up from %d to %d: %s", in.workers, desired, load)
+	case desired < in.workers && now.Sub(s.lastScaledAt) < s.config.ScaleDownCooldown:
+		return in.workers, fmt.Sprintf("holding at %d, in cooldown to scale down to %d: %s", in.workers, desired, load)
+	case desired < in.workers:
+		s.lastScaledAt = now
+		return in.workers - 1, fmt.Sprintf("scaled down from %d to %d: %s", in.workers, in.workers-1, load)
+	}
+	return in.workers, fmt.Sprintf("holding at %d: %s", in.workers, load)
+}
+
+// supervise resizes the pool of workers every scalingInterval, and saves its state until stop is closed.
+func (w *Worker) supervise(scaling ScalingConfig, stop <-chan struct{}) {
+	defer w.running.Done()
+	process := processName()
+	defer func() {
+		if err := models.DeleteWorkerPool(w.db, process); err != nil {
+			log.Errorf("Failed to delete worker pool state: %v", err)
+		}
+	}()
+
+	scaler := &scaler{config: scaling, lastScaledAt: time.Now()}
+	ticker := time.NewTicker(scalingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		queued, consumers, err := w.logQueue.QueueDepth()
+		if err != nil {
+			log.Errorf("Failed to get queue depth, not scaling workers: %v", err)
+			continue
+		}
+		_, runnable := w.scheduler.stats()
+		w.mutex.Lock()
+		in := scalingInput{
+			workers:        len(w.workerStops),
+			busy:           int(w.busy.Load()),
+			queued:         queued,
+			consumers:      consumers,
+			runnable:       runnable,
+			avgJobDuration: w.avgJobDuration,
+		}
+		w.mutex.Unlock()
+
+		target, decision := scaler.decide(in, time.Now())
+		if target != in.workers {
+			log.Info("Workers ", decision)
+		}
+		for count := in.workers; count < target; count++ {
+			w.addWorker()
+		}
+		for count := in.workers; count > target; count-- {
+			w.removeWorker()
+		}
+
+		pool := &models.WorkerPool{
+			Process:       process,
+			Workers:       target,
+			MinWorkers:    scaling.Min,
+			MaxWorkers:    scaling.Max,
+			BusyWorkers:   in.busy,
+			AvgJobSeconds: in.avgJobDuration.Seconds(),
+			LastDecision:  decision,
+			LastScaledAt:  scaler.lastScaledAt,
+		}
+		if err := pool.Save(w.db); err != nil {
+			log.Errorf("Failed to save worker pool state: %v", err)
+		}
+		latestPool.Store(pool)
+	}
+}
+
+func processName() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
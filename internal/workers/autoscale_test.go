@@ -0,0 +1,54 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDesiredWorkers(t *testing.T) {
+	scaling := ScalingConfig{Min: 2, Max: 10, TargetQueueWait: time.Minute}
+
+	tests := []struct {
+		name string
+		in   scalingInput
+		want int
+	}{
+		{"idle keeps the min", scalingInput{workers: 4, consumers: 1}, 2},
+		{"a worker per queued job, until the duration of jobs is known", scalingInput{workers: 2, busy: 2, queued: 1, runnable: 2, consumers: 1}, 5},
+		{"queued jobs shared by the workers that can start them in time", scalingInput{workers: 2, busy: 2, queued: 6, consumers: 1, avgJobDuration: 20 * time.Second}, 4},
+		{"long jobs need a worker each", scalingInput{workers: 2, busy: 2, queued: 3, consumers: 1, avgJobDuration: 5 * time.Minute}, 5},
+		{"share of the queue of this process only", scalingInput{workers: 2, busy: 2, queued: 8, consumers: 4}, 4},
+		{"bounded by the max", scalingInput{workers: 8, busy: 8, queued: 100, consumers: 1}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, scaling.desiredWorkers(tt.in))
+		})
+	}
+}
+
+func TestScalerCooldowns(t *testing.T) {
+	now := time.Now()
+	scaler := &scaler{
+		config:       ScalingConfig{Min: 1, Max: 10, ScaleUpCooldown: 30 * time.Second, ScaleDownCooldown: 2 * time.Minute, TargetQueueWait: time.Minute},
+		lastScaledAt: now,
+	}
+	busy := scalingInput{workers: 2, busy: 2, queued: 4, consumers: 1}
+
+	workers, _ := scaler.decide(busy, now.Add(10*time.Second))
+	assert.Equal(t, 2, workers, "should not scale up within the cooldown")
+
+	now = now.Add(30 * time.Second)
+	workers, _ = scaler.decide(busy, now)
+	assert.Equal(t, 6, workers, "should scale up at once")
+
+	idle := scalingInput{workers: 6, consumers: 1}
+	workers, _ = scaler.decide(idle, now.Add(time.Minute))
+	assert.Equal(t, 6, workers, "should not scale down within the cooldown")
+
+	now = now.Add(2 * time.Minute)
+	workers, _ = scaler.decide(idle, now)
+	assert.Equal(t, 5, workers, "should scale down one worker at a time")
+}
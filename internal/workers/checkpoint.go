@@ -0,0 +1,176 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log-flow/internal/domain/models"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+)
+
+const defaultCheckpointInterval = 30 * time.Second
+
+// processingCheckpoint is what gets persisted in models.JobCheckpoint.State.
+// A file processed without chunks is checkpointed as a single chunk spanning the whole file.
+type processingCheckpoint struct {
+	TotalSize int64             `json:"totalSize"`
+	Chunks    []chunkCheckpoint `json:"chunks"`
+}
+
+type chunkCheckpoint struct {
+	Start     int64       `json:"start"`
+	End       int64       `json:"end"`
+	LineStart int64       `json:"lineStart"` //-1 if the chunk had not found its first line yet
+	Metrics   *LogMetrics `json:"metrics"`
+}
+
+// saveCheckpoints persists the progress every checkpointInterval until stopChan is closed, then closes stopped.
+func (lp *LogProcessor) saveCheckpoints(stopped chan<- struct{}) {
+	defer close(stopped)
+
+	interval := lp.checkpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := lp.saveCheckpoint(); err != nil {
+				log.Errorf("Error saving checkpoint of job %s: %v", lp.jobID, err)
+			}
+		case <-lp.stopChan:
+			return
+		}
+	}
+}
+
+// saveCheckpoint persists the byte offsets reached and the metrics so far, unless nothing changed since the last save.
+func (lp *LogProcessor) saveCheckpoint() error {
+	checkpoint := lp.createCheckpoint()
+
+	var processedSize int64
+	for _, chunk := range checkpoint.Chunks {
+		processedSize += chunk.Metrics.ProcessedSize
+	}
+	if processedSize == lp.checkpointedSize {
+		return nil
+	}
+
+	// The IPs of the lines counted by the checkpoint were added before it was created, so they are saved
+	// by this flush. A resumed job doesn't read these lines again, and would miss their IPs otherwise.
+	if lp.ips != nil {
+		if err := lp.ips.flush(); err != nil {
+			return err
+		}
+	}
+
+	state, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %v", err)
+	}
+
+	jobCheckpoint := models.JobCheckpoint{
+		JobID: uuid.MustParse(lp.jobID),
+		State: state,
+	}
+	if err := jobCheckpoint.Save(lp.db); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+
+	lp.checkpointedSize = processedSize
+	log.Tracef("Checkpoint saved for job %s at %d bytes", lp.jobID, processedSize)
+	return nil
+}
+
+func (lp *LogProcessor) createCheckpoint() processingCheckpoint {
+	checkpoint := processingCheckpoint{TotalSize: lp.totalSize}
+
+	if lp.chunks == nil {
+		metrics := newLogMetrics()
+		lp.mutex.Lock()
+		metrics.Merge(lp.metrics)
+		lp.mutex.Unlock()
+
+		checkpoint.Chunks = []chunkCheckpoint{{Start: 0, End: math.MaxInt64, LineStart: 0, Metrics: metrics}}
+		return checkpoint
+	}
+
+	for _, chunk := range lp.chunks {
+		metrics := newLogMetrics()
+		chunk.mutex.Lock()
+		metrics.Merge(chunk.metrics)
+		lineStart := chunk.lineStart
+		chunk.mutex.Unlock()
+
+		checkpoint.Chunks = append(checkpoint.Chunks, chunkCheckpoint{
+			Start:     chunk.start,
+			End:       chunk.end,
+			LineStart: lineStart,
+			Metrics:   metrics,
+		})
+	}
+	return checkpoint
+}
+
+// restoreCheckpoint loads the checkpoint left by a previous attempt of the job, if any,
+// and reports whether processing resumes from it.
+func (lp *LogProcessor) restoreCheckpoint() (bool, error) {
+	jobCheckpoint, err := models.GetJobCheckpoint(lp.db, lp.jobID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get checkpoint: %v", err)
+	}
+	if jobCheckpoint == nil {
+		return false, nil
+	}
+
+	var checkpoint processingCheckpoint
+	if err := json.Unmarshal(jobCheckpoint.State, &checkpoint); err != nil {
+		return false, fmt.Errorf("failed to unmarshal checkpoint: %v", err)
+	}
+	if err := lp.applyCheckpoint(checkpoint); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (lp *LogProcessor) applyCheckpoint(checkpoint processingCheckpoint) error {
+	if len(checkpoint.Chunks) == 0 {
+		return fmt.Errorf("checkpoint has no chunks")
+	}
+	if checkpoint.TotalSize != lp.totalSize {
+		return fmt.Errorf("file size changed from %d to %d bytes", checkpoint.TotalSize, lp.totalSize)
+	}
+
+	var processedSize int64
+	chunks := make([]*logChunk, 0, len(checkpoint.Chunks))
+	for _, chunk := range checkpoint.Chunks {
+		metrics := newLogMetrics()
+		if chunk.Metrics != nil {
+			metrics.Merge(chunk.Metrics)
+		}
+		processedSize += metrics.ProcessedSize
+
+		chunks = append(chunks, &logChunk{
+			start:     chunk.Start,
+			end:       chunk.End,
+			lineStart: chunk.LineStart,
+			metrics:   metrics,
+		})
+	}
+
+	if len(chunks) == 1 {
+		lp.metrics = chunks[0].metrics
+	} else {
+		lp.chunks = chunks
+	}
+	lp.checkpointedSize = processedSize
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package workers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+const defaultChunkSize = 64 * 1024 * 1024 // 64MB
+
+// logChunk is a byte range of a log file, processed by its own goroutine with its own metrics.
+// A line belongs to the chunk in which it starts, so a chunk skips the partial line at its
+// start (processed by the previous chunk) and reads past its end to finish its last line.
+type logChunk struct {
+	start     int64 // first byte of the range
+	end       int64 // exclusive; lines starting at or after end belong to the next chunk
+	mutex     sync.Mutex
+	lineStart int64 // first byte of the first line of the chunk, -1 until known
+	metrics   *LogMetrics
+}
+
+// The metrics count every byte from the first line of the chunk, so this is where reading would continue from.
+// To be called with the mutex held.
+func (chunk *logChunk) offset() int64 {
+	return chunk.lineStart + chunk.metrics.ProcessedSize
+}
+
+// splitIntoChunks divides a file of totalSize bytes into chunks of chunkSize bytes.
+// The last chunk is left open ended, in case the file turns out to be longer than reported.
+func splitIntoChunks(totalSize, chunkSize int64) []*logChunk {
+	if totalSize <= 0 || chunkSize <= 0 {
+		return nil
+	}
+
+	chunks := make([]*logChunk, 0, (totalSize+chunkSize-1)/chunkSize)
+	for start := int64(0); start < totalSize; start += chunkSize {
+		chunks = append(chunks, &logChunk{
+			start:     start,
+			end:       start + chunkSize,
+			lineStart: -1,
+			metrics:   newLogMetrics(),
+		})
+	}
+	chunks[0].lineStart = 0
+	chunks[len(chunks)-1].end = math.MaxInt64
+
+	return chunks
+}
+
+// processChunks processes lp.chunks in parallel, at most lp.chunkConcurrency at a time.
+// The merged result is available through snapshotMetrics.
+func (lp *LogProcessor) processChunks(fileURL string) error {
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, lp.chunkConcurrency)
+		errChan   = make(chan error, len(lp.chunks))
+	)
+
+	for _, chunk := range lp.chunks {
+		wg.Add(1)
+		go func(chunk *logChunk) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if err := lp.processChunk(fileURL, chunk); err != nil {
+				errChan <- fmt.Errorf("chunk at byte %d: %v", chunk.start, err)
+			}
+		}(chunk)
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	return <-errChan //first error, if any
+}
+
+func (lp *LogProcessor) processChunk(fileURL string, chunk *logChunk) error {
+	chunk.mutex.Lock()
+	lineStartKnown := chunk.lineStart >= 0
+	readFrom := chunk.offset() //resuming from a checkpoint
+	chunk.mutex.Unlock()
+	if !lineStartKnown {
+		// Reading from one byte before the start tells whether the chunk starts on a line boundary.
+		// If it does, only that byte (the '\n' ending the previous chunk's last line) is skipped.
+		readFrom = chunk.start - 1
+	}
+	if readFrom >= chunk.end {
+		return nil //already done
+	}
+
+	logStream, err := lp.storage.StreamLogsFrom(fileURL, readFrom)
+	if err != nil {
+		return fmt.Errorf("failed to stream logs: %v", err)
+	}
+	defer logStream.Close()
+
+	reader := bufio.NewReaderSize(logStream, scanBufferSize)
+	offset := readFrom
+	if !lineStartKnown {
+		skipped, err := skipLine(reader)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to skip partial line: %v", err)
+		}
+		offset += skipped
+
+		chunk.mutex.Lock()
+		chunk.lineStart = offset
+		chunk.mutex.Unlock()
+		if err == io.EOF {
+			return nil
+		}
+	}
+
+	if err := lp.scanLogs(reader, &chunk.mutex, chunk.metrics, chunk.end-offset); err != nil {
+		return fmt.Errorf("failed to read log stream: %v", err)
+	}
+	return nil
+}
+
+// skipLine discards everything up to and including the next '\n', and returns the number of bytes discarded.
+func skipLine(reader *bufio.Reader) (int64, error) {
+	var skipped int64
+	for {
+		line, err := reader.ReadSlice('\n')
+		skipped += int64(len(line))
+		if err != bufio.ErrBufferFull {
+			return skipped, err
+		}
+	}
+}
@@ -1,82 +1,373 @@
 package workers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log-flow/internal/domain/models"
+	"log-flow/internal/infrastructure/config"
 	"log-flow/internal/infrastructure/queue"
 	"log-flow/internal/infrastructure/storage"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/streadway/amqp"
 	"gorm.io/gorm"
 )
 
-var ActiveJobs sync.Map
+const (
+	defaultConcurrency = 4
 
+	// How long interrupted jobs get to checkpoint their progress
+	interruptTimeout = 10 * time.Second
+
+	// Jobs are leased to workers, to limit the jobs of a user processed at once across processes
+	jobLeaseDuration = 2 * time.Minute
+	jobLeaseRenewal  = 30 * time.Second
+	heldBackDelay    = 5 * time.Second //before retrying to lease a job of a user at its limit
+)
+
+// Worker is the pool of workers of the process. A single consumer receives the jobs ahead,
+// for the scheduler to hand them to the workers fairly across users.
 type Worker struct {
 	db              *gorm.DB
 	resultQueue     queue.LiveStatusQueue
 	logQueue        queue.LogQueueReceiver
 	storage         storage.Storage
 	keyWordsToTrack []string
+	scheduler       *fairScheduler
+	maxJobsPerUser  int
+
+	mutex            sync.Mutex
+	consumerTag      string                //of the consumer receiving jobs, once started
+	workerStops      map[int]chan struct{} //closed to stop a worker, by worker ID
+	lastWorkerID     int
+	busy             atomic.Int32    //workers processing a job
+	avgJobDuration   time.Duration   //moving average, 0 until a job is done
+	supervisorStop   chan struct{}   //closed to stop the scaling supervisor, if started
+	stopping         bool            //no worker is to be added anymore
+	running          sync.WaitGroup  //consumer, workers and supervisor
+	processCtx       context.Context //cancelled to interrupt the jobs in progress
+	cancelProcessing context.CancelFunc
 }
 
 func NewWorkers(db *gorm.DB, storage storage.Storage, logQueue queue.LogQueueReceiver, progressQueue queue.LiveStatusQueue, keyWordsToTrack []string) *Worker {
+	quantum := int64(config.Env.WorkerConfig.SchedulingQuantumMB) * 1024 * 1024
+	if quantum <= 0 {
+		quantum = defaultSchedulingQuantum
+	}
+	maxJobsPerUser := max(config.Env.WorkerConfig.MaxJobsPerUser, 0)
+
+	processCtx, cancelProcessing := context.WithCancel(context.Background())
 	return &Worker{
-		db:              db,
-		resultQueue:     progressQueue,
-		logQueue:        logQueue,
-		storage:         storage,
-		keyWordsToTrack: keyWordsToTrack,
+		db:               db,
+		resultQueue:      progressQueue,
+		logQueue:         logQueue,
+		storage:          storage,
+		keyWordsToTrack:  keyWordsToTrack,
+		scheduler:        newFairScheduler(quantum, maxJobsPerUser),
+		maxJobsPerUser:   maxJobsPerUser,
+		workerStops:      make(map[int]chan struct{}),
+		processCtx:       processCtx,
+		cancelProcessing: cancelProcessing,
 	}
 }
 
+// Start starts the workers as configured: a fixed number of them, or autoscaled on the queue depth.
+// The state of the pool is saved periodically, for the queue status to show it.
+func (w *Worker) Start() {
+	scaling := scalingConfigFromEnv()
+	w.StartMany(scaling.Min)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.supervisorStop = make(chan struct{})
+	w.running.Add(1)
+	go w.supervise(scaling, w.supervisorStop)
+}
+
+// StartMany starts count workers, or the default count if not set
 func (w *Worker) StartMany(count int) {
+	if count <= 0 {
+		count = defaultConcurrency
+	}
 	fmt.Println("Starting all workers...")
-	for i := 1; i <= count; i++ {
-		go w.start(i)
+	w.startReceiving()
+	for i := 0; i < count; i++ {
+		w.addWorker()
 	}
 }
 
-func (w *Worker) start(workerID int) {
-	msgs, err := w.logQueue.RecieveLogFileDetails()
+// Count returns the number of running workers
+func (w *Worker) Count() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.workerStops)
+}
+
+// startReceiving starts the consumer of the jobs, if not started yet
+func (w *Worker) startReceiving() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.consumerTag != "" || w.stopping {
+		return
+	}
+	w.consumerTag = fmt.Sprintf("%s-scheduler", processName())
+	prefetch := config.Env.WorkerConfig.SchedulingBuffer
+	if prefetch <= 0 {
+		prefetch = defaultSchedulingBuffer
+	}
+
+	msgs, err := w.logQueue.RecieveLogFileDetails(w.consumerTag, prefetch)
 	if err != nil {
 		log.Fatalf("Failed to consume messages from RabbitMQ: %v", err)
 	}
+	w.running.Add(1)
+	go w.receive(msgs)
+}
 
+// receive hands the received jobs to the scheduler, until the consumer is stopped
+func (w *Worker) receive(msgs <-chan amqp.Delivery) {
+	defer w.running.Done()
 	for msg := range msgs {
-
-		log.Debug("✅log recieved by worker:", workerID)
 		var logMsg queue.LogMessage
 		if err := json.Unmarshal(msg.Body, &logMsg); err != nil {
 			log.Errorf("❌ Failed to unmarshal message: %v", err)
 			//marshalling errors are not supposed to be happen, and not meaningful to retry. Hence, directly sending to failed queue (for manual inspection, if required)
-			w.logQueue.SendToFailedQueue(msg) 
+			w.logQueue.SendToFailedQueue(msg)
+			msg.Ack(false)
 			continue
 		}
 
-		err:=models.AddFailAttemptForJob(w.db, logMsg.JobID)
-		if err != nil {
-			log.Errorf("❌ Failed to add attempt for job in database: %v", err)
-			w.logQueue.SentForRetry(msg)
-			continue
+		if logMsg.UserID == "" { //queued before messages carried it
+			job, err := models.GetJobByID(w.db, logMsg.JobID)
+			if err != nil {
+				log.Errorf("❌ Failed to get job from database: %v", err)
+				w.logQueue.SentForRetry(msg)
+				msg.Ack(false)
+				continue
+			}
+			logMsg.UserID = job.UserID.String()
 		}
 
-		logProcessor, err := NewLogProcessor(w.resultQueue, w.resultQueue, w.storage, w.db, w.keyWordsToTrack, logMsg.JobID)
-		if err != nil {
-			log.Errorf("❌ Failed to create log processor: %v", err)
-			w.logQueue.SentForRetry(msg)
-			continue
+		if !w.scheduler.push(scheduledJob{msg: msg, logMsg: logMsg}) {
+			msg.Nack(false, true)
+		}
+	}
+
+	// Jobs received but not started are left to other processes
+	for _, job := range w.scheduler.close() {
+		job.msg.Nack(false, true)
+	}
+}
+
+func (w *Worker) addWorker() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopping {
+		return
+	}
+	w.lastWorkerID++
+	workerID := w.lastWorkerID
+	stop := make(chan struct{})
+	w.workerStops[workerID] = stop
+	w.running.Add(1)
+	go w.start(workerID, stop)
+}
+
+// removeWorker stops the latest started worker. It stops once done with its job in progress, if any.
+func (w *Worker) removeWorker() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	latest := 0
+	for workerID := range w.workerStops {
+		latest = max(latest, workerID)
+	}
+	if latest == 0 {
+		return
+	}
+	close(w.workerStops[latest])
+	delete(w.workerStops, latest)
+}
+
+// recordJobDuration updates the average duration of jobs, used to size the pool of workers
+func (w *Worker) recordJobDuration(duration time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.avgJobDuration == 0 {
+		w.avgJobDuration = duration
+		return
+	}
+	w.avgJobDuration = (w.avgJobDuration*4 + duration) / 5
+}
+
+// Shutdown stops taking new jobs, and waits for the jobs in progress to finish until ctx is done.
+// Jobs still in progress then are interrupted: their progress is checkpointed, a cancelled status is sent
+// to their subscribers, and their messages are requeued for another worker to resume them.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mutex.Lock()
+	w.stopping = true
+	if w.supervisorStop != nil {
+		close(w.supervisorStop)
+		w.supervisorStop = nil
+	}
+	if w.consumerTag != "" {
+		if err := w.logQueue.StopReceiving(w.consumerTag); err != nil {
+			log.Errorf("Failed to stop consumer %s: %v", w.consumerTag, err)
+		}
+	}
+	for workerID, stop := range w.workerStops {
+		close(stop)
+		delete(w.workerStops, workerID)
+	}
+	w.mutex.Unlock()
+	for _, job := range w.scheduler.close() {
+		job.msg.Nack(false, true)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+	}
+
+	w.cancelProcessing()
+	select {
+	case <-stopped:
+	case <-time.After(interruptTimeout):
+		return fmt.Errorf("workers didn't stop in time")
+	}
+	return fmt.Errorf("jobs in progress were interrupted")
+}
+
+func (w *Worker) start(workerID int, stop <-chan struct{}) {
+	defer w.running.Done()
+
+	for {
+		job, ok := w.scheduler.next(stop)
+		if !ok {
+			return
 		}
 
-		err = logProcessor.ProcessLogFile(logMsg)
-		if err != nil {
-			log.Errorf("❌ Failed to process log file: %v", err)
-			w.logQueue.SentForRetry(msg)
+		leased, err := w.leaseJob(job.logMsg)
+		if errors.Is(err, models.ErrJobNotFound) { //held back forever otherwise
+			log.Errorf("❌ Job %s not found, sending it to the failed queue", job.logMsg.JobID)
+			w.logQueue.SendToFailedQueue(job.msg)
+			job.msg.Ack(false)
+			w.scheduler.done(job.logMsg.UserID)
 			continue
+		} else if err != nil {
+			log.Errorf("❌ Failed to lease job %s, processing it anyway: %v", job.logMsg.JobID, err)
+		} else if !leased { //the user is at its limit in other processes
+			w.scheduler.holdBack(job, time.Now().Add(heldBackDelay))
+			continue
+		}
+
+		w.busy.Add(1)
+		started := time.Now()
+		stopRenewing := w.renewLease(job.logMsg.JobID)
+		if w.handleMessage(workerID, job.msg, job.logMsg) {
+			job.msg.Nack(false, true)
+		} else {
+			job.msg.Ack(false)
+			w.recordJobDuration(time.Since(started))
+		}
+		stopRenewing()
+		w.busy.Add(-1)
+		w.scheduler.done(job.logMsg.UserID)
+	}
+}
+
+// leaseJob leases the job to this process, unless its user is at its limit of jobs in progress.
+// Jobs are always leased when there's no limit.
+func (w *Worker) leaseJob(logMsg queue.LogMessage) (bool, error) {
+	if w.maxJobsPerUser == 0 {
+		return true, nil
+	}
+	now := time.Now()
+	return models.ClaimJobLease(w.db, logMsg.JobID, logMsg.UserID, w.maxJobsPerUser, now.Add(jobLeaseDuration), now)
+}
+
+// renewLease keeps the lease of the job while it's processed, then releases it
+func (w *Worker) renewLease(jobID string) (release func()) {
+	if w.maxJobsPerUser == 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(jobLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := models.RenewJobLease(w.db, jobID, time.Now().Add(jobLeaseDuration)); err != nil {
+					log.Errorf("Failed to renew lease of job %s: %v", jobID, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-renewed
+		if err := models.ReleaseJobLease(w.db, jobID); err != nil {
+			log.Errorf("Failed to release lease of job %s: %v", jobID, err)
 		}
+	}
+}
+
+// handleMessage processes a message, and reports whether it's to be requeued as is, rather than acked.
+// Failures are acked too, once the message is sent for retry (or to the failed queue) as a new message.
+func (w *Worker) handleMessage(workerID int, msg amqp.Delivery, logMsg queue.LogMessage) (requeue bool) {
+	log.Debug("✅log recieved by worker:", workerID)
 
-		log.Trace("✅ @Received message from RabbitMQ by worker(%d)..: %s\n", workerID, logMsg.FileURL)
+	err := models.AddFailAttemptForJob(w.db, logMsg.JobID)
+	if err != nil {
+		log.Errorf("❌ Failed to add attempt for job in database: %v", err)
+		w.logQueue.SentForRetry(msg)
+		return false
 	}
+
+	keyWordsToTrack := w.keyWordsToTrack
+	if len(logMsg.Keywords) > 0 {
+		keyWordsToTrack = logMsg.Keywords
+	}
+	logProcessor, err := NewLogProcessor(w.resultQueue, w.resultQueue, w.storage, w.db, keyWordsToTrack, logMsg.JobID, logMsg.UserID)
+	if err != nil {
+		log.Errorf("❌ Failed to create log processor: %v", err)
+		w.logQueue.SentForRetry(msg)
+		return false
+	}
+	logProcessor.lastAttempt = queue.IsLastAttempt(msg)
+
+	err = logProcessor.ProcessLogFile(w.processCtx, logMsg)
+	if errors.Is(err, ErrInterrupted) {
+		log.Infof("Job %s interrupted, requeueing it", logMsg.JobID)
+		if err := models.UndoFailAttemptForJob(w.db, logMsg.JobID); err != nil {
+			log.Errorf("❌ Failed to uncount interrupted attempt of job: %v", err)
+		}
+		return true
+	}
+	if err != nil {
+		log.Errorf("❌ Failed to process log file: %v", err)
+		w.logQueue.SentForRetry(msg)
+		return false
+	}
+
+	log.Trace("✅ @Received message from RabbitMQ by worker(%d)..: %s\n", workerID, logMsg.FileURL)
+	return false
 }
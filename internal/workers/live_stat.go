@@ -3,22 +3,16 @@ package workers
 import (
 	"encoding/json"
 	"fmt"
+	"log-flow/internal/utils/hyperloglog"
+	"log-flow/pkg/liveprogress"
 
 	"github.com/gofiber/fiber/v2/log"
 )
 
-type LogLiveStats struct {
-	JobID              string         `json:"jobID"`
-	Progress           float64        `json:"progressInPercentage"`
-	UniqueIPs          int            `json:"uniqueIPs"`
-	InvalidLogs        int            `json:"invalidLogs"`
-	TotalLogsProcessed int            `json:"totalLogsProcessed"`
-	Status             string         `json:"status"` //Started, In Progress, Completed
-	LogLevelCounts     map[string]int `json:"logLevelCounts"`
-	KeyWordCounts      map[string]int `json:"keyWordCounts"`
-}
+// LogLiveStats is the payload of live progress messages, defined for clients in the liveprogress package.
+type LogLiveStats = liveprogress.Stats
 
-func (lls *LogLiveStats) GetMessage() (string, error) {
+func getMessage(lls *LogLiveStats) (string, error) {
 	message, err := json.Marshal(lls)
 	if err != nil {
 		log.Errorf("Error marshalling live stats: %v", err)
@@ -28,12 +22,35 @@ func (lls *LogLiveStats) GetMessage() (string, error) {
 }
 
 type LogMetrics struct {
-	ProcessedSize int64
-	LogsProcessed int
-	InvalidLogs   int
-	ErrorCount    int
-	WarnCount     int
-	InfoCount     int
-	UniqueIPs      map[string]struct{}
-	KeyWordsCount map[string]int
+	ProcessedSize int64               `json:"processedSize"`
+	LogsProcessed int                 `json:"logsProcessed"`
+	InvalidLogs   int                 `json:"invalidLogs"`
+	ErrorCount    int                 `json:"errorCount"`
+	WarnCount     int                 `json:"warnCount"`
+	InfoCount     int                 `json:"infoCount"`
+	UniqueIPs     *hyperloglog.Sketch `json:"uniqueIPs"` //bounded memory, however many IPs the file has
+	KeyWordsCount map[string]int      `json:"keyWordsCount"`
+}
+
+func newLogMetrics() *LogMetrics {
+	return &LogMetrics{
+		UniqueIPs:     hyperloglog.New(),
+		KeyWordsCount: make(map[string]int),
+	}
+}
+
+// Merge adds the counts of other into lm. IPs seen by both are counted once.
+func (lm *LogMetrics) Merge(other *LogMetrics) {
+	lm.ProcessedSize += other.ProcessedSize
+	lm.LogsProcessed += other.LogsProcessed
+	lm.InvalidLogs += other.InvalidLogs
+	lm.ErrorCount += other.ErrorCount
+	lm.WarnCount += other.WarnCount
+	lm.InfoCount += other.InfoCount
+	if other.UniqueIPs != nil {
+		lm.UniqueIPs.Merge(other.UniqueIPs)
+	}
+	for keyword, count := range other.KeyWordsCount {
+		lm.KeyWordsCount[keyword] += count
+	}
 }
@@ -2,6 +2,9 @@ package workers
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log-flow/internal/domain/models"
@@ -9,9 +12,11 @@ import (
 	"log-flow/internal/infrastructure/queue"
 	"log-flow/internal/infrastructure/storage"
 	"log-flow/internal/utils/helper"
+	"log-flow/pkg/liveprogress"
 	"math"
-	"strings"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2/log"
@@ -19,18 +24,33 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	scanBufferSize = 64 * 1024
+	maxLogLineSize = 1024 * 1024
+)
+
 type LogProcessor struct {
-	liveStatusQueue queue.LiveStatusQueueSession
-	storage         storage.Storage
-	db              *gorm.DB
-	keyWordsToTrack []string
-	metrics         *LogMetrics
-	stopChan        chan struct{}
-	mutex           sync.Mutex
-	jobID           string
-	totalSize       int64
-	status          string
-	mockProcessLag  bool
+	liveStatusQueue    *queue.LiveStatusQueueSession
+	watches            queue.LiveStatusQueue //progress is only sent for watched jobs
+	storage            storage.Storage
+	db                 *gorm.DB
+	keyWordsToTrack    []string
+	metrics            *LogMetrics
+	ips                *ipRecorder //distinct IPs of the job, saved as they are found. Nil to only count them
+	chunks             []*logChunk //set only when the file is processed in parallel chunks
+	stopChan           chan struct{}
+	mutex              sync.Mutex
+	jobID              string
+	userID             string
+	totalSize          int64
+	chunkSize          int64
+	chunkConcurrency   int
+	checkpointInterval time.Duration
+	checkpointedSize   int64 //processed size in the last saved checkpoint
+	status             string
+	interrupted        atomic.Bool //set to stop processing midway, see ErrInterrupted
+	lastAttempt        bool        //no retry after this attempt, if it fails
+	mockProcessLag     bool
 }
 
 func NewLogProcessor(
@@ -40,44 +60,98 @@ func NewLogProcessor(
 	db *gorm.DB,
 	keyWordsToTrack []string,
 	jobID string,
+	userID string,
 ) (*LogProcessor, error) {
 
-	queueSession, err := progressMessenger.StartQueue(jobID)
+	queueSession, err := progressMessenger.StartQueue(jobID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("Error starting live stats queue: %v", err)
 	}
 
+	chunkSize := int64(config.Env.LogConfig.ChunkSizeMB) * 1024 * 1024
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunkConcurrency := config.Env.LogConfig.ChunkConcurrency
+	if chunkConcurrency <= 0 {
+		chunkConcurrency = runtime.NumCPU()
+	}
+
 	return &LogProcessor{
-		liveStatusQueue: *queueSession,
-		storage:         storage,
-		db:              db,
-		keyWordsToTrack: keyWordsToTrack,
-		stopChan:        make(chan struct{}),
-		jobID:           jobID,
-		mockProcessLag:  config.Dev.SimulateLogProcessingLagMs > 0, //Development purpose
-		metrics: &LogMetrics{
-			UniqueIPs:     make(map[string]struct{}),
-			KeyWordsCount: make(map[string]int),
-		},
+		liveStatusQueue:    queueSession,
+		watches:            progressMessenger,
+		storage:            storage,
+		db:                 db,
+		keyWordsToTrack:    keyWordsToTrack,
+		stopChan:           make(chan struct{}),
+		jobID:              jobID,
+		userID:             userID,
+		chunkSize:          chunkSize,
+		chunkConcurrency:   chunkConcurrency,
+		checkpointInterval: time.Duration(config.Env.LogConfig.CheckpointIntervalSeconds) * time.Second,
+		mockProcessLag:     config.Dev.SimulateLogProcessingLagMs > 0, //Development purpose
+		metrics:            newLogMetrics(),
+		ips:                newIPRecorder(db, uuid.MustParse(jobID)),
 	}, nil
 }
 
-func (lp *LogProcessor) ProcessLogFile(logMessage queue.LogMessage) error {
+// ErrInterrupted is returned by ProcessLogFile when its context is cancelled midway.
+// The progress is checkpointed, for the job to be resumed.
+var ErrInterrupted = errors.New("processing interrupted")
+
+func (lp *LogProcessor) ProcessLogFile(ctx context.Context, logMessage queue.LogMessage) error {
 	start := time.Now()
+	stopInterrupting := context.AfterFunc(ctx, func() { lp.interrupted.Store(true) })
+	defer stopInterrupting()
 	fileURL := logMessage.FileURL
 
-	logStream, err := lp.storage.StreamLogs(fileURL)
+	lp.totalSize, _ = lp.storage.GetFileSize(fileURL)
+	resumed, err := lp.restoreCheckpoint()
 	if err != nil {
-		return fmt.Errorf("Failed to stream logs: %v", err)
+		log.Warnf("Ignoring checkpoint of job %s: %v", lp.jobID, err)
+	}
+	if resumed {
+		log.Debug("Resuming job ", lp.jobID, " from checkpoint")
+		if err := lp.ips.resume(); err != nil {
+			return err
+		}
+	} else {
+		if chunks := splitIntoChunks(lp.totalSize, lp.chunkSize); len(chunks) > 1 {
+			lp.chunks = chunks
+		}
+		// IPs saved by a previous attempt not resumed from would be counted without their lines
+		if err := models.DeleteJobIPs(lp.db, uuid.MustParse(lp.jobID)); err != nil {
+			return fmt.Errorf("Failed to delete IPs of previous attempt: %v", err)
+		}
 	}
-	defer logStream.Close()
-
-	lp.totalSize, _ = lp.storage.GetFileSize(fileURL)
-	go lp.sendLiveUpdates()
 
-	lp.processLogs(logStream)
+	liveUpdatesStopped := make(chan struct{})
+	go lp.sendLiveUpdates(liveUpdatesStopped)
+	checkpointsStopped := make(chan struct{})
+	go lp.saveCheckpoints(checkpointsStopped)
 
+	if lp.chunks != nil {
+		err = lp.processChunks(fileURL)
+	} else {
+		err = lp.processSequentially(fileURL)
+	}
+	if err != nil && lp.interrupted.Load() {
+		err = ErrInterrupted
+		lp.status = liveprogress.StatusCancelled
+	} else if err != nil {
+		lp.status = liveprogress.StatusFailed
+	}
 	close(lp.stopChan)
+	<-checkpointsStopped
+	<-liveUpdatesStopped //the last status is sent, cancelled ones before the message is requeued
+
+	if err != nil {
+		// Keep what is done so far, for the retry to resume from
+		if checkpointErr := lp.saveCheckpoint(); checkpointErr != nil {
+			log.Errorf("Error saving checkpoint of job %s: %v", lp.jobID, checkpointErr)
+		}
+		return fmt.Errorf("Failed to process log file: %w", err)
+	}
 
 	err = lp.SaveFinalMetrics()
 	if err != nil {
@@ -90,87 +164,191 @@ func (lp *LogProcessor) ProcessLogFile(logMessage queue.LogMessage) error {
 
 	timeTaken := time.Now().Sub(start)
 	log.Debug("Time taken to process logs: ", timeTaken)
-	lp.liveStatusQueue.Delete()
 	return nil
 }
 
-func (lp *LogProcessor) processLogs(logStream io.ReadCloser) {
-	scanner := bufio.NewScanner(logStream)
-	for scanner.Scan() {
-		logEntry := scanner.Text()
+// processSequentially processes the whole file (or the rest of it, when resuming) in a single stream
+func (lp *LogProcessor) processSequentially(fileURL string) error {
+	lp.mutex.Lock()
+	offset := lp.metrics.ProcessedSize
+	lp.mutex.Unlock()
+
+	logStream, err := lp.storage.StreamLogsFrom(fileURL, offset)
+	if err != nil {
+		return fmt.Errorf("Failed to stream logs: %v", err)
+	}
+	defer logStream.Close()
+
+	return lp.processLogs(logStream)
+}
+
+func (lp *LogProcessor) processLogs(logStream io.ReadCloser) error {
+	if err := lp.scanLogs(logStream, &lp.mutex, lp.metrics, math.MaxInt64); err != nil {
+		log.Errorf("Error reading log stream: %v", err)
+		return fmt.Errorf("Error reading log stream: %w", err)
+	}
+	return nil
+}
+
+// scanLogs accumulates the lines of logStream into metrics, holding mutex while updating them.
+// It stops before the first line starting at or beyond limit bytes of the stream.
+func (lp *LogProcessor) scanLogs(logStream io.Reader, mutex *sync.Mutex, metrics *LogMetrics, limit int64) error {
+	keyWords := make([][]byte, len(lp.keyWordsToTrack))
+	for i, keyword := range lp.keyWordsToTrack {
+		keyWords[i] = []byte(keyword)
+	}
+
+	var (
+		consumed int64
+		longLine []byte //reused for the lines longer than the read buffer
+	)
+	reader := bufio.NewReaderSize(logStream, scanBufferSize) //the reader itself if it's a bufio.Reader already
+	for consumed < limit {
+		if lp.interrupted.Load() { //the next line is left unread, to be read when resuming
+			return ErrInterrupted
+		}
+		rawLine, size, err := readLine(reader, &longLine)
+		if err != nil && err != io.EOF {
+			// A line cut short by an error is left uncounted, to be read again when resuming from a checkpoint
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		consumed += size
+
+		if rawLine == nil {
+			log.Warnf("Skipping line of %d bytes of job %s, longer than %d bytes", size, lp.jobID, maxLogLineSize)
+			mutex.Lock()
+			metrics.InvalidLogs++
+			metrics.LogsProcessed++
+			metrics.ProcessedSize += size
+			mutex.Unlock()
+			if err == io.EOF {
+				return nil
+			}
+			continue
+		}
+		logEntry := helper.TrimLineEnding(rawLine)
 
-		lp.mutex.Lock()
-		logLevel, logPayload, ip, parseErr := helper.ExtractLogDetails(logEntry)
+		mutex.Lock()
+		parsed, parseErr := helper.ParseLogLine(logEntry)
 		if parseErr != nil {
 			log.Tracef("Parsing Error: %v", parseErr)
-			lp.metrics.InvalidLogs++
+			metrics.InvalidLogs++
 		}
 
-		for _, keyword := range lp.keyWordsToTrack {
-			if strings.Contains(logPayload, keyword) {
-				lp.metrics.KeyWordsCount[keyword]++
+		for i, keyword := range keyWords {
+			if bytes.Contains(parsed.Message, keyword) {
+				metrics.KeyWordsCount[lp.keyWordsToTrack[i]]++
 			}
 		}
 
-		lp.metrics.LogsProcessed++
-		lp.metrics.ProcessedSize += int64(len(logEntry) + 1)
+		metrics.LogsProcessed++
+		metrics.ProcessedSize += int64(len(rawLine))
 
-		switch logLevel {
-		case "ERROR":
-			lp.metrics.ErrorCount++
-		case "WARN":
-			lp.metrics.WarnCount++
-		case "INFO":
-			lp.metrics.InfoCount++
+		switch parsed.Level {
+		case helper.LogLevelError:
+			metrics.ErrorCount++
+		case helper.LogLevelWarn:
+			metrics.WarnCount++
+		case helper.LogLevelInfo:
+			metrics.InfoCount++
 		}
 
-		if ip != "" {
-			if _, ok := lp.metrics.UniqueIPs[ip]; !ok {
-				lp.metrics.UniqueIPs[ip] = struct{}{}
-			}
+		flushIPs := false
+		if ip := helper.ExtractJSONStringField(parsed.Message, "ip"); len(ip) != 0 {
+			metrics.UniqueIPs.Add(ip)
+			// Added while holding mutex, so that a checkpoint counting the line flushes its IP (see saveCheckpoint)
+			flushIPs = lp.ips != nil && lp.ips.add(ip)
 		}
 
-		lp.mutex.Unlock()
+		mutex.Unlock()
+		if flushIPs {
+			if err := lp.ips.flush(); err != nil {
+				return err
+			}
+		}
 		if lp.mockProcessLag {
 			time.Sleep(time.Millisecond * time.Duration(config.Dev.SimulateLogProcessingLagMs))
 		}
+		if err == io.EOF {
+			return nil
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Errorf("Error reading log stream: %v", err)
+	return nil
+}
+
+// readLine reads the next line of reader, with its line ending, and returns its size. The last line may have
+// none, read with io.EOF. Lines longer than maxLogLineSize are skipped: the line returned is nil then.
+// longLine is a buffer for the lines longer than the one of reader, reused across calls.
+func readLine(reader *bufio.Reader, longLine *[]byte) (line []byte, size int64, err error) {
+	line, err = reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, int64(len(line)), err
+	}
+
+	*longLine = append((*longLine)[:0], line...)
+	for {
+		line, err = reader.ReadSlice('\n')
+		if len(*longLine)+len(line) > maxLogLineSize {
+			size = int64(len(*longLine) + len(line))
+			if err == bufio.ErrBufferFull {
+				var skipped int64
+				skipped, err = skipLine(reader)
+				size += skipped
+			}
+			return nil, size, err
+		}
+		*longLine = append(*longLine, line...)
+		if err != bufio.ErrBufferFull {
+			return *longLine, int64(len(*longLine)), err
+		}
 	}
 }
 
-func (lp *LogProcessor) sendLiveUpdates() {
+func (lp *LogProcessor) sendLiveUpdates(stopped chan<- struct{}) {
+	defer close(stopped)
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	// Status changes are sent even with no websocket listening, for the feeds of all of a user's jobs
+	started := LogLiveStats{
+		JobID:          lp.jobID,
+		Progress:       lp.calculateProgress(lp.snapshotMetrics()),
+		Status:         liveprogress.StatusStarted,
+		LogLevelCounts: map[string]int{},
+		KeyWordCounts:  map[string]int{},
+	}
+	if strMessage, err := getMessage(&started); err == nil {
+		lp.liveStatusQueue.SendIntermediateResult(strMessage)
+	}
+
 	for {
 		select {
 		case <-ticker.C:
-			if _, ok := ActiveJobs.Load(lp.jobID); !ok {
+			if !lp.watches.IsWatched(lp.jobID, lp.userID) {
 				log.Trace("No websockets listening for job: %v", lp.jobID)
 				continue
 			}
-			lp.mutex.Lock()
-			progress := lp.calculateProgress()
+			metrics := lp.snapshotMetrics()
 			stats := LogLiveStats{
 				JobID:              lp.jobID,
-				Progress:           progress,
-				UniqueIPs:          len(lp.metrics.UniqueIPs),
-				InvalidLogs:        lp.metrics.InvalidLogs,
-				TotalLogsProcessed: lp.metrics.LogsProcessed,
+				Progress:           lp.calculateProgress(metrics),
+				UniqueIPs:          int(metrics.UniqueIPs.Count()),
+				InvalidLogs:        metrics.InvalidLogs,
+				TotalLogsProcessed: metrics.LogsProcessed,
 				LogLevelCounts: map[string]int{
-					"error": lp.metrics.ErrorCount,
-					"warn":  lp.metrics.WarnCount,
-					"info":  lp.metrics.InfoCount,
+					liveprogress.LogLevelError: metrics.ErrorCount,
+					liveprogress.LogLevelWarn:  metrics.WarnCount,
+					liveprogress.LogLevelInfo:  metrics.InfoCount,
 				},
-				KeyWordCounts: helper.GetMapCopy(lp.metrics.KeyWordsCount),
-				Status:        "In Progress",
+				KeyWordCounts: metrics.KeyWordsCount,
+				Status:        liveprogress.StatusInProgress,
 			}
-			lp.mutex.Unlock()
 
-			strMessage, err := stats.GetMessage()
+			strMessage, err := getMessage(&stats)
 			if err != nil {
 				log.Errorf("Error marshalling live stats: %v", err)
 				continue
@@ -179,59 +357,87 @@ func (lp *LogProcessor) sendLiveUpdates() {
 			lp.liveStatusQueue.SendIntermediateResult(strMessage)
 
 		case <-lp.stopChan:
-			if _, ok := ActiveJobs.Load(lp.jobID); !ok {
-				log.Trace("No websockets listening for job: %v", lp.jobID)
-				return
+			// Sent even with no websocket listening, as the final status ends the subscriptions to the job
+			metrics := lp.snapshotMetrics()
+			status, progress, final := liveprogress.StatusCompleted, float64(100), true
+			switch {
+			case lp.status == liveprogress.StatusCancelled: //interrupted, to be resumed
+				status, progress, final = liveprogress.StatusCancelled, lp.calculateProgress(metrics), false
+			case lp.status == liveprogress.StatusFailed:
+				status, progress, final = liveprogress.StatusFailed, lp.calculateProgress(metrics), lp.lastAttempt
+				if !lp.lastAttempt {
+					status = liveprogress.StatusRetrying
+				}
 			}
-			lp.mutex.Lock()
 			stats := LogLiveStats{
 				JobID:              lp.jobID,
-				Progress:           100,
-				UniqueIPs:          len(lp.metrics.UniqueIPs),
-				InvalidLogs:        lp.metrics.InvalidLogs,
-				TotalLogsProcessed: lp.metrics.LogsProcessed,
+				Progress:           progress,
+				UniqueIPs:          int(metrics.UniqueIPs.Count()),
+				InvalidLogs:        metrics.InvalidLogs,
+				TotalLogsProcessed: metrics.LogsProcessed,
 				LogLevelCounts: map[string]int{
-					"error": lp.metrics.ErrorCount,
-					"warn":  lp.metrics.WarnCount,
-					"info":  lp.metrics.InfoCount,
+					liveprogress.LogLevelError: metrics.ErrorCount,
+					liveprogress.LogLevelWarn:  metrics.WarnCount,
+					liveprogress.LogLevelInfo:  metrics.InfoCount,
 				},
-				KeyWordCounts: helper.GetMapCopy(lp.metrics.KeyWordsCount),
-				Status:        "Completed",
+				KeyWordCounts: metrics.KeyWordsCount,
+				Status:        status,
 			}
-			lp.mutex.Unlock()
 
-			strMessage, err := stats.GetMessage()
+			strMessage, err := getMessage(&stats)
 			if err != nil {
 				log.Errorf("Error marshalling live stats: %v", err)
-				continue
+				return
 			}
 
-			lp.liveStatusQueue.SendIntermediateResult(strMessage)
+			if final {
+				lp.liveStatusQueue.SendFinalResult(strMessage)
+			} else {
+				lp.liveStatusQueue.SendIntermediateResult(strMessage)
+			}
 			return
 		}
 	}
 }
 
-func (lp *LogProcessor) calculateProgress() float64 {
-	return math.Round(min((float64(lp.metrics.ProcessedSize)/float64(lp.totalSize))*100, 100))
+func (lp *LogProcessor) calculateProgress(metrics *LogMetrics) float64 {
+	return math.Round(min((float64(metrics.ProcessedSize)/float64(lp.totalSize))*100, 100))
 }
 
-func (lp *LogProcessor) SaveFinalMetrics() error {
+// snapshotMetrics returns a copy of the metrics so far, merged across all chunks.
+func (lp *LogProcessor) snapshotMetrics() *LogMetrics {
+	snapshot := newLogMetrics()
+
 	lp.mutex.Lock()
-	defer lp.mutex.Unlock()
-	logReport := models.LogReport{
-		JobID:                uuid.MustParse(lp.jobID),
-		TotalLogs:            lp.metrics.LogsProcessed,
-		ErrorCount:           lp.metrics.ErrorCount,
-		WarnCount:            lp.metrics.WarnCount,
-		InfoCount:            lp.metrics.InfoCount,
-		UniqueIPs:            len(lp.metrics.UniqueIPs),
-		TrackedKeywordsCount: helper.GetMapCopy(lp.metrics.KeyWordsCount),
-		InvalidLogs:          lp.metrics.InvalidLogs,
-		CreatedAt:            time.Now(),
-	}
-
-	err := logReport.Create(lp.db)
+	snapshot.Merge(lp.metrics)
+	lp.mutex.Unlock()
+
+	for _, chunk := range lp.chunks {
+		chunk.mutex.Lock()
+		snapshot.Merge(chunk.metrics)
+		chunk.mutex.Unlock()
+	}
+	return snapshot
+}
+
+func (lp *LogProcessor) SaveFinalMetrics() error {
+	metrics := lp.snapshotMetrics()
+
+	// The distinct IPs are counted exactly once saved, otherwise the report keeps the estimate of the sketch
+	logReport, err := lp.ips.reportIPs(metrics.UniqueIPs)
+	if err != nil {
+		return err
+	}
+	logReport.JobID = uuid.MustParse(lp.jobID)
+	logReport.TotalLogs = metrics.LogsProcessed
+	logReport.ErrorCount = metrics.ErrorCount
+	logReport.WarnCount = metrics.WarnCount
+	logReport.InfoCount = metrics.InfoCount
+	logReport.TrackedKeywordsCount = metrics.KeyWordsCount
+	logReport.InvalidLogs = metrics.InvalidLogs
+	logReport.CreatedAt = time.Now()
+
+	err = logReport.Create(lp.db)
 	if err != nil {
 		return err
 	}
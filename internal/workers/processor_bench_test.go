@@ -0,0 +1,96 @@
+package workers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log-flow/internal/utils/helper"
+	"strings"
+	"testing"
+)
+
+var benchmarkLines = []string{
+	`[2025-02-20T10:00:00Z] INFO Server started`,
+	`[2025-02-20T10:01:15Z] DEBUG Initializing database connection`,
+	`[2025-02-20T10:02:30Z] INFO User login successful {"userId": 101, "ip": "192.168.1.%d"}`,
+	`[2025-02-20T10:03:50Z] WARN High memory usage detected (78%%)`,
+	`[2025-02-20T10:05:23Z] ERROR Database timeout {"userId": 123, "ip": "10.0.%d.1"}`,
+	`[2025-02-20T10:07:10Z] INFO API request received {"method": "GET", "endpoint": "/orders", "userId": %d}`,
+	`[2025-02-20T10:09:30Z] ERROR Payment gateway unreachable {"error": "Connection refused", "orderId": %d}`,
+	`Invalid log line %d`,
+}
+
+var benchmarkKeywords = []string{"error", "timeout", "failure", "unauthorized"}
+
+// benchmarkLogFile builds an in-memory log file of roughly the given size.
+func benchmarkLogFile(size int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		line := benchmarkLines[i%len(benchmarkLines)]
+		if strings.Contains(line, "%d") {
+			line = fmt.Sprintf(line, i%250)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Compare with BenchmarkProcessLogsRegexp for the MB/s gain of the hand-written parser:
+//
+//	go test ./internal/workers -run '^$' -bench ProcessLogs -benchmem
+func BenchmarkProcessLogs(b *testing.B) {
+	data := benchmarkLogFile(8 * 1024 * 1024)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		processor := &LogProcessor{
+			keyWordsToTrack: benchmarkKeywords,
+			metrics:         newLogMetrics(),
+		}
+		processor.processLogs(io.NopCloser(bytes.NewReader(data)))
+	}
+}
+
+// BenchmarkProcessLogsRegexp runs the previous line processing loop, built on helper.ExtractLogDetails and an IP set.
+func BenchmarkProcessLogsRegexp(b *testing.B) {
+	data := benchmarkLogFile(8 * 1024 * 1024)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		metrics := newLogMetrics()
+		uniqueIPs := make(map[string]struct{})
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			logEntry := scanner.Text()
+			logLevel, logPayload, ip, parseErr := helper.ExtractLogDetails(logEntry)
+			if parseErr != nil {
+				metrics.InvalidLogs++
+			}
+			for _, keyword := range benchmarkKeywords {
+				if strings.Contains(logPayload, keyword) {
+					metrics.KeyWordsCount[keyword]++
+				}
+			}
+			metrics.LogsProcessed++
+			metrics.ProcessedSize += int64(len(logEntry) + 1)
+			switch logLevel {
+			case "ERROR":
+				metrics.ErrorCount++
+			case "WARN":
+				metrics.WarnCount++
+			case "INFO":
+				metrics.InfoCount++
+			}
+			if ip != "" {
+				uniqueIPs[ip] = struct{}{}
+			}
+		}
+	}
+}
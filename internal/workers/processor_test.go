@@ -2,12 +2,25 @@ package workers
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
+	"log-flow/internal/domain/models"
 	"log-flow/internal/infrastructure/config"
 	"log-flow/internal/utils/helper"
+	"log-flow/internal/utils/hyperloglog"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func init() {
@@ -127,7 +140,7 @@ func TestProcessLogs(t *testing.T) {
 				mockProcessLag:  tt.mockProcessLag,
 				metrics: &LogMetrics{
 					KeyWordsCount: make(map[string]int),
-					UniqueIPs:     make(map[string]struct{}),
+					UniqueIPs:     hyperloglog.New(),
 				},
 			}
 
@@ -140,13 +153,33 @@ func TestProcessLogs(t *testing.T) {
 			assert.Equal(t, tt.want.warnCount, processor.metrics.WarnCount, "warn count mismatch")
 			assert.Equal(t, tt.want.infoCount, processor.metrics.InfoCount, "info count mismatch")
 			assert.Equal(t, tt.want.invalidLogs, processor.metrics.InvalidLogs, "invalid logs count mismatch")
-			assert.Equal(t, tt.want.uniqueIPs, len(processor.metrics.UniqueIPs), "unique IPs count mismatch")
+			assert.Equal(t, tt.want.uniqueIPs, int(processor.metrics.UniqueIPs.Count()), "unique IPs count mismatch")
 			assert.EqualValues(t, tt.want.keywordCounts, processor.metrics.KeyWordsCount, "Keyword count mismatch")
 
 		})
 	}
 }
 
+func TestProcessLogsSkipsOversizedLines(t *testing.T) {
+	var logBuffer bytes.Buffer
+	logBuffer.WriteString("[2025-02-20T10:05:23Z] ERROR Database timeout {\"ip\": \"192.168.1.1\"}\n")
+	logBuffer.WriteString("[2025-02-20T10:05:24Z] WARN Huge payload " + strings.Repeat("x", 2*maxLogLineSize) + "\n")
+	logBuffer.WriteString("[2025-02-20T10:05:25Z] INFO Long but kept " + strings.Repeat("y", 2*scanBufferSize) + "\n")
+	logBuffer.WriteString("[2025-02-20T10:05:26Z] ERROR Database timeout {\"ip\": \"192.168.1.2\"}")
+	data := logBuffer.Bytes()
+
+	processor := &LogProcessor{keyWordsToTrack: []string{"timeout"}, metrics: newLogMetrics()}
+	assert.NoError(t, processor.processLogs(io.NopCloser(bytes.NewReader(data))), "an oversized line shouldn't fail the job")
+	assert.Equal(t, 4, processor.metrics.LogsProcessed)
+	assert.Equal(t, 1, processor.metrics.InvalidLogs, "the oversized line should be counted as invalid")
+	assert.Equal(t, 0, processor.metrics.WarnCount, "the oversized line should be skipped")
+	assert.Equal(t, 1, processor.metrics.InfoCount, "lines longer than the read buffer should be parsed")
+	assert.Equal(t, 2, processor.metrics.ErrorCount)
+	assert.Equal(t, 2, processor.metrics.KeyWordsCount["timeout"])
+	assert.Equal(t, 2, int(processor.metrics.UniqueIPs.Count()))
+	assert.Equal(t, int64(len(data)), processor.metrics.ProcessedSize, "skipped bytes should count as processed")
+}
+
 func TestProcessLogsWithLogStreamError(t *testing.T) { //simulate error in log stream
 	// Create a mock reader that returns an error
 	errReader := &errorReader{err: io.ErrUnexpectedEOF}
@@ -155,7 +188,7 @@ func TestProcessLogsWithLogStreamError(t *testing.T) { //simulate error in log s
 		keyWordsToTrack: []string{"test"},
 		metrics: &LogMetrics{
 			KeyWordsCount: make(map[string]int),
-			UniqueIPs:     make(map[string]struct{}),
+			UniqueIPs:     hyperloglog.New(),
 		},
 	}
 
@@ -165,11 +198,103 @@ func TestProcessLogsWithLogStreamError(t *testing.T) { //simulate error in log s
 	assert.Equal(t, 0, processor.metrics.WarnCount, "no warnings should be counted when reader errors")
 	assert.Equal(t, 0, processor.metrics.InfoCount, "no info logs should be counted when reader errors")
 	assert.Equal(t, 0, processor.metrics.InvalidLogs, "no invalid logs should be counted when reader errors")
-	assert.Equal(t, 0, len(processor.metrics.UniqueIPs), "no unique IPs should be counted when reader errors")
+	assert.Equal(t, 0, int(processor.metrics.UniqueIPs.Count()), "no unique IPs should be counted when reader errors")
 	assert.EqualValues(t, 0, len(processor.metrics.KeyWordsCount), "no keywords should be counted when reader errors")
 
 }
 
+// newTestDB returns a fresh database with the tables of jobs and their reports
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Job{}, &models.LogReport{}, &models.JobIP{}, &models.TrackedKeywordsCount{}, &models.JobCheckpoint{}))
+	return db
+}
+
+func TestPersistUniqueIPs(t *testing.T) { //as processing and the backfill of reports save them
+	db := newTestDB(t)
+
+	// Past the IPs a sketch counts exactly, and overlapping across jobs
+	logFile := func(from, to int) []byte {
+		var logBuffer bytes.Buffer
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&logBuffer, "[2025-02-20T10:05:23Z] ERROR Database timeout {\"ip\": \"10.0.%d.%d\"}\n", i/256, i%256)
+			fmt.Fprintf(&logBuffer, "[2025-02-20T10:05:24Z] INFO Retried {\"ip\": \"10.0.%d.%d\"}\n", i/256, i%256)
+		}
+		return logBuffer.Bytes()
+	}
+	userID := uuid.New()
+	uploadedAt := []time.Time{time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC)}
+	for i, data := range [][]byte{logFile(0, 5000), logFile(3000, 6000)} {
+		job := models.Job{ID: uuid.New(), UserID: userID, FileURL: "file.log"}
+		require.NoError(t, job.Create(db))
+		require.NoError(t, db.Model(&job).Update("uploaded_at", uploadedAt[i]).Error)
+
+		report, err := PersistUniqueIPs(db, job.ID, bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.True(t, report.IPsPersisted)
+		assert.NotEmpty(t, report.UniqueIPSketch)
+		report.JobID = job.ID
+		require.NoError(t, report.Create(db))
+	}
+
+	stats, err := models.GetWholeLogReportsAggregate(db, userID, models.ReportsFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 6000, stats.TotalUniqueIPs, "IPs of both jobs should be counted once")
+	assert.True(t, stats.UniqueIPsExact)
+
+	stats, err = models.GetWholeLogReportsAggregate(db, userID, models.ReportsFilter{From: uploadedAt[1]})
+	require.NoError(t, err)
+	assert.Equal(t, 3000, stats.TotalUniqueIPs, "only the IPs of jobs in the range should be counted")
+
+	_, err = PersistUniqueIPs(db, uuid.New(), &errorReader{err: io.ErrUnexpectedEOF})
+	assert.Error(t, err)
+}
+
+func TestJobsPastTheSavedIPsLimitKeepOnlyTheirSketch(t *testing.T) {
+	db := newTestDB(t)
+	logFile := func(from, to int) io.Reader {
+		var logBuffer bytes.Buffer
+		for i := from; i < to; i++ {
+			fmt.Fprintf(&logBuffer, "[2025-02-20T10:05:23Z] ERROR Database timeout {\"ip\": \"10.0.%d.%d\"}\n", i/256, i%256)
+		}
+		return &logBuffer
+	}
+	userID := uuid.New()
+	persist := func(data io.Reader, limit int64) models.LogReport {
+		job := models.Job{ID: uuid.New(), UserID: userID, FileURL: "file.log"}
+		require.NoError(t, job.Create(db))
+		ips := newIPRecorder(db, job.ID)
+		ips.limit = limit
+		report, err := persistUniqueIPs(ips, data)
+		require.NoError(t, err)
+		report.JobID = job.ID
+		require.NoError(t, report.Create(db))
+		return report
+	}
+
+	small := persist(logFile(0, 100), 1500)
+	assert.True(t, small.IPsPersisted)
+	large := persist(logFile(0, 2000), 1500)
+	assert.False(t, large.IPsPersisted, "the IPs of a job past the limit shouldn't be kept")
+	assert.Equal(t, 2000, large.UniqueIPs, "the sketch should count them")
+	count, err := models.CountJobIPs(db, large.JobID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	stats, err := models.GetWholeLogReportsAggregate(db, userID, models.ReportsFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 2000, stats.TotalUniqueIPs, "the sketches of the jobs should be merged, counting shared IPs once")
+	assert.True(t, stats.UniqueIPsExact, "sketches are exact up to hyperloglog.ExactLimit")
+
+	persist(logFile(2000, 5000), 1500)
+	stats, err = models.GetWholeLogReportsAggregate(db, userID, models.ReportsFilter{})
+	require.NoError(t, err)
+	assert.InDelta(t, 5000, stats.TotalUniqueIPs, 0.03*5000)
+	assert.False(t, stats.UniqueIPsExact)
+}
+
 // Mock reader that always returns an error
 type errorReader struct {
 	err error
@@ -178,3 +303,169 @@ type errorReader struct {
 func (r *errorReader) Read(p []byte) (n int, err error) {
 	return 0, r.err
 }
+
+func TestProcessChunksMatchesSequentialProcessing(t *testing.T) {
+	logs := []string{
+		`[2025-02-20T10:02:30Z] INFO User login successful {"userId": 101, "ip": "192.168.1.5"}`,
+		`[2025-02-20T10:03:50Z] WARN High memory usage detected (78%)`,
+		`[2025-02-20T10:05:23Z] ERROR Database timeout {"userId": 123, "ip": "192.168.1.1"}` + "\r",
+		"",
+		`Invalid log format`,
+		`[2025-02-20T10:09:30Z] ERROR Payment gateway unreachable {"error": "Connection refused", "orderId": 56789, "ip": "192.168.1.5"}`,
+		`[2025-02-20T10:17:00Z] ERROR Failed to send email {"recipient": "user@example.com", "error": "SMTP timeout", "ip": "10.0.0.7"}`,
+		`[2025-02-20T10:00:00Z] INFO Server started`,
+	}
+	keywords := []string{"timeout", "unreachable", "memory", "Server"}
+
+	for _, trailingNewline := range []bool{true, false} {
+		data := strings.Join(logs, "\n")
+		if trailingNewline {
+			data += "\n"
+		}
+
+		sequential := &LogProcessor{keyWordsToTrack: keywords, metrics: newLogMetrics()}
+		sequential.processLogs(io.NopCloser(strings.NewReader(data)))
+
+		for _, chunkSize := range []int64{1, 7, 40, 64, 100, 1000} {
+			t.Run(fmt.Sprintf("chunk size %d, trailing newline %v", chunkSize, trailingNewline), func(t *testing.T) {
+				processor := &LogProcessor{
+					storage:          &memoryStorage{data: []byte(data)},
+					keyWordsToTrack:  keywords,
+					metrics:          newLogMetrics(),
+					chunks:           splitIntoChunks(int64(len(data)), chunkSize),
+					chunkConcurrency: 3,
+				}
+
+				err := processor.processChunks("file.log")
+				assert.NoError(t, err)
+				assert.Equal(t, sequential.metrics, processor.snapshotMetrics())
+				assert.Equal(t, int64(len(data)), processor.snapshotMetrics().ProcessedSize, "every byte should be accounted for exactly once")
+			})
+		}
+	}
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	var logBuffer bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&logBuffer, "[2025-02-20T10:05:23Z] ERROR Database timeout {\"userId\": %d, \"ip\": \"10.0.0.%d\"}\n", i, i%50)
+		fmt.Fprintf(&logBuffer, "[2025-02-20T10:03:50Z] WARN High memory usage detected (%d%%)\n", i%100)
+	}
+	data := logBuffer.Bytes()
+	keywords := []string{"timeout", "memory"}
+
+	sequential := &LogProcessor{keyWordsToTrack: keywords, metrics: newLogMetrics()}
+	sequential.processLogs(io.NopCloser(bytes.NewReader(data)))
+
+	for _, chunkSize := range []int64{0, 1000, 3000} { //0: no chunks
+		for _, failAt := range []int64{1, 2500, 7000, int64(len(data)) - 1} {
+			t.Run(fmt.Sprintf("chunk size %d, fail at %d", chunkSize, failAt), func(t *testing.T) {
+				failing := &LogProcessor{
+					storage:          &memoryStorage{data: data, failAt: failAt},
+					keyWordsToTrack:  keywords,
+					metrics:          newLogMetrics(),
+					chunks:           splitIntoChunks(int64(len(data)), chunkSize),
+					totalSize:        int64(len(data)),
+					chunkConcurrency: 2,
+				}
+				if chunkSize == 0 {
+					assert.Error(t, failing.processSequentially("file.log"))
+				} else {
+					assert.Error(t, failing.processChunks("file.log"))
+				}
+
+				checkpoint := failing.createCheckpoint()
+				if failAt >= 2500 {
+					assert.Positive(t, failing.snapshotMetrics().ProcessedSize, "progress before the failure should be checkpointed")
+				}
+
+				state, err := json.Marshal(checkpoint)
+				assert.NoError(t, err)
+				var restored processingCheckpoint
+				assert.NoError(t, json.Unmarshal(state, &restored))
+
+				resumed := &LogProcessor{
+					storage:          &memoryStorage{data: data},
+					keyWordsToTrack:  keywords,
+					metrics:          newLogMetrics(),
+					totalSize:        int64(len(data)),
+					chunkConcurrency: 2,
+				}
+				assert.NoError(t, resumed.applyCheckpoint(restored))
+				if resumed.chunks == nil {
+					assert.NoError(t, resumed.processSequentially("file.log"))
+				} else {
+					assert.NoError(t, resumed.processChunks("file.log"))
+				}
+
+				assert.Equal(t, sequential.metrics, resumed.snapshotMetrics(), "no line should be lost or counted twice")
+			})
+		}
+	}
+}
+
+func TestResumeInterruptedProcessing(t *testing.T) { //as on shutdown
+	data := benchmarkLogFile(64 * 1024)
+	keywords := []string{"timeout", "memory"}
+
+	sequential := &LogProcessor{keyWordsToTrack: keywords, metrics: newLogMetrics()}
+	sequential.processLogs(io.NopCloser(bytes.NewReader(data)))
+
+	interrupted := &LogProcessor{keyWordsToTrack: keywords, metrics: newLogMetrics(), totalSize: int64(len(data))}
+	stream := &interruptingReader{reader: bytes.NewReader(data), after: 20 * 1024, interrupt: func() { interrupted.interrupted.Store(true) }}
+	err := interrupted.processLogs(io.NopCloser(stream))
+	assert.ErrorIs(t, err, ErrInterrupted)
+	processed := interrupted.snapshotMetrics().ProcessedSize
+	assert.Positive(t, processed, "lines read before the interruption should be counted")
+	assert.Less(t, processed, int64(len(data)), "lines read after the interruption should be left")
+
+	resumed := &LogProcessor{storage: &memoryStorage{data: data}, keyWordsToTrack: keywords, metrics: newLogMetrics(), totalSize: int64(len(data))}
+	assert.NoError(t, resumed.applyCheckpoint(interrupted.createCheckpoint()))
+	assert.NoError(t, resumed.processSequentially("file.log"))
+	assert.Equal(t, sequential.metrics, resumed.snapshotMetrics(), "no line should be lost or counted twice")
+}
+
+// Calls interrupt once more than after bytes are read
+type interruptingReader struct {
+	reader    io.Reader
+	after     int
+	read      int
+	interrupt func()
+}
+
+func (r *interruptingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p[:min(len(p), 1024)])
+	r.read += n
+	if r.read > r.after {
+		r.interrupt()
+	}
+	return n, err
+}
+
+// In-memory storage, supporting ranged reads
+type memoryStorage struct {
+	data   []byte
+	failAt int64 //if set, reads fail on reaching this offset
+}
+
+func (s *memoryStorage) UploadFile(fileHeader *multipart.FileHeader) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func (s *memoryStorage) StreamLogs(fileURL string) (io.ReadCloser, error) {
+	return s.StreamLogsFrom(fileURL, 0)
+}
+
+func (s *memoryStorage) StreamLogsFrom(fileURL string, offset int64) (io.ReadCloser, error) {
+	if s.failAt > 0 {
+		if offset >= s.failAt {
+			return io.NopCloser(&errorReader{err: io.ErrUnexpectedEOF}), nil
+		}
+		return io.NopCloser(io.MultiReader(bytes.NewReader(s.data[offset:s.failAt]), &errorReader{err: io.ErrUnexpectedEOF})), nil
+	}
+	return io.NopCloser(bytes.NewReader(s.data[offset:])), nil
+}
+
+func (s *memoryStorage) GetFileSize(fileURL string) (int64, error) {
+	return int64(len(s.data)), nil
+}
@@ -0,0 +1,247 @@
+package workers
+
+import (
+	"log-flow/internal/infrastructure/queue"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultSchedulingQuantum = 4 * 1024 * 1024
+	defaultSchedulingBuffer  = 100
+
+	// Cost of a job, in bytes, at the least. So that a flood of tiny files counts too.
+	minJobCost = 1024 * 1024
+)
+
+// scheduledJob is a received message, waiting for a worker
+type scheduledJob struct {
+	msg    amqp.Delivery
+	logMsg queue.LogMessage
+}
+
+func (j scheduledJob) cost() int64 {
+	return max(j.logMsg.FileSize, minJobCost)
+}
+
+type userJobs struct {
+	jobs    []scheduledJob
+	deficit int64 //bytes the user can still be served in the current round
+}
+
+// fairScheduler hands the received jobs to the workers fairly across users, by deficit round robin:
+// each round, every user with jobs waiting is credited a quantum of bytes, and is served jobs while
+// credited enough for them. Users uploading large files get fewer jobs per round than users uploading small ones,
+// and no user gets more than maxPerUser jobs in progress at once.
+type fairScheduler struct {
+	mutex      sync.Mutex
+	quantum    int64
+	maxPerUser int //0 for no limit
+
+	users     map[string]*userJobs
+	ring      []string //users with jobs waiting, in round robin order
+	current   int      //index in ring of the user being served
+	credited  bool     //whether the current user has been credited for this round
+	running   map[string]int
+	heldUntil map[string]time.Time //users whose jobs are held back, at their limit in other processes
+	pending   int
+	closed    bool
+	changed   chan struct{} //closed and replaced on every change, waking up the waiting workers
+}
+
+func newFairScheduler(quantum int64, maxPerUser int) *fairScheduler {
+	return &fairScheduler{
+		quantum:    quantum,
+		maxPerUser: maxPerUser,
+		users:      make(map[string]*userJobs),
+		running:    make(map[string]int),
+		heldUntil:  make(map[string]time.Time),
+		changed:    make(chan struct{}),
+	}
+}
+
+// push queues a received job. It reports false if the scheduler is closed, the job is then to be requeued.
+func (s *fairScheduler) push(job scheduledJob) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return false
+	}
+	user := s.userJobs(job.logMsg.UserID)
+	user.jobs = append(user.jobs, job)
+	s.pending++
+	s.notify()
+	return true
+}
+
+// next waits for a job to process, counted as in progress until done is called.
+// It returns false once the scheduler is closed, or stop is.
+func (s *fairScheduler) next(stop <-chan struct{}) (scheduledJob, bool) {
+	for {
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			return scheduledJob{}, false
+		}
+		job, ok := s.pop(time.Now())
+		changed, held := s.changed, len(s.heldUntil) > 0
+		s.mutex.Unlock()
+		if ok {
+			return job, true
+		}
+
+		var heldBack <-chan time.Time
+		if held {
+			heldBack = time.After(time.Second) //held back users may be served again
+		}
+		select {
+		case <-stop:
+			return scheduledJob{}, false
+		case <-changed:
+		case <-heldBack:
+		}
+	}
+}
+
+// done marks a job of the user as no longer in progress
+func (s *fairScheduler) done(userID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running[userID]--; s.running[userID] <= 0 {
+		delete(s.running, userID)
+	}
+	s.notify()
+}
+
+// holdBack puts back a job that couldn't be started, as its user is at its limit of jobs in progress
+// in other processes. The jobs of the user are held back until the given time.
+func (s *fairScheduler) holdBack(job scheduledJob, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	userID := job.logMsg.UserID
+	if s.running[userID]--; s.running[userID] <= 0 {
+		delete(s.running, userID)
+	}
+	if s.closed {
+		job.msg.Nack(false, true)
+		return
+	}
+	user := s.userJobs(userID)
+	user.jobs = append([]scheduledJob{job}, user.jobs...)
+	user.deficit += job.cost() //not served after all
+	s.pending++
+	s.heldUntil[userID] = until
+	s.notify()
+}
+
+// close stops handing out jobs, and returns the jobs still waiting, to be requeued.
+func (s *fairScheduler) close() []scheduledJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var waiting []scheduledJob
+	for _, userID := range s.ring {
+		waiting = append(waiting, s.users[userID].jobs...)
+	}
+	s.users, s.ring, s.pending = nil, nil, 0
+	s.notify()
+	return waiting
+}
+
+// stats returns the number of jobs waiting, and of those which could be started right away
+func (s *fairScheduler) stats() (pending int, runnable int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for _, userID := range s.ring {
+		if s.eligible(userID, now) {
+			runnable += len(s.users[userID].jobs)
+		}
+	}
+	return s.pending, runnable
+}
+
+func (s *fairScheduler) pop(now time.Time) (scheduledJob, bool) {
+	for userID, until := range s.heldUntil {
+		if !now.Before(until) {
+			delete(s.heldUntil, userID)
+		}
+	}
+
+	hasEligible := false
+	for _, userID := range s.ring {
+		if s.eligible(userID, now) {
+			hasEligible = true
+			break
+		}
+	}
+	if !hasEligible {
+		return scheduledJob{}, false
+	}
+
+	// Terminates, as every eligible user is credited a quantum per round
+	for {
+		userID := s.ring[s.current]
+		if s.eligible(userID, now) {
+			user := s.users[userID]
+			if !s.credited {
+				user.deficit += s.quantum
+				s.credited = true
+			}
+			if job := user.jobs[0]; job.cost() <= user.deficit {
+				user.deficit -= job.cost()
+				user.jobs = user.jobs[1:]
+				s.pending--
+				s.running[userID]++
+				if len(user.jobs) == 0 {
+					s.removeUser(s.current)
+				}
+				return job, true
+			}
+		}
+		s.current = (s.current + 1) % len(s.ring)
+		s.credited = false
+	}
+}
+
+func (s *fairScheduler) eligible(userID string, now time.Time) bool {
+	if s.maxPerUser > 0 && s.running[userID] >= s.maxPerUser {
+		return false
+	}
+	until, held := s.heldUntil[userID]
+	return !held || !now.Before(until)
+}
+
+// userJobs returns the jobs of the user, adding the user to the round robin if it had none waiting
+func (s *fairScheduler) userJobs(userID string) *userJobs {
+	user, ok := s.users[userID]
+	if !ok {
+		user = &userJobs{}
+		s.users[userID] = user
+		s.ring = append(s.ring, userID)
+	}
+	return user
+}
+
+// removeUser removes the user at index i of the round robin, once it has no job waiting
+func (s *fairScheduler) removeUser(i int) {
+	delete(s.users, s.ring[i])
+	s.ring = append(s.ring[:i], s.ring[i+1:]...)
+	if i < s.current {
+		s.current--
+	}
+	if s.current >= len(s.ring) {
+		s.current = 0
+	}
+	s.credited = false
+}
+
+func (s *fairScheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
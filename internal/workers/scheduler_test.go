@@ -0,0 +1,133 @@
+package workers
+
+import (
+	"fmt"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/infrastructure/queue"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pushJobs(s *fairScheduler, userID string, count int, fileSize int64) {
+	for i := 0; i < count; i++ {
+		s.push(scheduledJob{logMsg: queue.LogMessage{JobID: fmt.Sprintf("%s-%d", userID, i), UserID: userID, FileSize: fileSize}})
+	}
+}
+
+// popUsers pops count jobs, marking them done right away, and returns the users they are of
+func popUsers(s *fairScheduler, count int) []string {
+	var users []string
+	for i := 0; i < count; i++ {
+		job, ok := s.pop(time.Now())
+		if !ok {
+			break
+		}
+		s.done(job.logMsg.UserID)
+		users = append(users, job.logMsg.UserID)
+	}
+	return users
+}
+
+func TestFairSchedulerRoundRobinsUsers(t *testing.T) {
+	s := newFairScheduler(minJobCost, 0)
+	pushJobs(s, "flooding", 500, 10*1024)
+	pushJobs(s, "other", 2, 10*1024)
+
+	assert.Equal(t, []string{"flooding", "other", "flooding", "other", "flooding", "flooding"}, popUsers(s, 6),
+		"a user with many jobs shouldn't delay the jobs of others")
+}
+
+func TestFairSchedulerWeighsJobsByFileSize(t *testing.T) {
+	s := newFairScheduler(minJobCost, 0)
+	pushJobs(s, "large", 2, 4*minJobCost)
+	pushJobs(s, "small", 8, minJobCost)
+
+	assert.Equal(t, []string{"small", "small", "small", "large", "small", "small", "small", "small", "large"}, popUsers(s, 9),
+		"users should be served the same bytes per round")
+}
+
+func TestFairSchedulerLimitsJobsPerUser(t *testing.T) {
+	s := newFairScheduler(minJobCost, 2)
+	pushJobs(s, "flooding", 10, 0)
+	pushJobs(s, "other", 1, 0)
+
+	var users []string
+	for i := 0; i < 4; i++ {
+		if job, ok := s.pop(time.Now()); ok {
+			users = append(users, job.logMsg.UserID)
+		}
+	}
+	assert.Equal(t, []string{"flooding", "other", "flooding"}, users, "no more than 2 jobs of a user should be in progress")
+
+	s.done("flooding")
+	job, ok := s.pop(time.Now())
+	assert.True(t, ok, "a job done should free a slot of its user")
+	assert.Equal(t, "flooding", job.logMsg.UserID)
+
+	pending, runnable := s.stats()
+	assert.Equal(t, 7, pending)
+	assert.Equal(t, 0, runnable, "jobs of users at their limit can't be started")
+}
+
+func TestFairSchedulerHoldsBackUsers(t *testing.T) {
+	s := newFairScheduler(minJobCost, 0)
+	pushJobs(s, "limited", 1, 0)
+	pushJobs(s, "other", 1, 0)
+
+	job, _ := s.pop(time.Now())
+	assert.Equal(t, "limited", job.logMsg.UserID)
+	s.holdBack(job, time.Now().Add(time.Hour)) //at its limit in other processes
+
+	job, ok := s.pop(time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, "other", job.logMsg.UserID)
+	_, ok = s.pop(time.Now())
+	assert.False(t, ok, "held back users should be skipped")
+
+	job, ok = s.pop(time.Now().Add(2 * time.Hour))
+	assert.True(t, ok, "held back users should be served once the hold is over")
+	assert.Equal(t, "limited-0", job.logMsg.JobID)
+}
+
+func TestFairSchedulerCloseReturnsWaitingJobs(t *testing.T) {
+	s := newFairScheduler(minJobCost, 0)
+	pushJobs(s, "user", 3, 0)
+	popUsers(s, 1)
+
+	assert.Len(t, s.close(), 2)
+	assert.False(t, s.push(scheduledJob{}), "jobs received once closed should be requeued")
+	_, ok := s.next(make(chan struct{}))
+	assert.False(t, ok, "workers should stop once closed")
+}
+
+func TestJobLeasesLimitJobsPerUser(t *testing.T) { //across processes
+	db := newTestDB(t)
+	userID := uuid.NewString()
+	var jobIDs []string
+	for i := 0; i < 3; i++ {
+		job := models.Job{ID: uuid.New(), UserID: uuid.MustParse(userID), FileURL: "file.log"}
+		require.NoError(t, job.Create(db))
+		jobIDs = append(jobIDs, job.ID.String())
+	}
+	now := time.Now()
+	claim := func(jobID string) (bool, error) {
+		return models.ClaimJobLease(db, jobID, userID, 2, now.Add(jobLeaseDuration), now)
+	}
+
+	for i, want := range []bool{true, true, false} {
+		leased, err := claim(jobIDs[i])
+		require.NoError(t, err)
+		assert.Equal(t, want, leased, "job %d", i)
+	}
+	require.NoError(t, models.ReleaseJobLease(db, jobIDs[0]))
+	leased, err := claim(jobIDs[2])
+	require.NoError(t, err)
+	assert.True(t, leased, "a released lease should make room")
+
+	_, err = claim(uuid.NewString())
+	assert.ErrorIs(t, err, models.ErrJobNotFound, "missing jobs should be told apart from users at their limit")
+}
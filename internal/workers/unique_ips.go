@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"fmt"
+	"io"
+	"log-flow/internal/domain/models"
+	"log-flow/internal/utils/hyperloglog"
+	"math"
+	"sync"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// ipBatchSize is the number of distinct IPs buffered before they are saved
+	ipBatchSize = 1000
+
+	// maxSavedIPs is the number of distinct IPs of a job up to which they are saved. Jobs with more only keep
+	// the sketch of their report, so the saved IPs stay bounded per job however many IPs a file has.
+	maxSavedIPs = 100000
+)
+
+// ipRecorder saves the distinct IPs of a job to the database in batches, so memory stays bounded
+// however many IPs the file has. Saving an IP twice is a no-op, so lines read again when resuming don't matter.
+// Past its limit of distinct IPs, it stops saving them.
+type ipRecorder struct {
+	db      *gorm.DB
+	jobID   uuid.UUID
+	limit   int64
+	mutex   sync.Mutex
+	pending map[string]struct{}
+	saved   int64 //distinct IPs saved, none more once past limit
+}
+
+func newIPRecorder(db *gorm.DB, jobID uuid.UUID) *ipRecorder {
+	return &ipRecorder{db: db, jobID: jobID, limit: maxSavedIPs, pending: make(map[string]struct{})}
+}
+
+// add buffers the IP, and reports whether the buffer is full and should be flushed.
+func (r *ipRecorder) add(ip []byte) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.saved > r.limit {
+		return false
+	}
+	if _, ok := r.pending[string(ip)]; !ok {
+		r.pending[string(ip)] = struct{}{}
+	}
+	return len(r.pending) >= ipBatchSize
+}
+
+// flush saves the buffered IPs. Once it returns, every IP added before the call is saved.
+func (r *ipRecorder) flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	ips := make([]string, 0, len(r.pending))
+	for ip := range r.pending {
+		ips = append(ips, ip)
+	}
+	saved, err := models.SaveJobIPs(r.db, r.jobID, ips)
+	if err != nil {
+		return fmt.Errorf("failed to save IPs: %v", err)
+	}
+	r.saved += saved
+	clear(r.pending)
+	return nil
+}
+
+// resume counts the IPs saved by the previous attempt of the job, resumed from its checkpoint.
+func (r *ipRecorder) resume() error {
+	saved, err := models.CountJobIPs(r.db, r.jobID)
+	if err != nil {
+		return fmt.Errorf("failed to count IPs: %v", err)
+	}
+	r.mutex.Lock()
+	r.saved = saved
+	r.mutex.Unlock()
+	return nil
+}
+
+// reportIPs returns a report with the IP data of the job set: its sketch, and its count of distinct IPs,
+// exact if they are all saved, or else estimated by the sketch. The IPs of jobs past the limit are deleted.
+func (r *ipRecorder) reportIPs(uniqueIPs *hyperloglog.Sketch) (models.LogReport, error) {
+	sketch, err := uniqueIPs.MarshalBinary()
+	if err != nil {
+		return models.LogReport{}, fmt.Errorf("Error encoding unique IPs: %v", err)
+	}
+	report := models.LogReport{UniqueIPs: int(uniqueIPs.Count()), UniqueIPSketch: sketch}
+
+	if err := r.flush(); err != nil {
+		log.Errorf("Error saving IPs of job %s: %v", r.jobID, err)
+	} else if r.saved > r.limit {
+		if err := models.DeleteJobIPs(r.db, r.jobID); err != nil {
+			log.Errorf("Error deleting IPs of job %s: %v", r.jobID, err)
+		}
+	} else if count, err := models.CountJobIPs(r.db, r.jobID); err != nil {
+		log.Errorf("Error counting IPs of job %s: %v", r.jobID, err)
+	} else {
+		report.UniqueIPs, report.IPsPersisted = int(count), true
+	}
+	return report, nil
+}
+
+// PersistUniqueIPs reads a whole log stream of the job and saves its distinct IPs, as processing it would.
+// It returns a report with the IP data of the job set, as processing would save it.
+func PersistUniqueIPs(db *gorm.DB, jobID uuid.UUID, logStream io.Reader) (models.LogReport, error) {
+	return persistUniqueIPs(newIPRecorder(db, jobID), logStream)
+}
+
+func persistUniqueIPs(ips *ipRecorder, logStream io.Reader) (models.LogReport, error) {
+	var (
+		lp      = LogProcessor{ips: ips}
+		mutex   sync.Mutex
+		metrics = newLogMetrics()
+	)
+	if err := models.DeleteJobIPs(ips.db, ips.jobID); err != nil {
+		return models.LogReport{}, fmt.Errorf("failed to delete IPs: %v", err)
+	}
+	if err := lp.scanLogs(logStream, &mutex, metrics, math.MaxInt64); err != nil {
+		return models.LogReport{}, err
+	}
+	return ips.reportIPs(metrics.UniqueIPs)
+}
@@ -0,0 +1,140 @@
+// Package liveprogress defines the messages of the live progress WebSockets, for clients to import:
+//
+//	/api/live-stats/:jobID  progress of a job, until it ends
+//	/api/live-stats         status changes of all the jobs of the user, and progress of the jobs subscribed to
+//
+// Every message from the server is an Envelope. Its Type tells what its Payload holds.
+// Clients of the user feed send Requests to subscribe to the progress of jobs.
+package liveprogress
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version of the protocol, sent in every Envelope. Incremented on breaking changes.
+const Version = 1
+
+type EventType string
+
+const (
+	EventSnapshot  EventType = "snapshot"  //Stats, the latest status of the job when subscribing to it
+	EventProgress  EventType = "progress"  //Stats, including status changes (Started, Retrying)
+	EventCompleted EventType = "completed" //Stats, final
+	EventFailed    EventType = "failed"    //Stats (final), or Error if the job failed before
+	EventCancelled EventType = "cancelled" //Stats, the attempt in progress was cancelled on shutdown. Not final, the job is resumed
+	EventError     EventType = "error"     //Error
+
+	// Replies to the Requests of the user feed
+	EventSubscribed   EventType = "subscribed"   //Subscription
+	EventUnsubscribed EventType = "unsubscribed" //Subscription
+)
+
+// Statuses of a job, in Stats
+const (
+	StatusStarted    = "Started"
+	StatusInProgress = "In Progress"
+	StatusRetrying   = "Retrying" //an attempt failed, the job will be retried
+	StatusCompleted  = "Completed"
+	StatusFailed     = "Failed"
+	StatusCancelled  = "Cancelled" //the attempt was cancelled on shutdown, the job will be resumed from its checkpoint
+)
+
+// Keys of Stats.LogLevelCounts. Lower case for every message; before envelopes (Version 1), the message
+// sent for jobs already completed when subscribing used "ERROR", "WARN" and "INFO".
+const (
+	LogLevelError = "error"
+	LogLevelWarn  = "warn"
+	LogLevelInfo  = "info"
+)
+
+// Close codes of the sockets, besides the standard ones
+// (1000 when the job has ended, 1001 when the server shuts down, 1011 on internal errors).
+const (
+	CloseTokenExpired = 4401 //the token the socket was opened with has expired
+	CloseJobNotFound  = 4404
+)
+
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    EventType       `json:"type"`
+	JobID   string          `json:"jobId,omitempty"`
+	Seq     int64           `json:"seq,omitempty"` //increasing across the updates of a job, zero for messages not from its updates
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func NewEnvelope(eventType EventType, jobID string, seq int64, payload any) (Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("Error marshalling %s payload: %v", eventType, err)
+	}
+	return Envelope{
+		Version: Version,
+		Type:    eventType,
+		JobID:   jobID,
+		Seq:     seq,
+		Payload: data,
+	}, nil
+}
+
+// Decode unmarshals the payload into v, which should be of the type documented for e.Type.
+func (e Envelope) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Stats is the status and the metrics so far of a job.
+type Stats struct {
+	JobID              string         `json:"jobID"`
+	Progress           float64        `json:"progressInPercentage"`
+	UniqueIPs          int            `json:"uniqueIPs"`
+	InvalidLogs        int            `json:"invalidLogs"`
+	TotalLogsProcessed int            `json:"totalLogsProcessed"`
+	Status             string         `json:"status"`
+	LogLevelCounts     map[string]int `json:"logLevelCounts"` //by LogLevelError, LogLevelWarn and LogLevelInfo
+	KeyWordCounts      map[string]int `json:"keyWordCounts"`
+}
+
+// EventTypeOf returns the type of the event carrying stats. final tells whether it's the last status of the job.
+func EventTypeOf(stats Stats, final bool) EventType {
+	if stats.Status == StatusCancelled {
+		return EventCancelled
+	}
+	if !final {
+		return EventProgress
+	}
+	switch stats.Status {
+	case StatusFailed:
+		return EventFailed
+	default:
+		return EventCompleted
+	}
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes
+const (
+	ErrCodeJobNotFound    = "JOB_NOT_FOUND"
+	ErrCodeAttemptsFailed = "ATTEMPTS_EXHAUSTED"
+	ErrCodeInvalidRequest = "INVALID_REQUEST"
+	ErrCodeInternal       = "INTERNAL_ERROR"
+)
+
+// Actions of Requests
+const (
+	ActionSubscribe   = "subscribe"
+	ActionUnsubscribe = "unsubscribe"
+)
+
+// Request is sent by clients of the user feed.
+type Request struct {
+	Action string   `json:"action"`
+	JobIDs []string `json:"jobIDs"`
+}
+
+type Subscription struct {
+	JobIDs []string `json:"jobIDs"`
+}
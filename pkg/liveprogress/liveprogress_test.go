@@ -0,0 +1,32 @@
+package liveprogress
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	stats := Stats{JobID: "job", Progress: 40, Status: StatusInProgress, LogLevelCounts: map[string]int{"error": 2}}
+	envelope, err := NewEnvelope(EventProgress, "job", 7, stats)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(envelope)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"v":1,"type":"progress","jobId":"job","seq":7,"payload":{"jobID":"job","progressInPercentage":40,
+		"uniqueIPs":0,"invalidLogs":0,"totalLogsProcessed":0,"status":"In Progress","logLevelCounts":{"error":2},"keyWordCounts":null}}`, string(data))
+
+	var received Envelope
+	assert.NoError(t, json.Unmarshal(data, &received))
+	var decoded Stats
+	assert.NoError(t, received.Decode(&decoded))
+	assert.Equal(t, stats, decoded)
+}
+
+func TestEventTypeOf(t *testing.T) {
+	assert.Equal(t, EventProgress, EventTypeOf(Stats{Status: StatusRetrying}, false))
+	assert.Equal(t, EventCompleted, EventTypeOf(Stats{Status: StatusCompleted}, true))
+	assert.Equal(t, EventFailed, EventTypeOf(Stats{Status: StatusFailed}, true))
+	assert.Equal(t, EventCancelled, EventTypeOf(Stats{Status: StatusCancelled}, false))
+}